@@ -3,27 +3,249 @@ package api
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
-	"github.com/goteleport-interview/fs4/api/handlers"
+	"github.com/quic-go/quic-go/http3"
 
-	"github.com/rs/cors"
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/checksum"
+	"github.com/goteleport-interview/fs4/api/dirsize"
+	"github.com/goteleport-interview/fs4/api/diskusage"
+	"github.com/goteleport-interview/fs4/api/handlers"
+	"github.com/goteleport-interview/fs4/api/metrics"
+	"github.com/goteleport-interview/fs4/api/quota"
+	"github.com/goteleport-interview/fs4/api/shares"
+	"github.com/goteleport-interview/fs4/api/thumbnail"
+	"github.com/goteleport-interview/fs4/api/trash"
+	"github.com/goteleport-interview/fs4/api/versions"
+	"github.com/goteleport-interview/fs4/api/watch"
 )
 
 // Server serves the directory browser API and webapp.
 type Server struct {
-	handler http.Handler
+	handler     http.Handler
+	baseDir     string
+	authBackend handlers.AuthBackend
+	auditLog    *audit.Logger
+	logger      *slog.Logger
+	metrics     *metrics.Metrics
+	events      *watch.Hub
+	thumbnails  *thumbnail.Generator
+	checksums   *checksum.Cache
+	dirSizes    *dirsize.Calculator
+	diskUsage   *diskusage.Analyzer
+	trash       *trash.Trash
+	versions    *versions.Store
+	shares      *shares.Store
+	quotas      *quota.Tracker
+	certs       atomic.Pointer[CertificateSource]
+	http3       atomic.Pointer[http3.Server]
+	draining    atomic.Bool
+	listeners   listeners
+	cors        CORSOptions
+	headers     SecurityHeaders
+	// trustedProxies may set X-Forwarded-For and X-Forwarded-Proto
+	trustedProxies []netip.Prefix
+	tls            TLSOptions
+	limits         Limits
+	// drainDelay is how long Shutdown reports not ready before closing listeners
+	drainDelay time.Duration
+	// trashRetention is how long deleted items are kept, forever if zero
+	trashRetention time.Duration
+	// versionRetention limits the versions kept of overwritten files, none if MaxCount is zero
+	versionRetention versions.Retention
+	// quotaLimits apply to users without their own entry in userQuotaLimits
+	quotaLimits     quota.Limits
+	userQuotaLimits map[string]quota.Limits
+	// dirSizeOptions configures calculating directory sizes, disabled if Workers is zero
+	dirSizeOptions dirsize.Options
+	// diskUsageWorkers read directories for disk usage analyses, which are stopped after
+	// diskUsageTimeout unless it is zero
+	diskUsageWorkers int
+	diskUsageTimeout time.Duration
+}
+
+// TLSOptions configures the protocol settings of the TLS listener.
+type TLSOptions struct {
+	MinVersion uint16
+	// CipherSuites applies to TLS 1.2 only.
+	CipherSuites []uint16
+	// NextProtos are extra ALPN protocols to offer, such as the ACME tls-alpn-01 protocol.
+	// HTTP/2 and HTTP/1.1 are always offered.
+	NextProtos []string
+	// ClientAuth sets whether client certificates are requested and verified against ClientCAs.
+	// Clients with a verified certificate are authenticated as the user it names.
+	ClientAuth tls.ClientAuthType
+	ClientCAs  *x509.CertPool
+}
+
+// Limits configures request size and timeout limits.
+type Limits struct {
+	// MaxRequestBody is the maximum size in bytes of a request body, other than uploads.
+	MaxRequestBody    int64
+	MaxHeaderBytes    int
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+	// MaxUploadSize is the maximum size in bytes of an uploaded file.
+	MaxUploadSize int64
+}
+
+// DefaultTLSOptions allows TLS 1.2 and 1.3 with forward-secret AEAD cipher suites.
+var DefaultTLSOptions = TLSOptions{
+	MinVersion: tls.VersionTLS12,
+	CipherSuites: []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,         // uint16 = 0xc02f
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,       // uint16 = 0xc02b
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,         // uint16 = 0xc030
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,       // uint16 = 0xc02c
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,   // uint16 = 0xcca8
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, // uint16 = 0xcca9
+	},
+}
+
+// DefaultLimits are the limits used if none are configured.
+var DefaultLimits = Limits{
+	MaxRequestBody:    1 << 20,
+	MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	ReadHeaderTimeout: 10 * time.Second,
+	IdleTimeout:       2 * time.Minute,
+	MaxUploadSize:     1 << 30,
+}
+
+// checksumCacheSize is the number of files whose checksums are cached.
+const checksumCacheSize = 10000
+
+// dirSizeCacheSize is the number of directories whose sizes are cached.
+const dirSizeCacheSize = 10000
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithAuditLog records authentication and file access events to the given audit log,
+// and exposes it to admins via /api/v1/audit.
+func WithAuditLog(l *audit.Logger) Option {
+	return func(s *Server) {
+		s.auditLog = l
+	}
+}
+
+// WithLogger sets the logger used for per-request access logs.
+// If not set, slog.Default() is used.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithTLSOptions sets the protocol settings used by ListenAndServeTLS.
+func WithTLSOptions(opts TLSOptions) Option {
+	return func(s *Server) {
+		s.tls = opts
+	}
+}
+
+// WithLimits sets request size and timeout limits.
+func WithLimits(limits Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithDrainDelay makes Shutdown report the server as not ready for delay before it stops
+// accepting connections, so that load balancers polling /readyz stop routing to it first.
+func WithDrainDelay(delay time.Duration) Option {
+	return func(s *Server) {
+		s.drainDelay = delay
+	}
+}
+
+// WithThumbnails serves image thumbnails from the given generator via /api/v1/thumbnail.
+func WithThumbnails(g *thumbnail.Generator) Option {
+	return func(s *Server) {
+		s.thumbnails = g
+	}
+}
+
+// WithTrashRetention permanently removes items from the trash once they were deleted longer
+// ago than retention. Items are kept until purged by their owner if it is zero.
+func WithTrashRetention(retention time.Duration) Option {
+	return func(s *Server) {
+		s.trashRetention = retention
+	}
+}
+
+// WithVersions keeps the previous contents of files overwritten by uploads, within retention,
+// and serves them via /api/v1/versions. Nothing is kept if retention.MaxCount is zero.
+func WithVersions(retention versions.Retention) Option {
+	return func(s *Server) {
+		s.versionRetention = retention
+	}
+}
+
+// WithQuotas limits the storage each user's uploads may use, by limits unless the user has
+// their own entry in users.
+func WithQuotas(limits quota.Limits, users map[string]quota.Limits) Option {
+	return func(s *Server) {
+		s.quotaLimits = limits
+		s.userQuotaLimits = users
+	}
+}
+
+// WithDirSizes calculates the total size of directories in the background, adding them to
+// listings and serving them via /api/v1/dirsize. Sizes are not calculated if opts.Workers is
+// zero.
+func WithDirSizes(opts dirsize.Options) Option {
+	return func(s *Server) {
+		s.dirSizeOptions = opts
+	}
+}
+
+// WithDiskUsage analyses disk usage for admins via /api/v1/diskusage, reading directories on
+// up to workers goroutines shared by all analyses, and stopping analyses that take longer
+// than timeout unless it is zero.
+func WithDiskUsage(workers int, timeout time.Duration) Option {
+	return func(s *Server) {
+		s.diskUsageWorkers = workers
+		s.diskUsageTimeout = timeout
+	}
 }
 
 // NewServer creates a directory browser server.
 // It serves webassets from the provided filesystem.
-func NewServer(webassets fs.FS, baseDir string, authBackend handlers.AuthBackend) (*Server, error) {
+func NewServer(webassets fs.FS, baseDir string, authBackend handlers.AuthBackend, opts ...Option) (*Server, error) {
 	mux := http.NewServeMux()
-	s := &Server{handler: mux}
+	s := &Server{
+		handler:     mux,
+		baseDir:     baseDir,
+		authBackend: authBackend,
+		logger:      slog.Default(),
+		metrics:     metrics.New(authBackend),
+		cors:        DefaultCORSOptions,
+		headers:     DefaultSecurityHeaders,
+		tls:         DefaultTLSOptions,
+		limits:      DefaultLimits,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.openState(); err != nil {
+		return nil, err
+	}
+
+	// Health probes
+	mux.Handle("GET /healthz", s.healthHandler(false))
+	mux.Handle("GET /readyz", s.healthHandler(true))
 
 	// API routes
 	mux.Handle("POST /api/v1/auth/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -32,8 +254,41 @@ func NewServer(webassets fs.FS, baseDir string, authBackend handlers.AuthBackend
 	mux.Handle("POST /api/v1/auth/logout", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		handlers.LogoutHandler(w, r, authBackend)
 	}))
-	mux.Handle("GET /api/v1/auth/me", handlers.RequireAuth(http.HandlerFunc(handlers.MeHandler), authBackend))
-	mux.Handle("POST /api/v1/files", handlers.RequireAuth(http.HandlerFunc(handlers.FilesHandler(baseDir)), authBackend))
+	mux.Handle("GET /api/v1/auth/me", handlers.RequireAuth(handlers.MeHandler(s.quotas), authBackend))
+	mux.Handle("POST /api/v1/files", handlers.RequireAuth(http.HandlerFunc(handlers.FilesHandler(baseDir, s.checksums, s.versions, s.dirSizes)), authBackend))
+	mux.Handle("PUT /api/v1/files", handlers.RequireAuth(handlers.UploadHandler(baseDir, s.versions, s.quotas), authBackend))
+	mux.Handle("DELETE /api/v1/files", handlers.RequireAuth(handlers.DeleteHandler(baseDir, s.trash, s.quotas), authBackend))
+	mux.Handle("GET /api/v1/trash", handlers.RequireAuth(handlers.TrashHandler(s.trash), authBackend))
+	mux.Handle("DELETE /api/v1/trash", handlers.RequireAuth(handlers.PurgeHandler(s.trash), authBackend))
+	mux.Handle("DELETE /api/v1/trash/{id}", handlers.RequireAuth(handlers.PurgeHandler(s.trash), authBackend))
+	mux.Handle("POST /api/v1/trash/{id}/restore", handlers.RequireAuth(handlers.RestoreHandler(baseDir, s.trash, s.quotas), authBackend))
+	mux.Handle("POST /api/v1/shares", handlers.RequireAuth(handlers.CreateShareHandler(baseDir, s.shares), authBackend))
+	mux.Handle("GET /api/v1/shares", handlers.RequireAuth(handlers.SharesHandler(s.shares), authBackend))
+	mux.Handle("DELETE /api/v1/shares/{id}", handlers.RequireAuth(handlers.RevokeShareHandler(s.shares), authBackend))
+	mux.Handle("GET /api/v1/archive", handlers.RequireAuth(handlers.ArchiveHandler(baseDir), authBackend))
+	mux.Handle("GET /api/v1/download", handlers.RequireAuth(handlers.DownloadHandler(baseDir, s.checksums), authBackend))
+	mux.Handle("GET /api/v1/checksum", handlers.RequireAuth(handlers.ChecksumHandler(baseDir, s.checksums), authBackend))
+	mux.Handle("GET /api/v1/events", handlers.RequireAuth(handlers.EventsHandler(baseDir, s.events, authBackend), authBackend))
+	if s.versions != nil {
+		mux.Handle("GET /api/v1/versions", handlers.RequireAuth(handlers.VersionsHandler(baseDir, s.versions), authBackend))
+		mux.Handle("GET /api/v1/versions/{id}", handlers.RequireAuth(handlers.VersionDownloadHandler(baseDir, s.versions), authBackend))
+		mux.Handle("POST /api/v1/versions/{id}/restore", handlers.RequireAuth(handlers.VersionRestoreHandler(baseDir, s.versions), authBackend))
+	}
+	if s.dirSizes != nil {
+		mux.Handle("GET /api/v1/dirsize", handlers.RequireAuth(handlers.DirSizeHandler(baseDir, s.dirSizes), authBackend))
+	}
+	if s.thumbnails != nil {
+		mux.Handle("GET /api/v1/thumbnail", handlers.RequireAuth(handlers.ThumbnailHandler(baseDir, s.thumbnails), authBackend))
+	}
+	mux.Handle("GET /api/v1/diskusage", handlers.RequireAuth(handlers.RequireAdmin(handlers.DiskUsageHandler(baseDir, s.diskUsage, s.diskUsageTimeout), authBackend), authBackend))
+	mux.Handle("POST /api/v1/quota/recalculate", handlers.RequireAuth(handlers.RequireAdmin(handlers.QuotaRecalculateHandler(s.quotas), authBackend), authBackend))
+	if s.auditLog != nil {
+		mux.Handle("GET /api/v1/audit", handlers.RequireAuth(handlers.RequireAdmin(handlers.AuditHandler(s.auditLog), authBackend), authBackend))
+	}
+
+	// Share links, which need no account
+	mux.Handle("GET /s/{token}", handlers.SharedHandler(baseDir, s.shares))
+	mux.Handle("GET /s/{token}/download", handlers.SharedDownloadHandler(baseDir, s.shares, s.checksums))
 
 	// Fall back to 404 for any unknown /api routes
 	mux.Handle("/api/", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -57,41 +312,98 @@ func NewServer(webassets fs.FS, baseDir string, authBackend handlers.AuthBackend
 		}
 	}))
 
-	// CORS :)
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000"},
-		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "POST"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-	})
-
-	s.handler = c.Handler(mux)
+	var handler http.Handler = audit.WithLogger(limitRequestBody(mux, s.limits), s.auditLog)
+	handler = s.cors.handler(handler)
+	handler = s.headers.handler(handler)
+	handler = s.advertiseHTTP3(handler)
+	handler = recordMetrics(handler, mux, s.metrics)
+	handler = logRequests(handler, mux, s.logger)
+	s.handler = s.forwardedHeaders(handler)
 	return s, nil
 }
 
-// ListenAndServeTLS starts the server on the specified address.
-func (s *Server) ListenAndServeTLS(addr string, cert tls.Certificate) error {
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
-		MaxVersion:   tls.VersionTLS13,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,         // uint16 = 0xc02f
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,       // uint16 = 0xc02b
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,         // uint16 = 0xc030
-			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,       // uint16 = 0xc02c
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,   // uint16 = 0xcca8
-			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, // uint16 = 0xcca9
-		},
+// openState creates the watchers, caches and stores backing the API, once the options are applied.
+func (s *Server) openState() error {
+	s.events = watch.NewHub(s.baseDir, s.logger)
+	s.checksums = checksum.NewCache(checksumCacheSize)
+	if s.dirSizeOptions.Workers > 0 {
+		opts := s.dirSizeOptions
+		opts.MaxCached = dirSizeCacheSize
+		opts.Exclude = filepath.Join(s.baseDir, handlers.StateDir)
+		s.dirSizes = dirsize.New(s.baseDir, s.events, opts, s.logger)
 	}
+	s.diskUsage = diskusage.New(s.baseDir, s.diskUsageWorkers, filepath.Join(s.baseDir, handlers.StateDir))
+	s.trash = trash.New(s.baseDir, filepath.Join(s.baseDir, handlers.StateDir, "trash"), s.trashRetention, s.logger)
+	if s.versionRetention.MaxCount > 0 {
+		s.versions = versions.New(s.baseDir, filepath.Join(s.baseDir, handlers.StateDir, "versions"), s.versionRetention, s.logger)
+	}
+	var err error
+	if s.shares, err = shares.Open(filepath.Join(s.baseDir, handlers.StateDir, "shares.json")); err != nil {
+		return err
+	}
+	s.quotas, err = quota.Open(s.baseDir, filepath.Join(s.baseDir, handlers.StateDir, "quota.json"), s.quotaLimits, s.userQuotaLimits, s.logger)
+	return err
+}
+
+// CertificateSource provides certificates to the TLS listener, such as a *tlscert.Manager.
+type CertificateSource interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// ListenAndServeTLS starts the server on the specified TCP address, serving certificates from certs.
+// It returns http.ErrServerClosed once Shutdown has been called.
+func (s *Server) ListenAndServeTLS(addr string, certs CertificateSource) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l, certs)
+}
+
+// tlsConfig returns the TLS settings shared by the TCP and QUIC listeners.
+func (s *Server) tlsConfig(certs CertificateSource) *tls.Config {
+	return &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     s.tls.MinVersion,
+		MaxVersion:     tls.VersionTLS13,
+		CipherSuites:   s.tls.CipherSuites,
+		ClientAuth:     s.tls.ClientAuth,
+		ClientCAs:      s.tls.ClientCAs,
+	}
+}
+
+// ListenAndServeMetrics serves Prometheus metrics on /metrics at the specified address,
+// along with the /healthz and /readyz probes.
+// The listener is separate from the main one and uses plain HTTP, so it should be bound
+// to an internal interface. If bearerToken is non-empty, scrapers must present it.
+func (s *Server) ListenAndServeMetrics(addr string, bearerToken string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", s.metrics.Handler(bearerToken))
+	mux.Handle("GET /healthz", s.healthHandler(false))
+	mux.Handle("GET /readyz", s.healthHandler(true))
 
 	server := &http.Server{
-		Addr:      addr,
-		Handler:   s.handler,
-		TLSConfig: tlsConfig,
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	return server.ListenAndServeTLS("", "")
+	return s.listeners.serve(server, server.ListenAndServe)
+}
+
+// limitRequestBody is middleware capping the size of request bodies. Uploads, the only PUT
+// requests, have their own limit.
+func limitRequestBody(next http.Handler, limits Limits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBytes := limits.MaxRequestBody
+		if r.Method == http.MethodPut {
+			maxBytes = limits.MaxUploadSize
+		}
+		if maxBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 func extractIndexHTML(fs http.FileSystem) ([]byte, error) {
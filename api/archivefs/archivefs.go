@@ -0,0 +1,416 @@
+// Package archivefs reads ZIP and tar archives as read-only directory trees, so that they
+// can be browsed like the directories they contain.
+//
+// Archives come from untrusted users, so member names are confined to the archive: names
+// with ".." elements are skipped rather than cleaned into something else. Only regular files
+// and directories are exposed. Limits bound the work done for an archive, so that an archive
+// with huge numbers of entries or a compression bomb cannot exhaust the server.
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for names that do not exist in the archive.
+	ErrNotFound = errors.New("not found in archive")
+	// ErrNotDir is returned when listing a file.
+	ErrNotDir = errors.New("not a directory")
+	// ErrIsDir is returned when opening a directory.
+	ErrIsDir = errors.New("is a directory")
+	// ErrTooLarge is returned when an archive or member exceeds the Limits.
+	ErrTooLarge = errors.New("archive exceeds size limits")
+	// ErrUnsupported is returned when opening a file that is not a supported archive.
+	ErrUnsupported = errors.New("unsupported archive format")
+)
+
+// Limits bounds the resources used for an archive.
+type Limits struct {
+	// MaxEntries is the number of members an archive may have.
+	MaxEntries int
+	// MaxScanBytes is the number of uncompressed bytes read from a tar archive to list it or
+	// find a member, not counting the member then read. Uncompressed tar archives are seeked
+	// past the contents of members, so only their headers count. ZIP archives have an index,
+	// so need not be read.
+	MaxScanBytes int64
+	// MaxFileSize is the uncompressed size of a member that may be opened.
+	MaxFileSize int64
+}
+
+// DefaultLimits are the limits used if none are set.
+var DefaultLimits = Limits{
+	MaxEntries:   10000,
+	MaxScanBytes: 1 << 30,
+	MaxFileSize:  4 << 30,
+}
+
+type format int
+
+const (
+	formatZip format = iota
+	formatTar
+	formatTarGzip
+)
+
+// formats maps file extensions to archive formats.
+var formats = []struct {
+	extension string
+	format    format
+}{
+	{".zip", formatZip},
+	{".tar", formatTar},
+	{".tar.gz", formatTarGzip},
+	{".tgz", formatTarGzip},
+}
+
+func formatOf(name string) (format, bool) {
+	name = strings.ToLower(name)
+	for _, f := range formats {
+		if strings.HasSuffix(name, f.extension) {
+			return f.format, true
+		}
+	}
+	return 0, false
+}
+
+// Supported reports whether name has the extension of a supported archive format.
+func Supported(name string) bool {
+	_, ok := formatOf(name)
+	return ok
+}
+
+// Entry describes a file or directory in an archive.
+type Entry struct {
+	Name     string
+	Dir      bool
+	Size     int64
+	Modified time.Time
+}
+
+type node struct {
+	Entry
+	children map[string]*node
+	// zipFile is the member of a ZIP archive.
+	zipFile *zip.File
+	// tarIndex is the position of the member's header in a tar archive.
+	tarIndex int
+}
+
+// Archive is an open archive.
+type Archive struct {
+	path   string
+	format format
+	limits Limits
+	root   *node
+	zip    *zip.ReadCloser
+}
+
+// Open reads the index of the archive at name, which must have the extension of a supported
+// format. The archive must be closed after use.
+func Open(name string, limits Limits) (*Archive, error) {
+	f, ok := formatOf(name)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		path:   name,
+		format: f,
+		limits: limits,
+		root:   &node{Entry: Entry{Name: filepath.Base(name), Dir: true, Modified: info.ModTime()}, children: map[string]*node{}},
+	}
+	if f == formatZip {
+		err = a.indexZip()
+	} else {
+		err = a.indexTar()
+	}
+	if err != nil {
+		_ = a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Archive) indexZip() error {
+	r, err := zip.OpenReader(a.path)
+	// insecure names are reported only if enabled with GODEBUG, and are skipped below anyway
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return err
+	}
+	a.zip = r
+	if len(r.File) > a.limits.MaxEntries {
+		return ErrTooLarge
+	}
+
+	for _, f := range r.File {
+		info := f.FileInfo()
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			// links could point anywhere
+			continue
+		}
+		n := a.add(f.Name, info.IsDir())
+		if n == nil || n.Dir {
+			continue
+		}
+		n.Size = int64(f.UncompressedSize64)
+		n.Modified = f.Modified
+		n.zipFile = f
+	}
+	return nil
+}
+
+func (a *Archive) indexTar() error {
+	return a.scanTar(func(index int, header *tar.Header) bool {
+		if index >= a.limits.MaxEntries {
+			return false
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir {
+			// links could point anywhere, and devices have no contents
+			return true
+		}
+		n := a.add(header.Name, header.Typeflag == tar.TypeDir)
+		if n != nil {
+			n.Modified = header.ModTime
+		}
+		if n != nil && !n.Dir {
+			n.Size = header.Size
+			n.tarIndex = index
+		}
+		return true
+	})
+}
+
+// scanTar calls fn with each header in the tar archive. If fn returns false, scanning stops
+// with ErrTooLarge.
+func (a *Archive) scanTar(fn func(index int, header *tar.Header) bool) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	// nolint:errcheck
+	defer f.Close()
+
+	tr, _, err := a.tarReader(f)
+	if err != nil {
+		return err
+	}
+	for index := 0; ; index++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(index, header) {
+			return ErrTooLarge
+		}
+	}
+}
+
+// tarReader returns a reader for the tar archive in f, stopping with ErrTooLarge once
+// MaxScanBytes have been read, and the limit it reads through.
+func (a *Archive) tarReader(f *os.File) (*tar.Reader, *limitedReader, error) {
+	if a.format == formatTar {
+		limit := &limitedReader{r: f, remaining: a.limits.MaxScanBytes}
+		// the tar reader seeks past member contents it is not asked to read
+		return tar.NewReader(seekingReader{limit, f}), limit, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit := &limitedReader{r: gz, remaining: a.limits.MaxScanBytes}
+	return tar.NewReader(limit), limit, nil
+}
+
+// add adds the member name to the tree, creating its parent directories, and returns its
+// node. Names that would leave the archive, or clash with an existing entry of another
+// kind, are skipped and nil is returned.
+func (a *Archive) add(name string, dir bool) *node {
+	name = strings.ReplaceAll(name, `\`, "/")
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return nil
+		}
+	}
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+
+	parent := a.root
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		child, ok := parent.children[elem]
+		if !ok {
+			child = &node{Entry: Entry{Name: elem, Dir: true, Modified: a.root.Modified}, children: map[string]*node{}}
+			parent.children[elem] = child
+		}
+		if !child.Dir {
+			return nil
+		}
+		parent = child
+	}
+
+	base := elems[len(elems)-1]
+	if existing, ok := parent.children[base]; ok {
+		if existing.Dir != dir {
+			return nil
+		}
+		return existing
+	}
+	n := &node{Entry: Entry{Name: base, Dir: dir}}
+	if dir {
+		n.children = map[string]*node{}
+	}
+	parent.children[base] = n
+	return n
+}
+
+func (a *Archive) lookup(name string) (*node, error) {
+	n := a.root
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return n, nil
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if !n.Dir {
+			return nil, ErrNotFound
+		}
+		child, ok := n.children[elem]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// Stat describes the member name, a slash-separated path inside the archive. The empty
+// name and "/" are the archive itself.
+func (a *Archive) Stat(name string) (Entry, error) {
+	n, err := a.lookup(name)
+	if err != nil {
+		return Entry{}, err
+	}
+	return n.Entry, nil
+}
+
+// ReadDir lists the directory name, sorted by name.
+func (a *Archive) ReadDir(name string) ([]Entry, error) {
+	n, err := a.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.Dir {
+		return nil, ErrNotDir
+	}
+
+	entries := make([]Entry, 0, len(n.children))
+	for _, child := range n.children {
+		entries = append(entries, child.Entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// Open opens the file name for reading. At most the member's size is read, and members
+// larger than MaxFileSize cannot be opened.
+func (a *Archive) Open(name string) (io.ReadCloser, error) {
+	n, err := a.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if n.Dir {
+		return nil, ErrIsDir
+	}
+	if n.Size > a.limits.MaxFileSize {
+		return nil, ErrTooLarge
+	}
+
+	if n.zipFile != nil {
+		// the zip reader checks the declared size, so a member cannot expand beyond it
+		rc, err := n.zipFile.Open()
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{io.LimitReader(rc, n.Size), rc}, nil
+	}
+	return a.openTar(n)
+}
+
+func (a *Archive) openTar(n *node) (io.ReadCloser, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	tr, limit, err := a.tarReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	for index := 0; index <= n.tarIndex; index++ {
+		if _, err := tr.Next(); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	// only finding the member counts towards MaxScanBytes, which would otherwise stop large
+	// members partway through
+	limit.remaining += n.Size
+	return readCloser{io.LimitReader(tr, n.Size), f}, nil
+}
+
+// Close releases the archive.
+func (a *Archive) Close() error {
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+	return nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// seekingReader reads through a limit, but seeks without one.
+type seekingReader struct {
+	*limitedReader
+	io.Seeker
+}
+
+// limitedReader reads from r until remaining bytes have been read, then fails with ErrTooLarge.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,271 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// members are written to each test archive, in order. A nil value is a directory.
+var members = []struct {
+	name    string
+	content []byte
+}{
+	{"docs/", nil},
+	{"docs/readme.txt", []byte("readme")},
+	{"docs/specs/orbit.txt", []byte("orbit")},
+	{"../evil.txt", []byte("evil")},
+	{"docs/../../evil.txt", []byte("evil")},
+	{"/abs.txt", []byte("abs")},
+	{`win\path.txt`, []byte("win")},
+}
+
+var modified = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func writeZip(t *testing.T, name string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	// nolint:errcheck
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, m := range members {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Modified: modified, Method: zip.Deflate})
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", m.name, err)
+		}
+		if _, err := w.Write(m.content); err != nil {
+			t.Fatalf("failed to write %s: %v", m.name, err)
+		}
+	}
+	link := &zip.FileHeader{Name: "link"}
+	link.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(link)
+	if err != nil {
+		t.Fatalf("failed to add link: %v", err)
+	}
+	if _, err := w.Write([]byte("/etc/passwd")); err != nil {
+		t.Fatalf("failed to write link: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return p
+}
+
+func writeTar(t *testing.T, name string, compress bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		header := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.content)), ModTime: modified, Typeflag: tar.TypeReg}
+		if m.content == nil {
+			header.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("failed to add %s: %v", m.name, err)
+		}
+		if _, err := tw.Write(m.content); err != nil {
+			t.Fatalf("failed to write %s: %v", m.name, err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}); err != nil {
+		t.Fatalf("failed to add link: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+
+	data := buf.Bytes()
+	if compress {
+		var gzBuf bytes.Buffer
+		gz := gzip.NewWriter(&gzBuf)
+		if _, err := gz.Write(data); err != nil {
+			t.Fatalf("failed to compress archive: %v", err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatalf("failed to compress archive: %v", err)
+		}
+		data = gzBuf.Bytes()
+	}
+
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	return p
+}
+
+func names(entries []Entry) []string {
+	var result []string
+	for _, e := range entries {
+		result = append(result, e.Name)
+	}
+	return result
+}
+
+func TestArchive(t *testing.T) {
+	for name, path := range map[string]string{
+		"zip":    writeZip(t, "test.zip"),
+		"tar":    writeTar(t, "test.tar", false),
+		"tar.gz": writeTar(t, "test.TGZ", true),
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, err := Open(path, DefaultLimits)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// nolint:errcheck
+			defer a.Close()
+
+			root, err := a.ReadDir("")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			// the traversal names and the symlink are left out
+			if expected := []string{"abs.txt", "docs", "win"}; !reflect.DeepEqual(names(root), expected) {
+				t.Errorf("expected %v, got %v", expected, names(root))
+			}
+
+			docs, err := a.ReadDir("/docs/")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if expected := []string{"readme.txt", "specs"}; !reflect.DeepEqual(names(docs), expected) {
+				t.Errorf("expected %v, got %v", expected, names(docs))
+			}
+			if !docs[1].Dir {
+				t.Error("expected implied directory specs to be a directory")
+			}
+
+			entry, err := a.Stat("docs/specs/orbit.txt")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if entry.Dir || entry.Size != 5 || !entry.Modified.Equal(modified) {
+				t.Errorf("unexpected entry %+v", entry)
+			}
+
+			rc, err := a.Open("docs/specs/orbit.txt")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			data, err := io.ReadAll(rc)
+			_ = rc.Close()
+			if err != nil || string(data) != "orbit" {
+				t.Errorf("expected orbit, got %q (%v)", data, err)
+			}
+
+			if _, err := a.Open("docs"); !errors.Is(err, ErrIsDir) {
+				t.Errorf("expected ErrIsDir, got %v", err)
+			}
+			if _, err := a.ReadDir("docs/readme.txt"); !errors.Is(err, ErrNotDir) {
+				t.Errorf("expected ErrNotDir, got %v", err)
+			}
+			for _, missing := range []string{"missing", "link", "../evil.txt", "docs/readme.txt/x"} {
+				if _, err := a.Stat(missing); !errors.Is(err, ErrNotFound) {
+					t.Errorf("expected ErrNotFound for %s, got %v", missing, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	zipPath := writeZip(t, "test.zip")
+	tarPath := writeTar(t, "test.tar.gz", true)
+
+	for _, path := range []string{zipPath, tarPath} {
+		if _, err := Open(path, Limits{MaxEntries: 3, MaxScanBytes: 1 << 20, MaxFileSize: 1 << 20}); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge for too many entries in %s, got %v", filepath.Base(path), err)
+		}
+
+		a, err := Open(path, Limits{MaxEntries: 100, MaxScanBytes: 1 << 20, MaxFileSize: 5})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := a.Open("docs/readme.txt"); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge for large member in %s, got %v", filepath.Base(path), err)
+		}
+		_ = a.Close()
+	}
+
+	if _, err := Open(tarPath, Limits{MaxEntries: 100, MaxScanBytes: 1024, MaxFileSize: 1 << 20}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge for scanning too much, got %v", err)
+	}
+}
+
+func TestLargeTarMember(t *testing.T) {
+	large := []struct {
+		name    string
+		content []byte
+	}{
+		{"big.bin", bytes.Repeat([]byte("x"), 4096)},
+		{"small.txt", []byte("small")},
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range large {
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("failed to add %s: %v", m.name, err)
+		}
+		if _, err := tw.Write(m.content); err != nil {
+			t.Fatalf("failed to write %s: %v", m.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "large.tar")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	// members larger than the scan limit are skipped while scanning, and read in full
+	a, err := Open(path, Limits{MaxEntries: 100, MaxScanBytes: 3072, MaxFileSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// nolint:errcheck
+	defer a.Close()
+	for _, m := range large {
+		rc, err := a.Open(m.name)
+		if err != nil {
+			t.Fatalf("expected no error opening %s, got %v", m.name, err)
+		}
+		content, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil || !bytes.Equal(content, m.content) {
+			t.Errorf("expected %d bytes of %s, got %d (%v)", len(m.content), m.name, len(content), err)
+		}
+	}
+}
+
+func TestOpenUnsupported(t *testing.T) {
+	if Supported("notes.txt") || !Supported("orbiter.ZIP") {
+		t.Error("expected archives to be recognised by extension")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "notes.txt"), DefaultLimits); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.zip")
+	if err := os.WriteFile(corrupt, []byte("not a zip"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := Open(corrupt, DefaultLimits); err == nil {
+		t.Error("expected error opening corrupt archive")
+	}
+}
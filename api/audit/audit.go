@@ -0,0 +1,359 @@
+// Package audit records authentication and file access events to a rotating JSON-lines log.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+)
+
+// Action identifies the kind of audited event.
+type Action string
+
+const (
+	// ActionLogin is recorded for every login attempt.
+	ActionLogin Action = "login"
+	// ActionLogout is recorded when a user logs out.
+	ActionLogout Action = "logout"
+	// ActionSessionExpired is recorded when a request is made with an expired session.
+	ActionSessionExpired Action = "session_expired"
+	// ActionList is recorded when a directory listing is requested.
+	ActionList Action = "list"
+	// ActionDownload is recorded when file contents are served.
+	ActionDownload Action = "download"
+	// ActionWatch is recorded when a client subscribes to changes to a directory.
+	ActionWatch Action = "watch"
+	// ActionChecksum is recorded when checksums of a file are requested.
+	ActionChecksum Action = "checksum"
+	// ActionUpload is recorded when a file is uploaded.
+	ActionUpload Action = "upload"
+	// ActionDelete is recorded when a file or directory is moved to the trash.
+	ActionDelete Action = "delete"
+	// ActionRestore is recorded when an item is restored from the trash.
+	ActionRestore Action = "restore"
+	// ActionPurge is recorded when items are permanently removed from a user's trash.
+	ActionPurge Action = "purge"
+	// ActionShare is recorded when a user creates a share link.
+	ActionShare Action = "share"
+	// ActionRevoke is recorded when a user revokes a share link.
+	ActionRevoke Action = "revoke"
+	// ActionDiskUsage is recorded when an admin analyses the disk usage of a directory.
+	ActionDiskUsage Action = "disk_usage"
+)
+
+// Outcome is the result of an audited event.
+type Outcome string
+
+const (
+	// OutcomeSuccess means the action was allowed and completed.
+	OutcomeSuccess Outcome = "success"
+	// OutcomeFailure means the action was rejected or failed.
+	OutcomeFailure Outcome = "failure"
+)
+
+// Event is a single audit record.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Action  Action    `json:"action"`
+	Outcome Outcome   `json:"outcome"`
+	User    string    `json:"user,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// Query filters events returned by Logger.Query.
+// Zero-valued fields are not applied.
+type Query struct {
+	Since  time.Time
+	Until  time.Time
+	User   string
+	Action Action
+	Limit  int
+}
+
+// DefaultMaxSize is the size in bytes at which the log is rotated if no size is given.
+const DefaultMaxSize = 10 << 20 // 10MiB
+
+// ErrClosed is returned when writing to a closed logger.
+var ErrClosed = errors.New("audit log closed")
+
+// Logger writes audit events to a JSON-lines file, rotating it once it reaches maxSize.
+// Rotated files are kept as path.1 (newest) through path.N (oldest).
+// A nil *Logger discards all events.
+type Logger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+// NewLogger opens (or creates) the audit log at path.
+func NewLogger(path string, maxSize int64, maxBackups int) (*Logger, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+
+	l := &Logger{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not stat audit log: %w", err)
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// Log appends an event to the log.
+func (l *Logger) Log(e Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return ErrClosed
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.maxBackups == 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return l.open()
+	}
+
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(l.backupPath(i), l.backupPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.backupPath(1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *Logger) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// Query returns events matching q, oldest first.
+// If q.Limit is set, only the most recent q.Limit matches are returned.
+func (l *Logger) Query(q Query) ([]Event, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	logs, closeLogs, err := l.openLogs()
+	if err != nil {
+		return nil, err
+	}
+	defer closeLogs()
+
+	events := []Event{}
+	for _, r := range logs {
+		matched, err := readEvents(r, q)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, matched...)
+	}
+
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[len(events)-q.Limit:]
+	}
+	return events, nil
+}
+
+// openLogs opens the backups, oldest first, and the current log up to the events written so
+// far, so that they can be read without holding l.mutex and blocking Log. Files rotated once
+// opened are still read in full.
+func (l *Logger) openLogs() ([]io.Reader, func(), error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var files []*os.File
+	closeLogs := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+	logs := make([]io.Reader, 0, l.maxBackups+1)
+	for i := l.maxBackups; i >= 0; i-- {
+		path := l.path
+		if i > 0 {
+			path = l.backupPath(i)
+		}
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			closeLogs()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		if i == 0 && l.file != nil {
+			// lines still being written are not read
+			logs = append(logs, io.LimitReader(f, l.size))
+		} else {
+			logs = append(logs, f)
+		}
+	}
+	return logs, closeLogs, nil
+}
+
+func readEvents(r io.Reader, q Query) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// skip partially written or corrupt lines
+			continue
+		}
+		if q.matches(e) {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+func (q Query) matches(e Event) bool {
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if q.User != "" && e.User != q.User {
+		return false
+	}
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	return true
+}
+
+// Close flushes the log to disk and closes the underlying file.
+// Further calls to Log return ErrClosed.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := errors.Join(l.file.Sync(), l.file.Close())
+	l.file = nil
+	return err
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger stored in ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(contextKey{}).(*Logger)
+	return l
+}
+
+// WithLogger is middleware making the logger available to Record.
+func WithLogger(next http.Handler, l *Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), l)))
+	})
+}
+
+// Record logs an event for the given request.
+// The client IP is always taken from the request, and the user from the
+// request's session unless already set on the event.
+func Record(r *http.Request, e Event) {
+	l := FromContext(r.Context())
+	if l == nil {
+		return
+	}
+
+	e.IP = remoteIP(r)
+	if e.User == "" {
+		if session, ok := r.Context().Value(auth.SessionContextKey).(*auth.Session); ok && session != nil {
+			e.User = session.Username
+		}
+	}
+
+	if err := l.Log(e); err != nil {
+		log.Printf("Failed to write audit event: %v", err)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+)
+
+func TestLogAndQuery(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogger(logPath, 0, 2)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	// nolint:errcheck
+	defer l.Close()
+
+	base := time.Now().Truncate(time.Second)
+	events := []Event{
+		{Time: base.Add(-2 * time.Hour), Action: ActionLogin, Outcome: OutcomeSuccess, User: "admin"},
+		{Time: base.Add(-1 * time.Hour), Action: ActionList, Outcome: OutcomeSuccess, User: "admin", Path: "/photos"},
+		{Time: base, Action: ActionLogin, Outcome: OutcomeFailure, User: "user"},
+	}
+	for _, e := range events {
+		if err := l.Log(e); err != nil {
+			t.Fatalf("failed to log event: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected int
+	}{
+		{"no filters", Query{}, 3},
+		{"by user", Query{User: "admin"}, 2},
+		{"by action", Query{Action: ActionLogin}, 2},
+		{"since", Query{Since: base.Add(-90 * time.Minute)}, 2},
+		{"until", Query{Until: base.Add(-90 * time.Minute)}, 1},
+		{"limit", Query{Limit: 1}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.Query(tt.query)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(got) != tt.expected {
+				t.Fatalf("expected %d events, got %d", tt.expected, len(got))
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogger(logPath, 200, 1)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	// nolint:errcheck
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := l.Log(Event{Action: ActionList, Outcome: OutcomeSuccess, User: "admin", Path: "/test-dir"}); err != nil {
+			t.Fatalf("failed to log event: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(logPath + ".1"); err != nil {
+		t.Fatalf("expected rotated file to exist, got %v", err)
+	}
+	if _, err := os.Stat(logPath + ".2"); !os.IsNotExist(err) {
+		t.Fatalf("expected only one backup to be kept, got %v", err)
+	}
+
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("failed to stat log: %v", err)
+	}
+	if info.Size() > 200 {
+		t.Errorf("expected log to be at most 200 bytes, got %d", info.Size())
+	}
+}
+
+func TestQueryWhileLogging(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogger(logPath, 1000, 100)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	// nolint:errcheck
+	defer l.Close()
+
+	const total = 200
+	done := make(chan error)
+	go func() {
+		for i := 0; i < total; i++ {
+			if err := l.Log(Event{Action: ActionList, Outcome: OutcomeSuccess, User: "admin", Path: "/test-dir"}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// every query sees whole events, as rotated, and at least as many as the one before
+	seen := 0
+	for logging := true; logging; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("failed to log event: %v", err)
+			}
+			logging = false
+		default:
+		}
+		events, err := l.Query(Query{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(events) < seen || len(events) > total {
+			t.Fatalf("expected %d to %d events, got %d", seen, total, len(events))
+		}
+		seen = len(events)
+	}
+	if seen != total {
+		t.Errorf("expected %d events, got %d", total, seen)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogger(logPath, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	// nolint:errcheck
+	defer l.Close()
+
+	req := httptest.NewRequest("POST", "/api/v1/files", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	ctx := context.WithValue(NewContext(req.Context(), l), auth.SessionContextKey, &auth.Session{Username: "testuser"})
+	Record(req.WithContext(ctx), Event{Action: ActionList, Outcome: OutcomeSuccess, Path: "/"})
+
+	got, err := l.Query(Query{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(got))
+	}
+	if got[0].User != "testuser" {
+		t.Errorf("expected user 'testuser', got '%s'", got[0].User)
+	}
+	if got[0].IP != "192.0.2.1" {
+		t.Errorf("expected ip '192.0.2.1', got '%s'", got[0].IP)
+	}
+	if got[0].Time.IsZero() {
+		t.Error("expected event time to be set")
+	}
+}
@@ -2,6 +2,7 @@
 package auth
 
 import (
+	"crypto/x509"
 	"errors"
 	"net/http"
 	"sync"
@@ -13,6 +14,7 @@ import (
 )
 
 // Session represents a user session.
+// Sessions authenticated by a client certificate have no ID and are not stored by the backend.
 type Session struct {
 	ID        string
 	Username  string
@@ -23,19 +25,24 @@ type Session struct {
 type User struct {
 	Username     string
 	PasswordHash string
+	Admin        bool
 }
 
 // CookieData represents the data to be stored in a Session cookie.
 type CookieData struct {
 	ID      string
 	Expires time.Time
+	// Insecure omits the Secure attribute, for clients reaching the server over plain HTTP
+	// through a proxy. Browsers would otherwise never send the cookie back.
+	Insecure bool
 }
 
 // InMemoryBackend is an in-memory implementation of the AuthBackend interface.
 type InMemoryBackend struct {
-	users    map[string]User
-	sessions map[string]Session
-	mutex    sync.Mutex
+	users           map[string]User
+	sessions        map[string]Session
+	sessionLifetime time.Duration
+	mutex           sync.Mutex
 }
 
 type contextKey string
@@ -49,14 +56,15 @@ const SessionCookieName = "session_id"
 // SessionCookiePath is the path of the session cookie.
 const SessionCookiePath = "/"
 
-// SessionMaxAge is the maximum age of a session.
+// SessionMaxAge is the default maximum age of a session.
 const SessionMaxAge = 30 * time.Minute // 30min expiry
 
 // NewInMemoryBackend creates a new in-memory backend instance.
 func NewInMemoryBackend() *InMemoryBackend {
 	return &InMemoryBackend{
-		users:    make(map[string]User),
-		sessions: make(map[string]Session),
+		users:           make(map[string]User),
+		sessions:        make(map[string]Session),
+		sessionLifetime: SessionMaxAge,
 	}
 }
 
@@ -73,8 +81,30 @@ var (
 	ErrSessionExpired = errors.New("session expired")
 	// ErrSessionCreation is returned when a session cannot be created.
 	ErrSessionCreation = errors.New("session creation failed")
+	// ErrAdminRequired is returned when a non-admin user requests an admin-only resource.
+	ErrAdminRequired = errors.New("admin privileges required")
+	// ErrUnknownClientCert is returned when a verified client certificate does not name a known user.
+	ErrUnknownClientCert = errors.New("client certificate does not match a user")
 )
 
+// CertificateUsername returns the username a client certificate authenticates as: the subject
+// common name, or if that is empty the first email, DNS or URI subject alternative name.
+// The certificate must already have been verified against the trusted client CAs.
+func CertificateUsername(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	default:
+		return ""
+	}
+}
+
 // GetSessionByID retrieves a session by its ID.
 func (b *InMemoryBackend) GetSessionByID(id string) (*Session, error) {
 	b.mutex.Lock()
@@ -102,13 +132,22 @@ func (b *InMemoryBackend) CreateSession(username string) (*Session, error) {
 	session := Session{
 		ID:        sessionID,
 		Username:  username,
-		ExpiresAt: time.Now().Truncate(time.Second).Add(SessionMaxAge),
+		ExpiresAt: time.Now().Truncate(time.Second).Add(b.sessionLifetime),
 	}
 
 	b.sessions[sessionID] = session
 	return &session, nil
 }
 
+// SetSessionLifetime sets how long newly created sessions remain valid.
+// Existing sessions are unaffected.
+func (b *InMemoryBackend) SetSessionLifetime(lifetime time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.sessionLifetime = lifetime
+}
+
 // DeleteSession removes a session by its ID.
 func (b *InMemoryBackend) DeleteSession(id string) error {
 	b.mutex.Lock()
@@ -127,6 +166,29 @@ func (b *InMemoryBackend) UpdateSession(id string, session *Session) error {
 	return nil
 }
 
+// ActiveSessions returns the number of sessions that have not yet expired.
+func (b *InMemoryBackend) ActiveSessions() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, session := range b.sessions {
+		if session.ExpiresAt.After(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// Ping reports whether the backend is able to serve requests.
+func (b *InMemoryBackend) Ping() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return nil
+}
+
 // GetUser retrieves a user by their username.
 func (b *InMemoryBackend) GetUser(username string) (*User, error) {
 	b.mutex.Lock()
@@ -157,6 +219,37 @@ func (b *InMemoryBackend) AddUser(username, password string) error {
 	return nil
 }
 
+// AddHashedUser adds a new user whose password has already been hashed with bcrypt.
+func (b *InMemoryBackend) AddHashedUser(username, passwordHash string) error {
+	if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.users[username] = User{
+		Username:     username,
+		PasswordHash: passwordHash,
+	}
+	return nil
+}
+
+// SetAdmin grants or revokes admin privileges for an existing user.
+func (b *InMemoryBackend) SetAdmin(username string, admin bool) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	user, exists := b.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	user.Admin = admin
+	b.users[username] = user
+	return nil
+}
+
 // SetCookie is a helper for consistent cookie creation
 func SetCookie(w http.ResponseWriter, data CookieData) {
 	http.SetCookie(w, &http.Cookie{
@@ -166,6 +259,6 @@ func SetCookie(w http.ResponseWriter, data CookieData) {
 		HttpOnly: true,
 		Path:     SessionCookiePath,
 		SameSite: http.SameSiteStrictMode,
-		Secure:   true,
+		Secure:   !data.Insecure,
 	})
 }
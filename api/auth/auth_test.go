@@ -1,8 +1,11 @@
 package auth
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -182,4 +185,86 @@ func TestSetCookie(t *testing.T) {
 	if !cookie.Secure {
 		t.Error("expected cookie to be Secure")
 	}
+
+	// plain HTTP behind a proxy on the same host
+	recorder = httptest.NewRecorder()
+	cookieData.Insecure = true
+	SetCookie(recorder, cookieData)
+	if cookies := recorder.Result().Cookies(); len(cookies) != 1 || cookies[0].Secure {
+		t.Errorf("expected one cookie without Secure, got %v", cookies)
+	}
+}
+
+func TestSetAdmin(t *testing.T) {
+	backend := NewInMemoryBackend()
+	err := backend.AddUser("testuser", "password")
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	if err := backend.SetAdmin("testuser", true); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	user, err := backend.GetUser("testuser")
+	if err != nil {
+		t.Fatalf("expected to find user, got error %v", err)
+	}
+	if !user.Admin {
+		t.Error("expected user to be admin")
+	}
+
+	if err := backend.SetAdmin("nonexistentuser", true); err != ErrUserNotFound {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestActiveSessions(t *testing.T) {
+	backend := NewInMemoryBackend()
+	err := backend.AddUser("testuser", "password")
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	session, _ := backend.CreateSession("testuser")
+	_, _ = backend.CreateSession("testuser")
+
+	if got := backend.ActiveSessions(); got != 2 {
+		t.Fatalf("expected 2 active sessions, got %d", got)
+	}
+
+	err = backend.UpdateSession(session.ID, &Session{
+		ID:        session.ID,
+		Username:  session.Username,
+		ExpiresAt: time.Now().Add(-1 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got := backend.ActiveSessions(); got != 1 {
+		t.Fatalf("expected 1 active session, got %d", got)
+	}
+}
+
+func TestCertificateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		expected string
+	}{
+		{"common name", &x509.Certificate{Subject: pkix.Name{CommonName: "build-bot"}, EmailAddresses: []string{"bot@example.com"}}, "build-bot"},
+		{"email", &x509.Certificate{EmailAddresses: []string{"bot@example.com"}, DNSNames: []string{"bot.example.com"}}, "bot@example.com"},
+		{"dns", &x509.Certificate{DNSNames: []string{"bot.example.com"}}, "bot.example.com"},
+		{"uri", &x509.Certificate{URIs: []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/bot"}}}, "spiffe://example.com/bot"},
+		{"none", &x509.Certificate{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CertificateUsername(tt.cert); got != tt.expected {
+				t.Fatalf("expected '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
 }
@@ -0,0 +1,303 @@
+// Package checksum computes checksums of files, caching them by the identity of the file so
+// that unchanged files are not read again.
+//
+// Files are identified by their device, inode, modification time and size, so a checksum is
+// reused across renames and hard links, and recomputed once a file is modified. A file that
+// changes while being read is not cached.
+package checksum
+
+import (
+	"container/list"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Supported algorithms.
+const (
+	SHA256 = "sha256"
+	SHA1   = "sha1"
+	MD5    = "md5"
+	// BLAKE2b is BLAKE2b-512, as computed by b2sum.
+	BLAKE2b = "blake2b"
+)
+
+var (
+	// ErrUnsupported is returned for unknown algorithms.
+	ErrUnsupported = errors.New("unsupported checksum algorithm, expected sha256, sha1, md5 or blake2b")
+	// ErrNotFile is returned for directories and other files that are not regular files.
+	ErrNotFile = errors.New("not a regular file")
+)
+
+// readSize is the size of the reads checksums are computed from.
+const readSize = 1 << 20
+
+// maxWarming is the number of files whose checksums are computed in the background at once.
+const maxWarming = 2
+
+var algorithms = map[string]func() hash.Hash{
+	SHA256: sha256.New,
+	SHA1:   sha1.New,
+	MD5:    md5.New,
+	BLAKE2b: func() hash.Hash {
+		// only fails for keys that are too long
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+}
+
+// Supported reports whether algorithm is a supported algorithm.
+func Supported(algorithm string) bool {
+	_, ok := algorithms[algorithm]
+	return ok
+}
+
+// Sums maps algorithms to hex-encoded checksums.
+type Sums map[string]string
+
+// fileKey identifies the contents of a file.
+type fileKey struct {
+	dev, ino uint64
+	modified int64
+	size     int64
+}
+
+// Cache caches the checksums of up to a maximum number of files, evicting the least recently
+// used. A nil *Cache computes checksums without caching them.
+type Cache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[fileKey]*list.Element
+	// order holds *entry values, most recently used first.
+	order *list.List
+	// warming holds the paths of the files being warmed, up to maxWarming
+	warming map[string]bool
+}
+
+type entry struct {
+	key  fileKey
+	sums Sums
+}
+
+// NewCache creates a cache of checksums for up to maxEntries files.
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		entries:    map[fileKey]*list.Element{},
+		order:      list.New(),
+		warming:    map[string]bool{},
+	}
+}
+
+// Sum returns the checksums of the regular file at path for each of algorithms. Checksums that
+// are not cached are computed in a single pass over the file, stopping if ctx is done.
+func (c *Cache) Sum(ctx context.Context, path string, algorithms ...string) (Sums, error) {
+	for _, algorithm := range algorithms {
+		if !Supported(algorithm) {
+			return nil, ErrUnsupported
+		}
+	}
+
+	f, info, err := openRegular(path)
+	if err != nil {
+		return nil, err
+	}
+	// nolint:errcheck
+	defer f.Close()
+
+	key, cacheable := keyOf(info)
+	sums, missing := c.lookup(key, cacheable, algorithms)
+	if len(missing) == 0 {
+		return sums, nil
+	}
+	computed, err := compute(ctx, f, missing)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable && unchanged(f, key) {
+		c.store(key, computed)
+	}
+	for algorithm, sum := range computed {
+		sums[algorithm] = sum
+	}
+	return sums, nil
+}
+
+func openRegular(path string) (*os.File, os.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = ErrNotFile
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// unchanged reports whether the open file f still has key.
+func unchanged(f *os.File, key fileKey) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	after, _ := keyOf(info)
+	return after == key
+}
+
+// Cached returns the checksum of the file at path for algorithm if it is cached, without
+// reading the file.
+func (c *Cache) Cached(path string, algorithm string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	return c.CachedInfo(info, algorithm)
+}
+
+// CachedInfo returns the checksum for algorithm of the file described by info if it is
+// cached, without reading or looking up the file again.
+func (c *Cache) CachedInfo(info os.FileInfo, algorithm string) (string, bool) {
+	if !info.Mode().IsRegular() {
+		return "", false
+	}
+	key, cacheable := keyOf(info)
+	sums, _ := c.lookup(key, cacheable, []string{algorithm})
+	sum, ok := sums[algorithm]
+	return sum, ok
+}
+
+// Warm computes the checksum of the file at path for algorithm in the background, so that it
+// is cached for later requests. It does nothing if the file is already being warmed, or too
+// many others are, and for a nil *Cache, which would not keep the checksum.
+func (c *Cache) Warm(path string, algorithm string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.warming[path] || len(c.warming) >= maxWarming {
+		return
+	}
+	c.warming[path] = true
+
+	go func() {
+		_, _ = c.Sum(context.Background(), path, algorithm)
+		c.mutex.Lock()
+		delete(c.warming, path)
+		c.mutex.Unlock()
+	}()
+}
+
+// lookup returns the cached checksums of key for algorithms, and the algorithms not cached.
+func (c *Cache) lookup(key fileKey, cacheable bool, algorithms []string) (Sums, []string) {
+	sums := Sums{}
+	if c == nil || !cacheable {
+		return sums, algorithms
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var missing []string
+	e, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(e)
+	}
+	for _, algorithm := range algorithms {
+		if sum, cached := c.cachedSum(e, algorithm); cached {
+			sums[algorithm] = sum
+		} else {
+			missing = append(missing, algorithm)
+		}
+	}
+	return sums, missing
+}
+
+func (c *Cache) cachedSum(e *list.Element, algorithm string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	sum, ok := entryOf(e).sums[algorithm]
+	return sum, ok
+}
+
+// store adds sums to the checksums cached for key.
+func (c *Cache) store(key fileKey, sums Sums) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		for algorithm, sum := range sums {
+			entryOf(e).sums[algorithm] = sum
+		}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, sums: sums})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, entryOf(oldest).key)
+	}
+}
+
+func entryOf(e *list.Element) *entry {
+	// only *entry values are added to the list
+	en, _ := e.Value.(*entry)
+	return en
+}
+
+// compute reads r to the end, returning its checksums for algorithms.
+func compute(ctx context.Context, r io.Reader, algorithms []string) (Sums, error) {
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, algorithm := range algorithms {
+		hashes[i] = newHash(algorithm)
+		writers[i] = hashes[i]
+	}
+
+	buf := make([]byte, readSize)
+	if _, err := io.CopyBuffer(io.MultiWriter(writers...), contextReader{ctx, r}, buf); err != nil {
+		return nil, err
+	}
+
+	sums := Sums{}
+	for i, algorithm := range algorithms {
+		sums[algorithm] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return sums, nil
+}
+
+func newHash(algorithm string) hash.Hash {
+	return algorithms[algorithm]()
+}
+
+// contextReader stops reading once ctx is done, so that large files are not read to the end
+// for a client that has gone away.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,143 @@
+package checksum
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var helloSums = Sums{
+	SHA256:  "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	SHA1:    "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+	MD5:     "5d41402abc4b2a76b9719d911017c592",
+	BLAKE2b: "e4cfa39a3d37be31c59609e807970799caa68a19bfaa15135f165085e01d41a65ba1e1b146aeb6bd0092b49eac214c103ccfa3a365954bbbe52f74a2b3620c94",
+}
+
+func writeFile(t *testing.T, path, content string, modified time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatalf("failed to set times: %v", err)
+	}
+}
+
+func TestSum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello.txt")
+	writeFile(t, path, "hello", time.Unix(1700000000, 0))
+
+	for _, c := range []*Cache{nil, NewCache(10)} {
+		sums, err := c.Sum(context.Background(), path, SHA256, SHA1, MD5, BLAKE2b)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(sums, helloSums) {
+			t.Errorf("expected %v, got %v", helloSums, sums)
+		}
+	}
+
+	if _, err := NewCache(10).Sum(context.Background(), path, "crc32"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+	if _, err := NewCache(10).Sum(context.Background(), dir, SHA256); !errors.Is(err, ErrNotFile) {
+		t.Errorf("expected ErrNotFile, got %v", err)
+	}
+	if _, err := NewCache(10).Sum(context.Background(), filepath.Join(dir, "missing"), SHA256); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewCache(10).Sum(ctx, path, SHA256); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("checksums are only cached where files have inode numbers")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hello.txt")
+	modified := time.Unix(1700000000, 0)
+	writeFile(t, path, "hello", modified)
+
+	c := NewCache(1)
+	if _, err := c.Sum(context.Background(), path, SHA256); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sum, ok := c.Cached(path, SHA256); !ok || sum != helloSums[SHA256] {
+		t.Errorf("expected cached sha256, got %q", sum)
+	}
+	if _, ok := c.Cached(path, MD5); ok {
+		t.Error("expected md5 not to be cached")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if sum, ok := c.CachedInfo(info, SHA256); !ok || sum != helloSums[SHA256] {
+		t.Errorf("expected cached sha256 from the file info, got %q", sum)
+	}
+
+	// rewritten in place with the same size and time, so the cached checksum is used
+	writeFile(t, path, "HELLO", modified)
+	sums, err := c.Sum(context.Background(), path, SHA256, MD5)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sums[SHA256] != helloSums[SHA256] || sums[MD5] == helloSums[MD5] {
+		t.Errorf("expected cached sha256 and computed md5, got %v", sums)
+	}
+
+	// modified, so recomputed
+	writeFile(t, path, "HELLO", modified.Add(time.Second))
+	if sums, _ := c.Sum(context.Background(), path, SHA256); sums[SHA256] == helloSums[SHA256] {
+		t.Errorf("expected sha256 to be recomputed, got %v", sums)
+	}
+
+	// evicted by another file
+	other := filepath.Join(dir, "other.txt")
+	writeFile(t, other, "other", modified)
+	if _, err := c.Sum(context.Background(), other, SHA256); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := c.Cached(path, SHA256); ok {
+		t.Error("expected least recently used file to be evicted")
+	}
+}
+
+func TestWarm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("checksums are only cached where files have inode numbers")
+	}
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	writeFile(t, path, "hello", time.Unix(1700000000, 0))
+
+	c := NewCache(1)
+	c.Warm(path, SHA256)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if sum, ok := c.Cached(path, SHA256); ok {
+			if sum != helloSums[SHA256] {
+				t.Errorf("expected warmed sha256, got %q", sum)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the checksum to be cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// does nothing without a cache to keep the checksum
+	var nilCache *Cache
+	nilCache.Warm(path, SHA256)
+}
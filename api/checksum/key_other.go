@@ -0,0 +1,10 @@
+//go:build !unix
+
+package checksum
+
+import "io/fs"
+
+// keyOf returns false, as files have no inode numbers to identify them on this platform.
+func keyOf(fs.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
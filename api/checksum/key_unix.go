@@ -0,0 +1,22 @@
+//go:build unix
+
+package checksum
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// keyOf returns the key of the file described by info, and whether it can be cached.
+func keyOf(info fs.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{
+		dev:      uint64(stat.Dev), // nolint:unconvert // not uint64 on all platforms
+		ino:      stat.Ino,
+		modified: info.ModTime().UnixNano(),
+		size:     info.Size(),
+	}, true
+}
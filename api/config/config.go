@@ -0,0 +1,970 @@
+// Package config loads the fs4 configuration from a YAML file, environment variables and defaults.
+//
+// Values are layered in increasing order of precedence: built-in defaults, the config file,
+// then FS4_* environment variables. Command line flags are applied on top by the caller.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix for environment variable overrides.
+// Variables are named after the YAML path, e.g. FS4_SERVER_PORT or FS4_TLS_CERT.
+const EnvPrefix = "FS4"
+
+// Config is the full fs4 configuration.
+type Config struct {
+	Server     Server     `yaml:"server"`
+	TLS        TLS        `yaml:"tls"`
+	CORS       CORS       `yaml:"cors"`
+	Headers    Headers    `yaml:"security_headers"`
+	Auth       Auth       `yaml:"auth"`
+	Storage    Storage    `yaml:"storage"`
+	Thumbnails Thumbnails `yaml:"thumbnails"`
+	Trash      Trash      `yaml:"trash"`
+	Versions   Versions   `yaml:"versions"`
+	Quota      Quota      `yaml:"quota"`
+	DirSizes   DirSizes   `yaml:"dir_sizes"`
+	DiskUsage  DiskUsage  `yaml:"disk_usage"`
+	Limits     Limits     `yaml:"limits"`
+	Logging    Logging    `yaml:"logging"`
+	Metrics    Metrics    `yaml:"metrics"`
+}
+
+// Server configures the main listener.
+type Server struct {
+	Host         string        `yaml:"host"`
+	Port         int           `yaml:"port"`
+	Root         string        `yaml:"root"`
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// DrainDelay is how long the server reports not ready on shutdown before it stops
+	// accepting connections, so load balancers can see it and stop routing to it.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// Production disables development conveniences such as CORS for the webapp dev server.
+	Production bool `yaml:"production"`
+	// HTTP3 additionally serves HTTP/3 over QUIC on the same host and UDP port.
+	HTTP3 bool `yaml:"http3"`
+	// RedirectListen is an optional plain HTTP address redirecting all requests to HTTPS, e.g. ":80".
+	RedirectListen string `yaml:"redirect_listen"`
+	// Listen selects the main listener: empty for TCP on Host and Port, "unix:<path>" for a
+	// Unix socket, or ListenSystemd for a socket passed by systemd socket activation.
+	Listen string `yaml:"listen"`
+	// SocketMode is the octal file mode of a Unix socket, e.g. "0660".
+	SocketMode string `yaml:"socket_mode"`
+	// Plaintext serves HTTP without TLS, for use behind a trusted proxy terminating TLS.
+	Plaintext bool `yaml:"plaintext"`
+	// TrustedProxies are addresses or CIDR ranges of proxies whose X-Forwarded-For and
+	// X-Forwarded-Proto headers are trusted. Unix socket peers are always trusted.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// TLS configures the certificate and protocol settings of the main listener.
+// Cipher suites only apply to TLS 1.2, as TLS 1.3 suites are not configurable.
+type TLS struct {
+	// Mode selects where the certificate comes from, one of TLSModeFiles, TLSModeSelfSigned
+	// or TLSModeACME.
+	Mode string `yaml:"mode"`
+	// Cert and Key are the certificate files used in files mode.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// CacheDir holds generated or obtained certificates in the self-signed and acme modes.
+	CacheDir string `yaml:"cache_dir"`
+	// Hosts are the names certificates are issued for in the self-signed and acme modes.
+	Hosts []string `yaml:"hosts"`
+	ACME  ACME     `yaml:"acme"`
+
+	MinVersion   string   `yaml:"min_version"`
+	CipherSuites []string `yaml:"cipher_suites"`
+	// ClientAuth is one of ClientAuthNone, ClientAuthOptional or ClientAuthRequire.
+	// Clients presenting a certificate signed by a CA in ClientCA are authenticated as the user it names.
+	ClientAuth string `yaml:"client_auth"`
+	ClientCA   string `yaml:"client_ca"`
+	// ReloadInterval is how often the cert and key files are checked for changes, zero to
+	// only reload on SIGHUP.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// ACME configures certificate issuance in acme mode.
+type ACME struct {
+	DirectoryURL string `yaml:"directory_url"`
+	Email        string `yaml:"email"`
+	// CABundle is an optional PEM file of CAs trusted when connecting to the directory,
+	// for test servers such as pebble.
+	CABundle string `yaml:"ca_bundle"`
+	// RenewBefore is how long before expiry certificates are renewed.
+	RenewBefore time.Duration `yaml:"renew_before"`
+}
+
+// CORS configures cross-origin requests. It is ignored in production mode.
+// Origins may contain a single "*" wildcard, e.g. "https://*.example.com".
+type CORS struct {
+	AllowedOrigins []string      `yaml:"allowed_origins"`
+	AllowedMethods []string      `yaml:"allowed_methods"`
+	AllowedHeaders []string      `yaml:"allowed_headers"`
+	ExposedHeaders []string      `yaml:"exposed_headers"`
+	MaxAge         time.Duration `yaml:"max_age"`
+}
+
+// Headers configures the security headers sent with every response.
+// Empty values omit the corresponding header.
+type Headers struct {
+	// HSTSMaxAge is the Strict-Transport-Security max-age, zero to omit the header.
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains"`
+	// ContentSecurityPolicy is sent with a frame-ancestors directive built from FrameAncestors.
+	ContentSecurityPolicy string   `yaml:"content_security_policy"`
+	FrameAncestors        []string `yaml:"frame_ancestors"`
+	ReferrerPolicy        string   `yaml:"referrer_policy"`
+}
+
+// Auth configures the authentication backend.
+type Auth struct {
+	Backend         string        `yaml:"backend"`
+	SessionLifetime time.Duration `yaml:"session_lifetime"`
+	Users           []User        `yaml:"users"`
+}
+
+// User is a user account provisioned at startup.
+type User struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+	Admin        bool   `yaml:"admin"`
+}
+
+// Storage configures where persistent state is written.
+type Storage struct {
+	AuditLog        string `yaml:"audit_log"`
+	AuditMaxSize    int64  `yaml:"audit_max_size"`
+	AuditMaxBackups int    `yaml:"audit_max_backups"`
+}
+
+// Thumbnails configures image thumbnail generation.
+type Thumbnails struct {
+	// CacheDir holds generated thumbnails, empty to disable thumbnails.
+	CacheDir string `yaml:"cache_dir"`
+	// Workers is the number of images decoded at once.
+	Workers int `yaml:"workers"`
+	// MaxPixels is the largest width times height of an image that is decoded.
+	MaxPixels int64 `yaml:"max_pixels"`
+}
+
+// Trash configures the trash that deleted files are moved to.
+type Trash struct {
+	// Retention is how long deleted items are kept before being purged, forever if zero.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Versions configures keeping the previous contents of files overwritten by uploads.
+type Versions struct {
+	// MaxCount is the number of versions kept per file, zero to keep none.
+	MaxCount int `yaml:"max_count"`
+	// MaxAge is how long versions are kept after being overwritten, forever if zero.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Quota configures limits on the storage used by the files each user uploads.
+type Quota struct {
+	// Soft is the usage in bytes above which uploads succeed with a warning, zero for none.
+	Soft int64 `yaml:"soft"`
+	// Hard is the usage in bytes uploads are refused for taking a user over, zero for none.
+	Hard int64 `yaml:"hard"`
+	// Users overrides the limits for individual users.
+	Users map[string]QuotaLimits `yaml:"users"`
+}
+
+// QuotaLimits are the storage limits of a user, like those of Quota.
+type QuotaLimits struct {
+	Soft int64 `yaml:"soft"`
+	Hard int64 `yaml:"hard"`
+}
+
+// DirSizes configures calculating the total size of directories in the background.
+type DirSizes struct {
+	// Workers is the number of directory trees walked at once, zero to disable directory sizes.
+	Workers int `yaml:"workers"`
+	// MaxWatched is the most directories a tree may have for its size to be kept until it
+	// changes. Sizes of larger trees are recalculated once older than TTL.
+	MaxWatched int `yaml:"max_watched"`
+	// TTL is how long the sizes of trees too large to watch are kept.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// DiskUsage configures the disk usage analyses available to admins.
+type DiskUsage struct {
+	// Workers is the number of directories read at once, shared by all analyses.
+	Workers int `yaml:"workers"`
+	// Timeout is how long an analysis may take, unlimited if zero.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Limits configures request size and timeout limits.
+type Limits struct {
+	MaxRequestBody    int64         `yaml:"max_request_body"`
+	MaxUploadSize     int64         `yaml:"max_upload_size"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+}
+
+// Logging configures log output.
+type Logging struct {
+	Format string `yaml:"format"`
+}
+
+// Metrics configures the Prometheus metrics listener.
+type Metrics struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+}
+
+// BackendMemory is the in-memory auth backend, currently the only one available.
+const BackendMemory = "memory"
+
+const (
+	// ListenSystemd uses the socket passed by systemd socket activation as the main listener.
+	ListenSystemd = "systemd"
+	// ListenUnixPrefix prefixes the path of a Unix socket to use as the main listener.
+	ListenUnixPrefix = "unix:"
+)
+
+const (
+	// TLSModeFiles loads the certificate and key from tls.cert and tls.key.
+	TLSModeFiles = "files"
+	// TLSModeSelfSigned generates a local CA and a certificate signed by it in tls.cache_dir.
+	TLSModeSelfSigned = "self-signed"
+	// TLSModeACME obtains certificates from an ACME directory, caching them in tls.cache_dir.
+	TLSModeACME = "acme"
+)
+
+const (
+	// ClientAuthNone does not request client certificates.
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies client certificates if presented, other clients use cookie login.
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire rejects connections without a verified client certificate.
+	ClientAuthRequire = "require"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	ClientAuthNone:     tls.NoClientCert,
+	ClientAuthOptional: tls.VerifyClientCertIfGiven,
+	ClientAuthRequire:  tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Default returns the configuration used when no file or overrides are given.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Host:         "localhost",
+			Port:         8081,
+			Root:         "./files/",
+			DrainTimeout: 30 * time.Second,
+			DrainDelay:   5 * time.Second,
+			SocketMode:   "0660",
+		},
+		TLS: TLS{
+			Mode:     TLSModeFiles,
+			Cert:     "api/certs/localhost.pem",
+			Key:      "api/certs/localhost-key.pem",
+			CacheDir: "tls-cache",
+			Hosts:    []string{"localhost", "127.0.0.1", "::1"},
+			ACME: ACME{
+				DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
+				RenewBefore:  30 * 24 * time.Hour,
+			},
+			MinVersion:     "1.2",
+			ClientAuth:     ClientAuthNone,
+			ReloadInterval: 30 * time.Second,
+			CipherSuites: []string{
+				"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+				"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+				"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+				"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+				"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+				"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+			},
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"http://localhost:3000"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type"},
+			ExposedHeaders: []string{"X-Request-ID", "X-Quota-Warning"},
+		},
+		Headers: Headers{
+			HSTSMaxAge: 365 * 24 * time.Hour,
+			ContentSecurityPolicy: "default-src 'self'; script-src 'self'; style-src 'self'; " +
+				"img-src 'self' data:; connect-src 'self'; font-src 'self'; object-src 'none'; " +
+				"base-uri 'self'; form-action 'self'",
+			FrameAncestors: []string{"'none'"},
+			ReferrerPolicy: "no-referrer",
+		},
+		Auth: Auth{
+			Backend:         BackendMemory,
+			SessionLifetime: 30 * time.Minute,
+		},
+		Storage: Storage{
+			AuditLog:        "audit.jsonl",
+			AuditMaxSize:    10 << 20,
+			AuditMaxBackups: 5,
+		},
+		Thumbnails: Thumbnails{
+			CacheDir:  "thumbnail-cache",
+			Workers:   4,
+			MaxPixels: 50_000_000,
+		},
+		Trash: Trash{
+			Retention: 30 * 24 * time.Hour,
+		},
+		Versions: Versions{
+			MaxCount: 10,
+			MaxAge:   90 * 24 * time.Hour,
+		},
+		DirSizes: DirSizes{
+			Workers:    2,
+			MaxWatched: 1000,
+			TTL:        10 * time.Minute,
+		},
+		DiskUsage: DiskUsage{
+			Workers: 4,
+			Timeout: 5 * time.Minute,
+		},
+		Limits: Limits{
+			MaxRequestBody:    1 << 20,
+			MaxUploadSize:     1 << 30,
+			MaxHeaderBytes:    1 << 20,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		},
+		Logging: Logging{
+			Format: "text",
+		},
+	}
+}
+
+// Load builds a configuration from the defaults, the YAML file at path (if non-empty),
+// and FS4_* environment variables. Callers should apply any flag overrides and then
+// call Validate.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open config file: %w", err)
+		}
+		// nolint:errcheck
+		defer f.Close()
+
+		if err := cfg.decode(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// decode reads YAML into cfg, rejecting unknown keys.
+func (cfg *Config) decode(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(cfg)
+}
+
+// ApplyEnv overrides configuration values from environment variables found by lookup.
+// List values are comma-separated.
+func (cfg *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookup)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.New("cannot be set from the environment")
+		}
+		v.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		return errors.New("cannot be set from the environment")
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type problems []string
+
+func (p *problems) addf(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the configuration for errors, including that the serving root
+// and TLS files exist. All problems are reported together in a *ValidationError.
+func (cfg *Config) Validate() error {
+	var p problems
+	cfg.Server.validate(&p)
+	cfg.validateListeners(&p)
+	if !cfg.Server.Plaintext {
+		cfg.TLS.validate(&p)
+	}
+	cfg.CORS.validate(&p)
+	cfg.Headers.validate(&p)
+	cfg.Auth.validate(&p)
+	cfg.Storage.validate(&p)
+	cfg.Thumbnails.validate(&p)
+	cfg.Trash.validate(&p)
+	cfg.Versions.validate(&p)
+	cfg.Quota.validate(&p)
+	cfg.DirSizes.validate(&p)
+	cfg.DiskUsage.validate(&p)
+	cfg.Limits.validate(&p)
+
+	if cfg.Logging.Format != "text" && cfg.Logging.Format != "json" {
+		p.addf("logging.format: unsupported format %q, expected text or json", cfg.Logging.Format)
+	}
+
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+	return nil
+}
+
+// validateListeners checks that the listener settings of the server and TLS sections are compatible.
+func (cfg *Config) validateListeners(p *problems) {
+	s := cfg.Server
+	if s.Listen != "" && s.HTTP3 {
+		p.addf("server.http3: requires the main listener to use TCP on host and port")
+	}
+	if !s.Plaintext {
+		return
+	}
+	if s.Listen == "" && len(s.TrustedProxies) == 0 {
+		p.addf("server.plaintext: requires a Unix or systemd socket, or trusted_proxies to be set")
+	}
+	if s.HTTP3 {
+		p.addf("server.http3: cannot be used in plaintext mode")
+	}
+	if s.RedirectListen != "" {
+		p.addf("server.redirect_listen: cannot be used in plaintext mode")
+	}
+	if cfg.TLS.ClientAuth != ClientAuthNone {
+		p.addf("tls.client_auth: client certificates cannot be verified in plaintext mode")
+	}
+}
+
+func (s Server) validate(p *problems) {
+	if s.Port < 1 || s.Port > 65535 {
+		p.addf("server.port: must be between 1 and 65535, got %d", s.Port)
+	}
+	if s.Root == "" {
+		p.addf("server.root: must be set")
+	} else if info, err := os.Stat(s.Root); err != nil {
+		p.addf("server.root: %v", err)
+	} else if !info.IsDir() {
+		p.addf("server.root: %s is not a directory", s.Root)
+	}
+	if s.DrainTimeout < 0 {
+		p.addf("server.drain_timeout: must not be negative")
+	}
+	if s.DrainDelay < 0 {
+		p.addf("server.drain_delay: must not be negative")
+	}
+	s.validateListen(p)
+}
+
+func (s Server) validateListen(p *problems) {
+	if s.RedirectListen != "" {
+		if _, _, err := net.SplitHostPort(s.RedirectListen); err != nil {
+			p.addf("server.redirect_listen: %v", err)
+		}
+	}
+	if s.Listen != "" && s.Listen != ListenSystemd && (!strings.HasPrefix(s.Listen, ListenUnixPrefix) || s.Listen == ListenUnixPrefix) {
+		p.addf("server.listen: %q must be empty, %s or %s<path>", s.Listen, ListenSystemd, ListenUnixPrefix)
+	}
+	if _, err := s.SocketFileMode(); err != nil {
+		p.addf("server.socket_mode: %v", err)
+	}
+	if _, err := s.TrustedProxyPrefixes(); err != nil {
+		p.addf("server.trusted_proxies: %v", err)
+	}
+}
+
+// SocketFileMode parses the configured Unix socket file mode.
+func (s Server) SocketFileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal file mode", s.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// TrustedProxyPrefixes parses the configured trusted proxies. Single addresses are
+// returned as prefixes covering only that address.
+func (s Server) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (t TLS) validate(p *problems) {
+	switch t.Mode {
+	case TLSModeFiles:
+		t.validateFiles(p)
+	case TLSModeSelfSigned:
+		t.validateCache(p)
+	case TLSModeACME:
+		t.validateCache(p)
+		t.ACME.validate(p)
+		for i, host := range t.Hosts {
+			if net.ParseIP(host) != nil {
+				p.addf("tls.hosts[%d]: %q is an IP address, acme mode requires DNS names", i, host)
+			}
+		}
+	default:
+		p.addf("tls.mode: unsupported mode %q, expected %s, %s or %s", t.Mode, TLSModeFiles, TLSModeSelfSigned, TLSModeACME)
+	}
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		p.addf("tls.min_version: unsupported version %q, expected 1.2 or 1.3", t.MinVersion)
+	}
+	if _, err := t.CipherSuiteIDs(); err != nil {
+		p.addf("tls.cipher_suites: %v", err)
+	}
+	if t.ReloadInterval < 0 {
+		p.addf("tls.reload_interval: must not be negative")
+	}
+	t.validateClientAuth(p)
+}
+
+func (t TLS) validateFiles(p *problems) {
+	for _, f := range []struct{ name, path string }{{"tls.cert", t.Cert}, {"tls.key", t.Key}} {
+		if f.path == "" {
+			p.addf("%s: must be set", f.name)
+		} else if _, err := os.Stat(f.path); err != nil {
+			p.addf("%s: %v", f.name, err)
+		}
+	}
+}
+
+func (t TLS) validateClientAuth(p *problems) {
+	if _, ok := clientAuthTypes[t.ClientAuth]; !ok {
+		p.addf("tls.client_auth: unsupported mode %q, expected %s, %s or %s", t.ClientAuth, ClientAuthNone, ClientAuthOptional, ClientAuthRequire)
+		return
+	}
+	if t.ClientAuth == ClientAuthNone {
+		return
+	}
+	if t.ClientAuth == ClientAuthRequire && t.Mode == TLSModeACME {
+		p.addf("tls.client_auth: %s cannot be used in acme mode, ACME challenges are made without a client certificate", ClientAuthRequire)
+	}
+	if t.ClientCA == "" {
+		p.addf("tls.client_ca: must be set when client_auth is %s", t.ClientAuth)
+	} else if _, err := t.ClientCAPool(); err != nil {
+		p.addf("tls.client_ca: %v", err)
+	}
+}
+
+func (t TLS) validateCache(p *problems) {
+	if t.CacheDir == "" {
+		p.addf("tls.cache_dir: must be set in %s mode", t.Mode)
+	}
+	if len(t.Hosts) == 0 {
+		p.addf("tls.hosts: at least one host is required in %s mode", t.Mode)
+	}
+	for i, host := range t.Hosts {
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		if host == "" || strings.ContainsAny(host, "/: ") {
+			p.addf("tls.hosts[%d]: invalid host %q", i, host)
+		}
+	}
+}
+
+func (a ACME) validate(p *problems) {
+	if u, err := url.Parse(a.DirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		p.addf("tls.acme.directory_url: must be an https URL, got %q", a.DirectoryURL)
+	}
+	if a.CABundle != "" {
+		if _, err := os.Stat(a.CABundle); err != nil {
+			p.addf("tls.acme.ca_bundle: %v", err)
+		}
+	}
+	if a.RenewBefore < 0 {
+		p.addf("tls.acme.renew_before: must not be negative")
+	}
+}
+
+var referrerPolicies = map[string]bool{
+	"": true, "no-referrer": true, "no-referrer-when-downgrade": true, "origin": true,
+	"origin-when-cross-origin": true, "same-origin": true, "strict-origin": true,
+	"strict-origin-when-cross-origin": true, "unsafe-url": true,
+}
+
+func (h Headers) validate(p *problems) {
+	if h.HSTSMaxAge < 0 {
+		p.addf("security_headers.hsts_max_age: must not be negative")
+	}
+	if strings.ContainsAny(h.ContentSecurityPolicy, "\r\n") {
+		p.addf("security_headers.content_security_policy: must be a single line")
+	}
+	if strings.Contains(strings.ToLower(h.ContentSecurityPolicy), "frame-ancestors") {
+		p.addf("security_headers.content_security_policy: set frame-ancestors with security_headers.frame_ancestors instead")
+	}
+	for i, source := range h.FrameAncestors {
+		if source == "" || strings.ContainsAny(source, " ;,\r\n") {
+			p.addf("security_headers.frame_ancestors[%d]: invalid source %q", i, source)
+		}
+	}
+	if !referrerPolicies[h.ReferrerPolicy] {
+		p.addf("security_headers.referrer_policy: unsupported policy %q", h.ReferrerPolicy)
+	}
+}
+
+var corsMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true,
+}
+
+func (c CORS) validate(p *problems) {
+	for i, origin := range c.AllowedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			p.addf("cors.allowed_origins[%d]: %v", i, err)
+		}
+	}
+	for i, method := range c.AllowedMethods {
+		if !corsMethods[method] {
+			p.addf("cors.allowed_methods[%d]: unsupported method %q", i, method)
+		}
+	}
+	for i, header := range c.AllowedHeaders {
+		if !validHeaderName(header) {
+			p.addf("cors.allowed_headers[%d]: invalid header name %q", i, header)
+		}
+	}
+	for i, header := range c.ExposedHeaders {
+		if !validHeaderName(header) {
+			p.addf("cors.exposed_headers[%d]: invalid header name %q", i, header)
+		}
+	}
+	if c.MaxAge < 0 {
+		p.addf("cors.max_age: must not be negative")
+	}
+}
+
+// validateOrigin checks that origin is a scheme and host, with at most one wildcard.
+// A bare "*" is rejected since credentials are always allowed.
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return errors.New(`"*" cannot be used with credentials, list origins explicitly`)
+	}
+	if strings.Count(origin, "*") > 1 {
+		return fmt.Errorf("%q may contain at most one wildcard", origin)
+	}
+	u, err := url.Parse(strings.Replace(origin, "*", "wildcard", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be of the form scheme://host[:port]", origin)
+	}
+	if u.Path+u.RawQuery+u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("%q must not contain a path, query or credentials", origin)
+	}
+	return nil
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	return strings.IndexFunc(name, func(r rune) bool {
+		return !strings.ContainsRune(headerNameChars, r)
+	}) < 0
+}
+
+// headerNameChars are the characters accepted in header names.
+const headerNameChars = "-_0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func (a Auth) validate(p *problems) {
+	if a.Backend != BackendMemory {
+		p.addf("auth.backend: unsupported backend %q, expected %q", a.Backend, BackendMemory)
+	}
+	if a.SessionLifetime <= 0 {
+		p.addf("auth.session_lifetime: must be positive")
+	}
+	seen := map[string]bool{}
+	for i, u := range a.Users {
+		if u.Username == "" {
+			p.addf("auth.users[%d].username: must be set", i)
+		} else if seen[u.Username] {
+			p.addf("auth.users[%d].username: duplicate user %q", i, u.Username)
+		}
+		seen[u.Username] = true
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			p.addf("auth.users[%d].password_hash: must be a bcrypt hash", i)
+		}
+	}
+}
+
+func (s Storage) validate(p *problems) {
+	if s.AuditMaxSize < 0 {
+		p.addf("storage.audit_max_size: must not be negative")
+	}
+	if s.AuditMaxBackups < 0 {
+		p.addf("storage.audit_max_backups: must not be negative")
+	}
+}
+
+func (t Thumbnails) validate(p *problems) {
+	if t.CacheDir == "" {
+		return
+	}
+	if t.Workers < 1 {
+		p.addf("thumbnails.workers: must be at least 1")
+	}
+	if t.MaxPixels <= 0 {
+		p.addf("thumbnails.max_pixels: must be positive")
+	}
+}
+
+func (t Trash) validate(p *problems) {
+	if t.Retention < 0 {
+		p.addf("trash.retention: must not be negative")
+	}
+}
+
+func (v Versions) validate(p *problems) {
+	if v.MaxCount < 0 {
+		p.addf("versions.max_count: must not be negative")
+	}
+	if v.MaxAge < 0 {
+		p.addf("versions.max_age: must not be negative")
+	}
+}
+
+func (d DirSizes) validate(p *problems) {
+	if d.Workers < 0 {
+		p.addf("dir_sizes.workers: must not be negative")
+	}
+	if d.MaxWatched < 0 {
+		p.addf("dir_sizes.max_watched: must not be negative")
+	}
+	if d.Workers > 0 && d.TTL <= 0 {
+		p.addf("dir_sizes.ttl: must be positive")
+	}
+}
+
+func (d DiskUsage) validate(p *problems) {
+	if d.Workers < 0 {
+		p.addf("disk_usage.workers: must not be negative")
+	}
+	if d.Timeout < 0 {
+		p.addf("disk_usage.timeout: must not be negative")
+	}
+}
+
+func (q Quota) validate(p *problems) {
+	QuotaLimits{Soft: q.Soft, Hard: q.Hard}.validate(p, "quota")
+	users := make([]string, 0, len(q.Users))
+	for user := range q.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		if user == "" {
+			p.addf("quota.users: usernames must not be empty")
+		}
+		q.Users[user].validate(p, fmt.Sprintf("quota.users[%q]", user))
+	}
+}
+
+func (l QuotaLimits) validate(p *problems, prefix string) {
+	if l.Soft < 0 {
+		p.addf("%s.soft: must not be negative", prefix)
+	}
+	if l.Hard < 0 {
+		p.addf("%s.hard: must not be negative", prefix)
+	}
+	if l.Hard > 0 && l.Soft > l.Hard {
+		p.addf("%s.soft: must not be above the hard limit", prefix)
+	}
+}
+
+func (l Limits) validate(p *problems) {
+	if l.MaxRequestBody <= 0 {
+		p.addf("limits.max_request_body: must be positive")
+	}
+	if l.MaxUploadSize <= 0 {
+		p.addf("limits.max_upload_size: must be positive")
+	}
+	if l.MaxHeaderBytes <= 0 {
+		p.addf("limits.max_header_bytes: must be positive")
+	}
+	if l.ReadHeaderTimeout <= 0 {
+		p.addf("limits.read_header_timeout: must be positive")
+	}
+	if l.IdleTimeout < 0 {
+		p.addf("limits.idle_timeout: must not be negative")
+	}
+}
+
+// MinVersionID returns the tls package constant for the configured minimum version.
+func (t TLS) MinVersionID() uint16 {
+	return tlsVersions[t.MinVersion]
+}
+
+// ClientAuthType returns the tls package constant for the configured client_auth mode.
+func (t TLS) ClientAuthType() tls.ClientAuthType {
+	return clientAuthTypes[t.ClientAuth]
+}
+
+// ClientCAPool loads the CAs trusted to issue client certificates, or returns nil if
+// client certificates are not requested.
+func (t TLS) ClientCAPool() (*x509.CertPool, error) {
+	if t.ClientAuthType() == tls.NoClientCert {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(t.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", t.ClientCA)
+	}
+	return pool, nil
+}
+
+// CipherSuiteIDs resolves the configured cipher suite names.
+// Only suites considered secure by the tls package are accepted.
+func (t TLS) CipherSuiteIDs() ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
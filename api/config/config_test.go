@@ -0,0 +1,334 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig returns the default configuration with paths pointing at temporary files.
+func testConfig(t *testing.T) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"cert.pem", "key.pem"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("test"), 0600); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+
+	cfg := Default()
+	cfg.Server.Root = dir
+	cfg.TLS.Cert = filepath.Join(dir, "cert.pem")
+	cfg.TLS.Key = filepath.Join(dir, "key.pem")
+	return cfg
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := testConfig(t).Validate(); err != nil {
+		t.Fatalf("expected default config to be valid, got %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		cfg := testConfig(t)
+		err := cfg.decode(strings.NewReader("server:\n  port: 9000\nauth:\n  session_lifetime: 1h\n"))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if cfg.Server.Port != 9000 {
+			t.Errorf("expected port 9000, got %d", cfg.Server.Port)
+		}
+		if cfg.Auth.SessionLifetime != time.Hour {
+			t.Errorf("expected session lifetime 1h, got %s", cfg.Auth.SessionLifetime)
+		}
+		if cfg.Server.Host != "localhost" {
+			t.Errorf("expected unset values to keep defaults, got host '%s'", cfg.Server.Host)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		err := Default().decode(strings.NewReader("server:\n  prot: 9000\n"))
+		if err == nil || !strings.Contains(err.Error(), "field prot not found") {
+			t.Fatalf("expected unknown field error, got %v", err)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if err := Default().decode(strings.NewReader("")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"FS4_SERVER_PORT":          "9443",
+		"FS4_SERVER_DRAIN_TIMEOUT": "5s",
+		"FS4_CORS_ALLOWED_ORIGINS": "https://a.example.com, https://b.example.com",
+		"FS4_METRICS_TOKEN":        "secret",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	cfg := Default()
+	if err := cfg.ApplyEnv(lookup); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if cfg.Server.Port != 9443 {
+		t.Errorf("expected port 9443, got %d", cfg.Server.Port)
+	}
+	if cfg.Server.DrainTimeout != 5*time.Second {
+		t.Errorf("expected drain timeout 5s, got %s", cfg.Server.DrainTimeout)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 2 || cfg.CORS.AllowedOrigins[1] != "https://b.example.com" {
+		t.Errorf("expected two allowed origins, got %v", cfg.CORS.AllowedOrigins)
+	}
+	if cfg.Metrics.Token != "secret" {
+		t.Errorf("expected metrics token 'secret', got '%s'", cfg.Metrics.Token)
+	}
+
+	env = map[string]string{"FS4_SERVER_PORT": "not-a-number"}
+	if err := Default().ApplyEnv(lookup); err == nil || !strings.Contains(err.Error(), "FS4_SERVER_PORT") {
+		t.Errorf("expected error naming FS4_SERVER_PORT, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Server.Port = 0
+	cfg.Server.DrainDelay = -time.Second
+	cfg.TLS.MinVersion = "1.1"
+	cfg.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	cfg.Auth.Backend = "ldap"
+	cfg.Auth.Users = []User{{Username: "admin", PasswordHash: "plaintext"}}
+	cfg.Headers.ContentSecurityPolicy = "default-src 'self'; frame-ancestors *"
+	cfg.Headers.ReferrerPolicy = "sometimes"
+	cfg.Thumbnails.Workers = 0
+	cfg.Trash.Retention = -time.Hour
+	cfg.Versions.MaxCount = -1
+	cfg.Quota.Users = map[string]QuotaLimits{"alice": {Soft: 20, Hard: 10}}
+	cfg.DirSizes.TTL = 0
+	cfg.DiskUsage.Workers = -1
+	cfg.Logging.Format = "xml"
+
+	err := cfg.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"server.port",
+		"server.drain_delay",
+		"tls.min_version",
+		"tls.cipher_suites",
+		"security_headers.content_security_policy",
+		"security_headers.referrer_policy",
+		"auth.backend",
+		"auth.users[0].password_hash",
+		"thumbnails.workers",
+		"trash.retention",
+		"versions.max_count",
+		`quota.users["alice"].soft`,
+		"dir_sizes.ttl",
+		"disk_usage.workers",
+		"logging.format",
+	}
+	if len(verr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(verr.Problems), verr.Problems)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(verr.Problems[i], prefix+":") {
+			t.Errorf("expected problem %d to be about %s, got '%s'", i, prefix, verr.Problems[i])
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(*TLS)
+		problems []string
+	}{
+		{"self-signed ignores cert files", func(c *TLS) {
+			c.Mode = TLSModeSelfSigned
+			c.Cert = ""
+		}, nil},
+		{"self-signed requires hosts", func(c *TLS) {
+			c.Mode = TLSModeSelfSigned
+			c.Hosts = nil
+		}, []string{"tls.hosts"}},
+		{"acme", func(c *TLS) {
+			c.Mode = TLSModeACME
+			c.Hosts = []string{"fs4.example.com"}
+		}, nil},
+		{"acme rejects IPs and plain http", func(c *TLS) {
+			c.Mode = TLSModeACME
+			c.ACME.DirectoryURL = "http://localhost:14000/dir"
+		}, []string{"tls.acme.directory_url", "tls.hosts[1]", "tls.hosts[2]"}},
+		{"unknown mode", func(c *TLS) {
+			c.Mode = "manual"
+		}, []string{"tls.mode"}},
+		{"client auth requires a CA", func(c *TLS) {
+			c.ClientAuth = ClientAuthOptional
+		}, []string{"tls.client_ca"}},
+		{"client auth CA must contain certificates", func(c *TLS) {
+			c.ClientAuth = ClientAuthOptional
+			c.ClientCA = c.Cert
+		}, []string{"tls.client_ca"}},
+		{"required client auth conflicts with acme", func(c *TLS) {
+			c.Mode = TLSModeACME
+			c.Hosts = []string{"fs4.example.com"}
+			c.ClientAuth = ClientAuthRequire
+			c.ClientCA = c.Cert
+		}, []string{"tls.client_auth", "tls.client_ca"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			tt.update(&cfg.TLS)
+			err := cfg.Validate()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected ValidationError, got %v", err)
+			}
+			if len(verr.Problems) != len(tt.problems) {
+				t.Fatalf("expected %d problems, got %v", len(tt.problems), verr.Problems)
+			}
+			for i, prefix := range tt.problems {
+				if !strings.HasPrefix(verr.Problems[i], prefix+":") {
+					t.Errorf("expected problem %d to be about %s, got '%s'", i, prefix, verr.Problems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(*Config)
+		problems []string
+	}{
+		{"unix socket", func(c *Config) {
+			c.Server.Listen = "unix:/run/fs4/fs4.sock"
+		}, nil},
+		{"systemd socket", func(c *Config) {
+			c.Server.Listen = ListenSystemd
+		}, nil},
+		{"unknown listener", func(c *Config) {
+			c.Server.Listen = "tcp:8080"
+		}, []string{"server.listen"}},
+		{"unix socket without path", func(c *Config) {
+			c.Server.Listen = ListenUnixPrefix
+		}, []string{"server.listen"}},
+		{"invalid socket mode", func(c *Config) {
+			c.Server.SocketMode = "rw-rw----"
+		}, []string{"server.socket_mode"}},
+		{"invalid trusted proxy", func(c *Config) {
+			c.Server.TrustedProxies = []string{"10.0.0.0/8", "proxy.local"}
+		}, []string{"server.trusted_proxies"}},
+		{"http3 requires tcp", func(c *Config) {
+			c.Server.Listen = ListenSystemd
+			c.Server.HTTP3 = true
+		}, []string{"server.http3"}},
+		{"plaintext skips tls", func(c *Config) {
+			c.Server.Listen = "unix:/run/fs4/fs4.sock"
+			c.Server.Plaintext = true
+			c.TLS.Cert = ""
+		}, nil},
+		{"plaintext over tcp requires trusted proxies", func(c *Config) {
+			c.Server.Plaintext = true
+		}, []string{"server.plaintext"}},
+		{"plaintext conflicts with https features", func(c *Config) {
+			c.Server.Plaintext = true
+			c.Server.TrustedProxies = []string{"127.0.0.1"}
+			c.Server.HTTP3 = true
+			c.Server.RedirectListen = ":80"
+			c.TLS.ClientAuth = ClientAuthOptional
+		}, []string{"server.http3", "server.redirect_listen", "tls.client_auth"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			tt.update(cfg)
+			err := cfg.Validate()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected ValidationError, got %v", err)
+			}
+			if len(verr.Problems) != len(tt.problems) {
+				t.Fatalf("expected %d problems, got %v", len(tt.problems), verr.Problems)
+			}
+			for i, prefix := range tt.problems {
+				if !strings.HasPrefix(verr.Problems[i], prefix+":") {
+					t.Errorf("expected problem %d to be about %s, got '%s'", i, prefix, verr.Problems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTrustedProxyPrefixes(t *testing.T) {
+	s := Server{TrustedProxies: []string{"10.1.2.3/8", "192.168.0.1", "::1"}}
+	prefixes, err := s.TrustedProxyPrefixes()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []string{"10.0.0.0/8", "192.168.0.1/32", "::1/128"}
+	if len(prefixes) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, prefixes)
+	}
+	for i, prefix := range prefixes {
+		if prefix.String() != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], prefix)
+		}
+	}
+}
+
+func TestValidateOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		valid  bool
+	}{
+		{"http://localhost:3000", true},
+		{"https://*.example.com", true},
+		{"*", false},
+		{"https://*.*.example.com", false},
+		{"example.com", false},
+		{"ftp://example.com", false},
+		{"https://example.com/path", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			err := validateOrigin(tt.origin)
+			if (err == nil) != tt.valid {
+				t.Fatalf("expected valid: %v, got error: %v", tt.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/cors"
+)
+
+// CORSOptions configures cross-origin requests to the server.
+// Credentials (the session cookie) are always allowed, so origins must be listed explicitly;
+// each origin may contain a single "*" wildcard, e.g. "https://*.example.com".
+// CORS is disabled entirely if no origins are allowed.
+type CORSOptions struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	ExposedHeaders []string
+	// MaxAge is how long browsers may cache preflight results, zero to use the browser default.
+	MaxAge time.Duration
+}
+
+// DefaultCORSOptions allows the development webapp server.
+var DefaultCORSOptions = CORSOptions{
+	AllowedOrigins: []string{"http://localhost:3000"},
+	AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+	AllowedHeaders: []string{"Authorization", "Content-Type"},
+	ExposedHeaders: []string{"X-Request-ID", "X-Quota-Warning"},
+}
+
+// WithCORS sets the cross-origin request policy.
+func WithCORS(opts CORSOptions) Option {
+	return func(s *Server) {
+		s.cors = opts
+	}
+}
+
+// handler wraps next with the CORS policy, or returns it unchanged if CORS is disabled.
+func (o CORSOptions) handler(next http.Handler) http.Handler {
+	if len(o.AllowedOrigins) == 0 {
+		return next
+	}
+
+	c := cors.New(cors.Options{
+		AllowedOrigins:   o.AllowedOrigins,
+		AllowCredentials: true,
+		AllowedMethods:   o.AllowedMethods,
+		AllowedHeaders:   o.AllowedHeaders,
+		ExposedHeaders:   o.ExposedHeaders,
+		MaxAge:           int(o.MaxAge / time.Second),
+	})
+	return c.Handler(next)
+}
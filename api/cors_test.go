@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+)
+
+func preflight(t *testing.T, s *Server, origin, method string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/files", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestCORS(t *testing.T) {
+	s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend(), WithCORS(CORSOptions{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		AllowedHeaders: []string{"Content-Type"},
+		MaxAge:         10 * time.Minute,
+	}))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	t.Run("wildcard origin", func(t *testing.T) {
+		resp := preflight(t, s, "https://dashboard.example.com", http.MethodDelete)
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://dashboard.example.com" {
+			t.Errorf("expected origin to be allowed, got '%s'", got)
+		}
+		if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Errorf("expected credentials to be allowed, got '%s'", got)
+		}
+		if got := resp.Header.Get("Access-Control-Max-Age"); got != "600" {
+			t.Errorf("expected max age 600, got '%s'", got)
+		}
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		resp := preflight(t, s, "https://evil.test", http.MethodGet)
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("expected origin to be rejected, got '%s'", got)
+		}
+	})
+
+	t.Run("disallowed method", func(t *testing.T) {
+		resp := preflight(t, s, "https://dashboard.example.com", http.MethodPut)
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("expected method to be rejected, got '%s'", got)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend(), WithCORS(CORSOptions{}))
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+		resp := preflight(t, s, "http://localhost:3000", http.MethodGet)
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("expected no CORS headers, got '%s'", got)
+		}
+	})
+}
@@ -0,0 +1,387 @@
+// Package dirsize calculates the total size of directory trees in the background, and caches
+// the results until the trees change.
+//
+// A calculation walks the whole tree below a directory, so it can take a long time for large
+// trees. Calculations run on a fixed number of workers, and report the size and number of
+// entries counted so far while they run. Once done, every directory in the tree is watched for
+// changes, and the result is dropped as soon as one is seen. Trees with too many directories
+// to watch, or that cannot be watched, are recalculated once their result is older than a
+// fixed time instead. Symlinks are counted as entries but not followed, and only regular
+// files count towards the size.
+package dirsize
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/watch"
+)
+
+// State is how far a calculation has got.
+type State string
+
+const (
+	// StateQueued is a calculation waiting for a worker.
+	StateQueued State = "queued"
+	// StateRunning is a calculation walking its tree.
+	StateRunning State = "running"
+	// StateDone is a calculation that has finished, with its result.
+	StateDone State = "done"
+	// StateFailed is a calculation whose directory could not be read. It is tried again once
+	// the failure has been reported.
+	StateFailed State = "failed"
+	// StateUnavailable is a calculation that could not be queued, as the cache is full of
+	// calculations still in progress. It is tried again on the next request.
+	StateUnavailable State = "unavailable"
+)
+
+// Status is the progress of the calculation for a directory, or its result once done.
+type Status struct {
+	// Path is the directory, slash-separated and relative to the root.
+	Path  string `json:"path"`
+	State State  `json:"state"`
+	// Size is the total size in bytes of the regular files below the directory, and Items
+	// the number of entries below it, counted so far while the calculation runs.
+	Size  int64 `json:"size"`
+	Items int64 `json:"items"`
+	// Unreadable is the number of directories below that could not be read, and whose
+	// contents are therefore not counted.
+	Unreadable int64      `json:"unreadable,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Options configures a Calculator.
+type Options struct {
+	// Workers is the number of trees walked at once.
+	Workers int
+	// MaxWatched is the most directories a tree may have for its result to be kept until it
+	// changes.
+	MaxWatched int
+	// TTL is how long results are kept for trees that are not watched.
+	TTL time.Duration
+	// MaxCached is the number of directories whose results are kept, or whose calculations
+	// are queued or running.
+	MaxCached int
+	// Exclude is a directory left out of every calculation, such as the server's own state.
+	Exclude string
+}
+
+// Calculator calculates and caches the sizes of directories under a root.
+type Calculator struct {
+	root    string
+	hub     *watch.Hub
+	opts    Options
+	logger  *slog.Logger
+	now     func() time.Time
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers chan struct{}
+	wg      sync.WaitGroup
+
+	// mutex guards entries and the fields of every entry not updated atomically
+	mutex   sync.Mutex
+	entries map[string]*entry
+	closed  bool
+}
+
+// entry is the calculation for a directory.
+type entry struct {
+	dir   string
+	state State
+	// size, items and unreadable are counted as the tree is walked
+	size       atomic.Int64
+	items      atomic.Int64
+	unreadable atomic.Int64
+	started    time.Time
+	finished   time.Time
+	err        string
+	// expires is when the result is dropped, never if zero, as the tree is watched by sub
+	expires time.Time
+	sub     *watch.Subscription
+}
+
+// New returns a Calculator for directories under root, watching them for changes with hub.
+func New(root string, hub *watch.Hub, opts Options, logger *slog.Logger) *Calculator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Calculator{
+		root:    filepath.Clean(root),
+		hub:     hub,
+		opts:    opts,
+		logger:  logger,
+		now:     time.Now,
+		ctx:     ctx,
+		cancel:  cancel,
+		workers: make(chan struct{}, max(opts.Workers, 1)),
+		entries: map[string]*entry{},
+	}
+}
+
+// Status returns the status of the calculation for dir, an absolute path of a directory under
+// the root, starting one if there is no current result and there is room for it in the cache.
+func (c *Calculator) Status(dir string) Status {
+	dir = filepath.Clean(dir)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[dir]
+	if ok && !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.drop(e)
+		ok = false
+	}
+	if !ok {
+		if e = c.start(dir); e == nil {
+			return Status{Path: c.rel(dir), State: StateUnavailable}
+		}
+	}
+	status := c.status(e)
+	if e.state == StateFailed {
+		// reported once, then tried again
+		c.drop(e)
+	}
+	return status
+}
+
+// Close stops every calculation and watch, waiting for them to finish.
+func (c *Calculator) Close() error {
+	c.cancel()
+	c.mutex.Lock()
+	c.closed = true
+	for _, e := range c.entries {
+		c.drop(e)
+	}
+	c.mutex.Unlock()
+	c.wg.Wait()
+	return nil
+}
+
+// start queues a calculation for dir, returning nil if the cache is full and no result can be
+// dropped to make room for it. The caller must hold c.mutex.
+func (c *Calculator) start(dir string) *entry {
+	if len(c.entries) >= c.opts.MaxCached && !c.evict() {
+		return nil
+	}
+	e := &entry{dir: dir, state: StateQueued}
+	c.entries[dir] = e
+	if !c.closed {
+		c.wg.Add(1)
+		go c.run(e)
+	}
+	return e
+}
+
+// evict drops a finished result to make room for another, reporting whether there was one.
+// Results are dropped in no particular order. The caller must hold c.mutex.
+func (c *Calculator) evict() bool {
+	for _, e := range c.entries {
+		if e.state == StateDone || e.state == StateFailed {
+			c.drop(e)
+			return true
+		}
+	}
+	return false
+}
+
+// drop removes e from the cache and stops watching its tree. The caller must hold c.mutex.
+func (c *Calculator) drop(e *entry) {
+	if c.entries[e.dir] == e {
+		delete(c.entries, e.dir)
+	}
+	if e.sub != nil {
+		e.sub.Close()
+		e.sub = nil
+	}
+}
+
+// run calculates the size of e's tree once a worker is free.
+func (c *Calculator) run(e *entry) {
+	defer c.wg.Done()
+	select {
+	case c.workers <- struct{}{}:
+	case <-c.ctx.Done():
+		return
+	}
+	defer func() { <-c.workers }()
+
+	c.mutex.Lock()
+	if c.entries[e.dir] != e {
+		// evicted or closed while queued
+		c.mutex.Unlock()
+		return
+	}
+	e.state = StateRunning
+	e.started = c.now()
+	c.mutex.Unlock()
+
+	dirs, err := c.walk(e)
+	var sub *watch.Subscription
+	if err == nil {
+		sub = c.subscribe(dirs)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e.finished = c.now()
+	switch {
+	case err != nil:
+		if c.ctx.Err() != nil {
+			// stopped by Close
+			return
+		}
+		c.logger.Warn("Failed to calculate directory size", slog.String("path", c.rel(e.dir)), slog.String("error", err.Error()))
+		e.state = StateFailed
+		e.err = err.Error()
+	case sub == nil:
+		e.state = StateDone
+		e.expires = e.finished.Add(c.opts.TTL)
+	case c.entries[e.dir] != e:
+		sub.Close()
+	default:
+		e.state = StateDone
+		e.sub = sub
+		c.wg.Add(1)
+		go c.watch(e, sub)
+	}
+}
+
+// walk counts the entries below e's directory. It returns the modification time of every
+// directory in the tree, or none if there are more than can be watched.
+func (c *Calculator) walk(e *entry) (map[string]time.Time, error) {
+	dirs := map[string]time.Time{}
+	err := filepath.WalkDir(e.dir, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := c.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if path == e.dir {
+				return err
+			}
+			// counted when it was listed, but its contents cannot be
+			e.unreadable.Add(1)
+			return nil
+		}
+		if path == c.opts.Exclude {
+			return filepath.SkipDir
+		}
+		if path != e.dir {
+			e.items.Add(1)
+		}
+		dirs = c.count(e, path, d, dirs)
+		return nil
+	})
+	return dirs, err
+}
+
+// count adds the entry at path to e's size, and records its modification time in dirs if it is
+// a directory. It returns dirs, or nil once it holds more directories than can be watched.
+func (c *Calculator) count(e *entry, path string, d fs.DirEntry, dirs map[string]time.Time) map[string]time.Time {
+	info, err := d.Info()
+	switch {
+	case err != nil:
+		// removed since it was listed
+	case d.IsDir() && dirs != nil:
+		dirs[path] = info.ModTime()
+		if len(dirs) > c.opts.MaxWatched {
+			return nil
+		}
+	case d.Type().IsRegular():
+		e.size.Add(info.Size())
+	}
+	return dirs
+}
+
+// subscribe watches dirs for changes, returning no subscription if they cannot be watched or
+// one changed while being walked.
+func (c *Calculator) subscribe(dirs map[string]time.Time) *watch.Subscription {
+	if c.hub == nil || len(dirs) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		paths = append(paths, dir)
+	}
+	sub, err := c.hub.Subscribe(paths)
+	if err != nil {
+		c.logger.Debug("Not watching directory tree for size changes", slog.String("error", err.Error()))
+		return nil
+	}
+
+	// entries added or removed since the walk are not included, so the result is already out
+	// of date
+	for dir, modified := range dirs {
+		if info, err := os.Lstat(dir); err != nil || !info.ModTime().Equal(modified) {
+			sub.Close()
+			return nil
+		}
+	}
+	return sub
+}
+
+// watch drops e once its tree changes.
+func (c *Calculator) watch(e *entry, sub *watch.Subscription) {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-sub.Done():
+			// closed, or the hub was, so changes are no longer seen
+		case <-sub.Ready():
+			if !c.changed(sub.Events()) {
+				continue
+			}
+		}
+		c.mutex.Lock()
+		c.drop(e)
+		c.mutex.Unlock()
+		return
+	}
+}
+
+// changed reports whether events include a change other than to the excluded directory.
+func (c *Calculator) changed(events []watch.Event) bool {
+	excludeDir, excludeName := c.rel(filepath.Dir(c.opts.Exclude)), filepath.Base(c.opts.Exclude)
+	for _, e := range events {
+		if c.opts.Exclude == "" || e.Dir != excludeDir || e.Name != excludeName {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the status of e. The caller must hold c.mutex.
+func (c *Calculator) status(e *entry) Status {
+	status := Status{
+		Path:       c.rel(e.dir),
+		State:      e.state,
+		Size:       e.size.Load(),
+		Items:      e.items.Load(),
+		Unreadable: e.unreadable.Load(),
+		Error:      e.err,
+	}
+	if !e.started.IsZero() {
+		started := e.started
+		status.Started = &started
+	}
+	if !e.finished.IsZero() {
+		finished := e.finished
+		status.Finished = &finished
+	}
+	return status
+}
+
+// rel returns path relative to the root in slash-separated form.
+func (c *Calculator) rel(path string) string {
+	rel, err := filepath.Rel(c.root, path)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
@@ -0,0 +1,144 @@
+package dirsize
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/watch"
+)
+
+func testCalculator(t *testing.T, maxWatched int) (*Calculator, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, size := range map[string]int{"a.txt": 3, "docs/b.txt": 5, "docs/sub/c.txt": 7, ".fs4/state.json": 100} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := watch.NewHub(root, logger)
+	opts := Options{Workers: 2, MaxWatched: maxWatched, TTL: time.Hour, MaxCached: 10, Exclude: filepath.Join(root, ".fs4")}
+	c := New(root, hub, opts, logger)
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = hub.Close()
+	})
+	return c, root
+}
+
+// wait polls the status of dir until its calculation is done.
+func wait(t *testing.T, c *Calculator, dir string) Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := c.Status(dir); status.State == StateDone || status.State == StateFailed {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for the size of %s", dir)
+	return Status{}
+}
+
+func TestStatus(t *testing.T) {
+	c, root := testCalculator(t, 100)
+
+	if status := c.Status(root); status.State == StateDone {
+		t.Errorf("expected the first request to start a calculation, got %+v", status)
+	}
+	status := wait(t, c, root)
+	// a.txt, docs, docs/b.txt, docs/sub and docs/sub/c.txt, without the state directory
+	if status.State != StateDone || status.Size != 15 || status.Items != 5 || status.Path != "/" {
+		t.Errorf("expected 15 bytes in 5 items, got %+v", status)
+	}
+	if status := wait(t, c, filepath.Join(root, "docs")); status.Size != 12 || status.Items != 3 || status.Path != "/docs" {
+		t.Errorf("expected 12 bytes in 3 items, got %+v", status)
+	}
+
+	if status := wait(t, c, filepath.Join(root, "missing")); status.State != StateFailed || status.Error == "" {
+		t.Errorf("expected a failed calculation, got %+v", status)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c, root := testCalculator(t, 100)
+	wait(t, c, root)
+	wait(t, c, filepath.Join(root, "docs"))
+
+	// changing the state directory changes nothing
+	if err := os.WriteFile(filepath.Join(root, ".fs4", "other.json"), []byte("{}"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "sub", "d.txt"), make([]byte, 10), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := wait(t, c, root); status.Size == 25 && status.Items == 6 {
+			if status := wait(t, c, filepath.Join(root, "docs")); status.Size != 22 {
+				t.Errorf("expected the subdirectory recalculated too, got %+v", status)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected the size recalculated once the tree changed")
+}
+
+func TestUnwatched(t *testing.T) {
+	c, root := testCalculator(t, 1)
+	wait(t, c, root)
+
+	if err := os.WriteFile(filepath.Join(root, "e.txt"), make([]byte, 10), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if status := c.Status(root); status.State != StateDone || status.Size != 15 {
+		t.Errorf("expected the result kept for a tree too large to watch, got %+v", status)
+	}
+
+	c.mutex.Lock()
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	c.mutex.Unlock()
+	if status := wait(t, c, root); status.Size != 25 {
+		t.Errorf("expected the result recalculated once expired, got %+v", status)
+	}
+}
+
+func TestMaxCached(t *testing.T) {
+	c, root := testCalculator(t, 100)
+	c.opts.MaxCached = 1
+	// keep every worker busy, so calculations stay queued
+	for range cap(c.workers) {
+		c.workers <- struct{}{}
+	}
+
+	if status := c.Status(root); status.State != StateQueued {
+		t.Fatalf("expected a queued calculation, got %+v", status)
+	}
+	docs := filepath.Join(root, "docs")
+	if status := c.Status(docs); status.State != StateUnavailable || status.Path != "/docs" {
+		t.Errorf("expected no room for another calculation, got %+v", status)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("expected 1 cached entry, got %d", len(c.entries))
+	}
+
+	for range cap(c.workers) {
+		<-c.workers
+	}
+	wait(t, c, root)
+	// the finished result makes way for another
+	if status := wait(t, c, docs); status.State != StateDone || status.Size != 12 {
+		t.Errorf("expected 12 bytes once there is room, got %+v", status)
+	}
+}
@@ -0,0 +1,314 @@
+// Package diskusage analyses the disk usage of directory trees, like du or ncdu.
+//
+// An analysis walks the whole tree below a directory, reading directories concurrently on a
+// fixed number of workers shared by every analysis, and stops as soon as its context is done.
+// It returns a tree of the largest entries down to a requested depth, each directory with the
+// total size and number of files below it, broken down by file type and by age. Symlinks are
+// counted as files but not followed, and only regular files count towards sizes.
+package diskusage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotDir is returned when analysing a path that is not a directory.
+var ErrNotDir = errors.New("not a directory")
+
+// Ages are the buckets files are grouped into by the time since they were last modified. Each
+// holds the files modified within its MaxAge, but not the previous one's. The last bucket holds
+// all older files.
+var Ages = []struct {
+	Name   string
+	MaxAge time.Duration
+}{
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"year", 365 * 24 * time.Hour},
+	{"older", 0},
+}
+
+// Usage is the number and total size of a set of files.
+type Usage struct {
+	Files int64 `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// TypeUsage is the usage of the files of a type, named by their lowercase extension without
+// the dot, or empty for files without one.
+type TypeUsage struct {
+	Type string `json:"type"`
+	Usage
+}
+
+// AgeUsage is the usage of the files in one of Ages.
+type AgeUsage struct {
+	Age string `json:"age"`
+	Usage
+}
+
+// Node is a file or directory in an analysed tree.
+type Node struct {
+	Name string `json:"name"`
+	// Path is slash-separated and relative to the root.
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	// Files and Dirs are the number of each below a directory.
+	Files int64 `json:"files,omitempty"`
+	Dirs  int64 `json:"dirs,omitempty"`
+	// Unreadable is the number of directories at or below a directory that could not be read,
+	// and whose contents are therefore not counted.
+	Unreadable int64 `json:"unreadable,omitempty"`
+	// Children are the largest entries of a directory, largest first, down to the requested
+	// depth. Other holds those left out.
+	Children []*Node `json:"children,omitempty"`
+	Other    *Usage  `json:"other,omitempty"`
+	// Types are the file types using the most space below a directory, largest first, with
+	// the rest under the type "*". Ages are the files below it in each of Ages.
+	Types []TypeUsage `json:"types,omitempty"`
+	Ages  []AgeUsage  `json:"ages,omitempty"`
+
+	types map[string]Usage
+	ages  []Usage
+}
+
+// Options configures an analysis.
+type Options struct {
+	// Depth is the number of levels of children included below the analysed directory.
+	Depth int
+	// Limit is the number of children included for each directory, and of file types.
+	Limit int
+}
+
+// Analyzer analyses directories under a root.
+type Analyzer struct {
+	root    string
+	exclude string
+	now     func() time.Time
+	// workers holds a token for each directory being read by a worker, rather than by the
+	// goroutine that found it
+	workers chan struct{}
+}
+
+// New returns an Analyzer for directories under root, reading up to workers directories at
+// once besides one per analysis. The exclude directory, such as the server's own state, is
+// left out of every analysis.
+func New(root string, workers int, exclude string) *Analyzer {
+	return &Analyzer{
+		root:    filepath.Clean(root),
+		exclude: exclude,
+		now:     time.Now,
+		workers: make(chan struct{}, max(workers, 0)),
+	}
+}
+
+// Analyze returns the tree below dir, an absolute path of a directory under the root. It
+// returns ctx's error if ctx is done before the analysis is.
+func (a *Analyzer) Analyze(ctx context.Context, dir string, opts Options) (*Node, error) {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, ErrNotDir
+	}
+
+	s := &scan{analyzer: a, ctx: ctx, now: a.now(), opts: opts}
+	root := a.node(filepath.Clean(dir), info)
+	s.dir(root, opts.Depth)
+	s.wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.finish(root, opts.Depth)
+	return root, nil
+}
+
+// node returns the node for the entry at path.
+func (a *Analyzer) node(path string, info fs.FileInfo) *Node {
+	n := &Node{Name: info.Name(), Path: a.rel(path), Type: "file", Modified: info.ModTime()}
+	if info.IsDir() {
+		n.Type = "dir"
+		n.types = map[string]Usage{}
+		n.ages = make([]Usage, len(Ages))
+	} else if info.Mode().IsRegular() {
+		n.Size = info.Size()
+	}
+	return n
+}
+
+// rel returns path relative to the root in slash-separated form.
+func (a *Analyzer) rel(path string) string {
+	rel, err := filepath.Rel(a.root, path)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// scan is a single analysis in progress.
+type scan struct {
+	analyzer *Analyzer
+	ctx      context.Context
+	now      time.Time
+	opts     Options
+	wg       sync.WaitGroup
+}
+
+// dir reads the directory n, and every directory below it. Files are counted as they are
+// read, and only kept as children if n is above depth. Subdirectories are counted once the
+// scan's wait group is done.
+func (s *scan) dir(n *Node, depth int) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	path := filepath.Join(s.analyzer.root, filepath.FromSlash(n.Path))
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		n.Unreadable++
+	}
+	for _, entry := range entries {
+		childPath := filepath.Join(path, entry.Name())
+		info, err := entry.Info()
+		if err != nil || childPath == s.analyzer.exclude {
+			// removed since it was listed, or left out
+			continue
+		}
+		child := s.analyzer.node(childPath, info)
+		if child.Type == "dir" {
+			n.Children = append(n.Children, child)
+			s.subdir(child, depth-1)
+			continue
+		}
+		s.file(n, child)
+		if depth > 0 {
+			n.Children = append(n.Children, child)
+		}
+	}
+}
+
+// file counts the file child in its directory n.
+func (s *scan) file(n, child *Node) {
+	n.Files++
+	n.Size += child.Size
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(child.Name), "."))
+	n.types[ext] = n.types[ext].plus(1, child.Size)
+	age := s.age(child.Modified)
+	n.ages[age] = n.ages[age].plus(1, child.Size)
+}
+
+// subdir reads the directory n on a free worker, or in the calling goroutine if none is free,
+// so that a scan always progresses however many others are running.
+func (s *scan) subdir(n *Node, depth int) {
+	select {
+	case s.analyzer.workers <- struct{}{}:
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-s.analyzer.workers }()
+			s.dir(n, depth)
+		}()
+	default:
+		s.dir(n, depth)
+	}
+}
+
+// finish totals the usage below n once every directory has been read, and drops the children
+// below depth and the least used beyond the limit.
+func (s *scan) finish(n *Node, depth int) {
+	for _, child := range n.Children {
+		if child.Type == "dir" {
+			s.finish(child, depth-1)
+			n.add(child)
+		}
+	}
+
+	slices.SortFunc(n.Children, func(a, b *Node) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Name, b.Name))
+	})
+	if depth <= 0 {
+		n.Children = nil
+	} else if len(n.Children) > s.opts.Limit {
+		other := Usage{}
+		for _, child := range n.Children[s.opts.Limit:] {
+			other = other.plus(child.files(), child.Size)
+		}
+		n.Other = &other
+		n.Children = n.Children[:s.opts.Limit]
+	}
+	n.Types = topTypes(n.types, s.opts.Limit)
+	n.Ages = make([]AgeUsage, len(Ages))
+	for i, usage := range n.ages {
+		n.Ages[i] = AgeUsage{Age: Ages[i].Name, Usage: usage}
+	}
+}
+
+// files returns the number of files n is, or holds if it is a directory.
+func (n *Node) files() int64 {
+	if n.Type == "dir" {
+		return n.Files
+	}
+	return 1
+}
+
+// add adds the totals of the subdirectory child to n.
+func (n *Node) add(child *Node) {
+	n.Size += child.Size
+	n.Files += child.Files
+	n.Dirs += child.Dirs + 1
+	n.Unreadable += child.Unreadable
+	for ext, usage := range child.types {
+		n.types[ext] = n.types[ext].plus(usage.Files, usage.Size)
+	}
+	for i, usage := range child.ages {
+		n.ages[i] = n.ages[i].plus(usage.Files, usage.Size)
+	}
+	// only needed to total n
+	child.types, child.ages = nil, nil
+}
+
+// age returns the index in Ages of the bucket for files last modified at modified.
+func (s *scan) age(modified time.Time) int {
+	age := s.now.Sub(modified)
+	for i, bucket := range Ages[:len(Ages)-1] {
+		if age < bucket.MaxAge {
+			return i
+		}
+	}
+	return len(Ages) - 1
+}
+
+func (u Usage) plus(files, size int64) Usage {
+	return Usage{Files: u.Files + files, Size: u.Size + size}
+}
+
+// topTypes returns the limit types using the most space, largest first, with the others
+// under the type "*".
+func topTypes(types map[string]Usage, limit int) []TypeUsage {
+	top := make([]TypeUsage, 0, len(types))
+	for ext, usage := range types {
+		top = append(top, TypeUsage{Type: ext, Usage: usage})
+	}
+	slices.SortFunc(top, func(a, b TypeUsage) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Type, b.Type))
+	})
+	if len(top) <= limit {
+		return top
+	}
+	other := TypeUsage{Type: "*"}
+	for _, t := range top[limit:] {
+		other.Usage = other.plus(t.Files, t.Size)
+	}
+	return append(top[:limit], other)
+}
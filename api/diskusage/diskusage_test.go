@@ -0,0 +1,94 @@
+package diskusage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	for name, size := range map[string]int{
+		"a.txt":          1,
+		"docs/b.TXT":     10,
+		"docs/c.pdf":     20,
+		"docs/old/d.pdf": 40,
+		"media/e.jpg":    100,
+		"media/f":        5,
+		".fs4/state":     1000,
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(root, "docs", "old", "d.pdf"), old, old); err != nil {
+		t.Fatalf("failed to set times: %v", err)
+	}
+	return root
+}
+
+func TestAnalyze(t *testing.T) {
+	root := testTree(t)
+	a := New(root, 2, filepath.Join(root, ".fs4"))
+
+	tree, err := a.Analyze(context.Background(), root, Options{Depth: 1, Limit: 2})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if tree.Path != "/" || tree.Size != 176 || tree.Files != 6 || tree.Dirs != 3 {
+		t.Errorf("expected 176 bytes in 6 files and 3 directories, got %+v", tree)
+	}
+	if len(tree.Children) != 2 || tree.Children[0].Path != "/media" || tree.Children[1].Path != "/docs" {
+		t.Fatalf("expected the two largest children, got %+v", tree.Children)
+	}
+	if tree.Children[1].Size != 70 || tree.Children[1].Files != 3 || tree.Children[1].Children != nil {
+		t.Errorf("expected docs totalled without its children, got %+v", tree.Children[1])
+	}
+	if tree.Other == nil || tree.Other.Files != 1 || tree.Other.Size != 1 {
+		t.Errorf("expected a.txt left out, got %+v", tree.Other)
+	}
+
+	expectedTypes := []TypeUsage{{"jpg", Usage{1, 100}}, {"pdf", Usage{2, 60}}, {"*", Usage{3, 16}}}
+	if len(tree.Types) != len(expectedTypes) {
+		t.Fatalf("expected types %v, got %v", expectedTypes, tree.Types)
+	}
+	for i, expected := range expectedTypes {
+		if tree.Types[i] != expected {
+			t.Errorf("expected type %v, got %v", expected, tree.Types[i])
+		}
+	}
+	if tree.Ages[0] != (AgeUsage{"day", Usage{5, 136}}) || tree.Ages[3] != (AgeUsage{"year", Usage{1, 40}}) {
+		t.Errorf("expected files grouped by age, got %v", tree.Ages)
+	}
+
+	tree, err = a.Analyze(context.Background(), filepath.Join(root, "docs"), Options{Depth: 2, Limit: 10})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(tree.Children) != 3 || tree.Children[0].Path != "/docs/old" || len(tree.Children[0].Children) != 1 {
+		t.Errorf("expected two levels of children, got %+v", tree.Children)
+	}
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	root := testTree(t)
+	a := New(root, 0, "")
+
+	if _, err := a.Analyze(context.Background(), filepath.Join(root, "a.txt"), Options{}); !errors.Is(err, ErrNotDir) {
+		t.Errorf("expected %v, got %v", ErrNotDir, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.Analyze(ctx, root, Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
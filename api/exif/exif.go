@@ -0,0 +1,181 @@
+// Package exif reads the few EXIF tags fs4 uses from JPEG images: the orientation, used to
+// display thumbnails upright, and the time the photo was taken.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	markerSOI  = 0xD8 // start of image
+	markerEOI  = 0xD9 // end of image
+	markerSOS  = 0xDA // start of scan, after which there is only image data
+	markerAPP1 = 0xE1 // holds EXIF data
+
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+
+	// dateTimeLayout is the layout of EXIF date tags, in the camera's local time.
+	dateTimeLayout = "2006:01:02 15:04:05"
+)
+
+// Tags are the EXIF tags of an image. Missing tags are zero.
+type Tags struct {
+	// Orientation is from 1 to 8: upright, or the flips and rotations in the order defined
+	// by EXIF.
+	Orientation int
+	// Taken is when the photo was taken, in the camera's local time but with a UTC location,
+	// as EXIF does not record the time zone.
+	Taken time.Time
+}
+
+// Read returns the EXIF tags of the JPEG read from r. ok is false if it has no EXIF data.
+// Only the headers are read, up to the start of the image data.
+func Read(r io.Reader) (tags Tags, ok bool) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, markerSOI} {
+		return Tags{}, false
+	}
+	for {
+		marker, data, err := nextSegment(br)
+		if err != nil || marker == markerSOS || marker == markerEOI {
+			return Tags{}, false
+		}
+		if tiff, ok := bytes.CutPrefix(data, []byte("Exif\x00\x00")); marker == markerAPP1 && ok {
+			return parseTIFF(tiff)
+		}
+	}
+}
+
+// nextSegment reads the next JPEG marker segment, returning its payload if it is APP1.
+func nextSegment(br *bufio.Reader) (byte, []byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if b != 0xFF {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	marker := byte(0xFF)
+	// markers may be preceded by any number of fill bytes
+	for marker == 0xFF {
+		if marker, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if marker == markerSOS || marker == markerEOI {
+		return marker, nil, nil
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(br, length[:]); err != nil {
+		return 0, nil, err
+	}
+	// the length includes its own two bytes
+	n := int(binary.BigEndian.Uint16(length[:])) - 2
+	if n < 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if marker != markerAPP1 {
+		_, err := br.Discard(n)
+		return marker, nil, err
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(br, data)
+	return marker, data, err
+}
+
+// tiff is the TIFF structure holding EXIF tags.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// entry is a directory entry, pointing at the 12 bytes of a tag in an IFD.
+type entry []byte
+
+func parseTIFF(data []byte) (Tags, bool) {
+	if len(data) < 8 {
+		return Tags{}, false
+	}
+	t := tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return Tags{}, false
+	}
+
+	ifd0 := t.entries(int64(t.order.Uint32(data[4:])))
+	tags := Tags{Orientation: t.orientation(ifd0[tagOrientation])}
+	if e, ok := ifd0[tagExifIFD]; ok && t.order.Uint16(e[2:]) == typeLong {
+		sub := t.entries(int64(t.order.Uint32(e[8:])))
+		tags.Taken = t.dateTime(sub[tagDateTimeOriginal])
+	}
+	if tags.Taken.IsZero() {
+		tags.Taken = t.dateTime(ifd0[tagDateTime])
+	}
+	return tags, true
+}
+
+// entries returns the entries of the IFD at offset by tag, skipping any beyond the data.
+func (t tiff) entries(offset int64) map[uint16]entry {
+	entries := map[uint16]entry{}
+	if offset < 0 || offset+2 > int64(len(t.data)) {
+		return entries
+	}
+	count := int64(t.order.Uint16(t.data[offset:]))
+	for i := int64(0); i < count; i++ {
+		start := offset + 2 + i*12
+		if start+12 > int64(len(t.data)) {
+			break
+		}
+		e := entry(t.data[start : start+12])
+		entries[t.order.Uint16(e)] = e
+	}
+	return entries
+}
+
+// orientation parses an orientation entry, returning zero if it is missing or invalid.
+func (t tiff) orientation(e entry) int {
+	// a single SHORT, stored at the start of the value field
+	if e == nil || t.order.Uint16(e[2:]) != typeShort {
+		return 0
+	}
+	if o := int(t.order.Uint16(e[8:])); o >= 1 && o <= 8 {
+		return o
+	}
+	return 0
+}
+
+// dateTime parses an EXIF date entry, returning the zero time if it is missing or invalid.
+func (t tiff) dateTime(e entry) time.Time {
+	// a 20 byte string, too long to store in the entry so stored at an offset
+	if e == nil || t.order.Uint16(e[2:]) != typeASCII || t.order.Uint32(e[4:]) != 20 {
+		return time.Time{}
+	}
+	offset := int64(t.order.Uint32(e[8:]))
+	if offset+20 > int64(len(t.data)) {
+		return time.Time{}
+	}
+	value := strings.TrimRight(string(t.data[offset:offset+20]), "\x00")
+	taken, err := time.Parse(dateTimeLayout, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return taken
+}
@@ -0,0 +1,129 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// tiffEntry is an IFD entry for buildTIFF. Values of ASCII entries are stored after the IFDs.
+type tiffEntry struct {
+	tag, typ uint16
+	value    uint32
+	text     string
+}
+
+// buildTIFF returns TIFF data with ifd0 and, if it is not empty, an EXIF sub-IFD.
+func buildTIFF(order binary.AppendByteOrder, ifd0, sub []tiffEntry) []byte {
+	header := []byte("II*\x00")
+	if order == binary.BigEndian {
+		header = []byte("MM\x00*")
+	}
+	data := order.AppendUint32(header, 8)
+
+	ifdSize := func(entries []tiffEntry) uint32 { return uint32(2 + len(entries)*12 + 4) }
+	if len(sub) > 0 {
+		// the sub-IFD follows IFD0, including this entry
+		ifd0 = append(ifd0, tiffEntry{tag: tagExifIFD, typ: typeLong, value: 8 + ifdSize(ifd0) + 12})
+	}
+	textOffset := 8 + ifdSize(ifd0)
+	if len(sub) > 0 {
+		textOffset += ifdSize(sub)
+	}
+
+	var text []byte
+	writeIFD := func(entries []tiffEntry) {
+		data = order.AppendUint16(data, uint16(len(entries)))
+		for _, e := range entries {
+			data = order.AppendUint16(data, e.tag)
+			data = order.AppendUint16(data, e.typ)
+			switch {
+			case e.text != "":
+				data = order.AppendUint32(data, uint32(len(e.text)+1))
+				data = order.AppendUint32(data, textOffset+uint32(len(text)))
+				text = append(append(text, e.text...), 0)
+			case e.typ == typeShort:
+				data = order.AppendUint32(data, 1)
+				data = order.AppendUint16(data, uint16(e.value))
+				data = append(data, 0, 0)
+			default:
+				data = order.AppendUint32(data, 1)
+				data = order.AppendUint32(data, e.value)
+			}
+		}
+		data = order.AppendUint32(data, 0)
+	}
+	writeIFD(ifd0)
+	if len(sub) > 0 {
+		writeIFD(sub)
+	}
+	return append(data, text...)
+}
+
+// jpegWithTIFF returns the start of a JPEG holding tiff as its EXIF data.
+func jpegWithTIFF(tiff []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xFF, markerSOI}
+	// an unrelated segment first, as written by most cameras
+	data = append(data, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00)
+	data = append(data, 0xFF, markerAPP1)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(payload)+2))
+	data = append(data, payload...)
+	return append(data, 0xFF, markerSOS)
+}
+
+func TestRead(t *testing.T) {
+	taken := time.Date(2023, 7, 14, 18, 30, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expected Tags
+		ok       bool
+	}{
+		{
+			name: "little endian",
+			data: jpegWithTIFF(buildTIFF(binary.LittleEndian,
+				[]tiffEntry{{tag: tagOrientation, typ: typeShort, value: 6}},
+				[]tiffEntry{{tag: tagDateTimeOriginal, typ: typeASCII, text: "2023:07:14 18:30:05"}})),
+			expected: Tags{Orientation: 6, Taken: taken},
+			ok:       true,
+		},
+		{
+			name: "big endian",
+			data: jpegWithTIFF(buildTIFF(binary.BigEndian,
+				[]tiffEntry{{tag: tagOrientation, typ: typeShort, value: 8}},
+				[]tiffEntry{{tag: tagDateTimeOriginal, typ: typeASCII, text: "2023:07:14 18:30:05"}})),
+			expected: Tags{Orientation: 8, Taken: taken},
+			ok:       true,
+		},
+		{
+			name: "modification date",
+			data: jpegWithTIFF(buildTIFF(binary.LittleEndian,
+				[]tiffEntry{{tag: tagDateTime, typ: typeASCII, text: "2023:07:14 18:30:05"}}, nil)),
+			expected: Tags{Taken: taken},
+			ok:       true,
+		},
+		{
+			name: "invalid values",
+			data: jpegWithTIFF(buildTIFF(binary.LittleEndian,
+				[]tiffEntry{{tag: tagOrientation, typ: typeShort, value: 9}},
+				[]tiffEntry{{tag: tagDateTimeOriginal, typ: typeASCII, text: "0000:00:00 00:00:00"}})),
+			ok: true,
+		},
+		{
+			name: "sub-IFD out of range",
+			data: jpegWithTIFF(buildTIFF(binary.LittleEndian,
+				[]tiffEntry{{tag: tagExifIFD, typ: typeLong, value: 1 << 30}}, nil)),
+			ok: true,
+		},
+		{name: "no exif", data: []byte{0xFF, markerSOI, 0xFF, markerSOS}},
+		{name: "truncated", data: jpegWithTIFF(buildTIFF(binary.LittleEndian, nil, nil))[:12]},
+		{name: "not a jpeg", data: []byte("GIF89a")},
+	} {
+		tags, ok := Read(bytes.NewReader(tc.data))
+		if ok != tc.ok || tags != tc.expected {
+			t.Errorf("%s: expected %+v (%v), got %+v (%v)", tc.name, tc.expected, tc.ok, tags, ok)
+		}
+	}
+}
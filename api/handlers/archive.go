@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+)
+
+// maxArchivePaths limits the number of paths selected for a single archive.
+const maxArchivePaths = 100
+
+var (
+	// ErrFileNotFound is returned when a requested file or directory does not exist.
+	ErrFileNotFound = errors.New("file not found")
+	// ErrArchiveFormat is returned when an unsupported archive format is requested.
+	ErrArchiveFormat = errors.New("unsupported archive format, expected zip or tar.gz")
+	// ErrArchivePaths is returned when the paths selected for an archive are unusable.
+	ErrArchivePaths = fmt.Errorf("between 1 and %d paths with distinct names must be selected", maxArchivePaths)
+)
+
+type archiveFormat struct {
+	contentType string
+	extension   string
+	newWriter   func(io.Writer) archiveWriter
+}
+
+var archiveFormats = map[string]archiveFormat{
+	"zip":    {"application/zip", ".zip", newZipArchive},
+	"tar.gz": {"application/gzip", ".tar.gz", newTarArchive},
+}
+
+// archiveEntry is a file or directory selected for an archive.
+type archiveEntry struct {
+	// name is the entry's name at the top of the archive.
+	name string
+	// path is the entry's location on disk, with symlinks resolved.
+	path string
+	// root is the served directory, with symlinks resolved. Symlinks below path are only
+	// followed to files inside it.
+	root string
+}
+
+// ArchiveHandler is the handler for the /archive endpoint.
+// It streams the files and directories named by the path query parameters as an archive in
+// the requested format, zip by default. Directories are included recursively, and each
+// selection is placed at the top of the archive under its own name.
+// The archive is written as it is read from disk. Entries that cannot be read are left out,
+// as are symlinks unless they point to a regular file under rootDir.
+func ArchiveHandler(rootDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		formatName, format, err := requestedFormat(query.Get("format"))
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := archiveEntries(rootDir, query["path"])
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveArchive(w, r, "/", entries, format, audit.Event{Action: audit.ActionDownload, Detail: formatName})
+	}
+}
+
+// requestedFormat returns the archive format with name, zip if it is empty.
+func requestedFormat(name string) (string, archiveFormat, error) {
+	if name == "" {
+		name = "zip"
+	}
+	format, ok := archiveFormats[name]
+	if !ok {
+		return "", archiveFormat{}, ErrArchiveFormat
+	}
+	return name, format, nil
+}
+
+// serveArchive streams entries as an archive in format, recording event for each of them.
+// Entries are recorded at their paths below dir, the slash-separated path of the directory
+// they were resolved in, relative to the root.
+func serveArchive(w http.ResponseWriter, r *http.Request, dir string, entries []archiveEntry, format archiveFormat, event audit.Event) {
+	name := "download"
+	if len(entries) == 1 {
+		name = entries[0].name
+	}
+	w.Header().Set("Content-Type", format.contentType)
+	setAttachment(w, name+format.extension)
+	w.WriteHeader(http.StatusOK)
+
+	err := writeArchive(r.Context(), format.newWriter(w), entries)
+	for _, entry := range entries {
+		event := event
+		event.Outcome = audit.OutcomeSuccess
+		// entry.path has its symlinks resolved, as has entry.root but not necessarily dir
+		event.Path = path.Join(dir, relPath(entry.root, entry.path))
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+		}
+		audit.Record(r, event)
+	}
+	if err != nil && r.Context().Err() == nil {
+		// the response has started, so abort the connection rather than let the
+		// client mistake a truncated archive for a complete one
+		log.Printf("Failed to write archive: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// archiveEntries resolves the requested paths to entries, rejecting any that are missing,
+// resolve outside rootDir, or would share a name in the archive.
+func archiveEntries(rootDir string, paths []string) ([]archiveEntry, error) {
+	if len(paths) == 0 || len(paths) > maxArchivePaths {
+		return nil, ErrArchivePaths
+	}
+	root, err := filepath.EvalSymlinks(rootDir)
+	if err != nil {
+		return nil, ErrDirRead
+	}
+
+	names := map[string]bool{}
+	entries := make([]archiveEntry, 0, len(paths))
+	for _, p := range paths {
+		clean, err := cleanPath(rootDir, p)
+		if err != nil {
+			return nil, ErrInvalidPath
+		}
+		resolved, err := filepath.EvalSymlinks(clean)
+		if err != nil || !withinRoot(root, resolved) {
+			return nil, ErrFileNotFound
+		}
+
+		name := filepath.Base(clean)
+		if names[name] {
+			return nil, ErrArchivePaths
+		}
+		names[name] = true
+		entries = append(entries, archiveEntry{name: name, path: resolved, root: root})
+	}
+	return entries, nil
+}
+
+// withinDir reports whether path is dir or inside it.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// writeArchive writes entries to a and closes it, stopping if ctx is done.
+func writeArchive(ctx context.Context, a archiveWriter, entries []archiveEntry) error {
+	for _, entry := range entries {
+		if err := addTree(ctx, a, entry); err != nil {
+			return err
+		}
+	}
+	return a.Close()
+}
+
+// addTree adds entry and, if it is a directory, everything below it. Symlinked directories
+// below it are not followed, so the walk cannot loop.
+func addTree(ctx context.Context, a archiveWriter, entry archiveEntry) error {
+	return filepath.WalkDir(entry.path, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			// unreadable, so leave it out
+			return nil
+		}
+
+		rel, err := filepath.Rel(entry.path, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(entry.name, filepath.ToSlash(rel))
+
+		switch {
+		case d.IsDir() && isStatePath(entry.root, p):
+			return filepath.SkipDir
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			return a.addDir(name, info)
+		case d.Type()&fs.ModeSymlink != 0:
+			return addLink(ctx, a, entry.root, name, p)
+		case d.Type().IsRegular():
+			return addFile(ctx, a, name, p)
+		default:
+			// devices, sockets and pipes, which could block reading
+			return nil
+		}
+	})
+}
+
+// addLink adds the file a symlink points to, if it is a regular file inside root.
+func addLink(ctx context.Context, a archiveWriter, root, name, link string) error {
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil || !withinRoot(root, target) {
+		return nil
+	}
+	// checked before opening, as opening a pipe would block
+	if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return addFile(ctx, a, name, target)
+}
+
+// addFile adds the regular file at p. Files that cannot be opened are left out.
+func addFile(ctx context.Context, a archiveWriter, name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer closeFile(f)
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return a.addFile(name, info, io.LimitReader(contextReader{ctx, f}, info.Size()))
+}
+
+// contextReader stops reading once ctx is done, so that large files are not read to the end
+// for a client that has gone away.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// archiveWriter writes entries to an archive stream.
+type archiveWriter interface {
+	addDir(name string, info fs.FileInfo) error
+	// addFile adds a file of info.Size() bytes read from r.
+	addFile(name string, info fs.FileInfo, r io.Reader) error
+	Close() error
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func newZipArchive(w io.Writer) archiveWriter {
+	return zipArchive{zip.NewWriter(w)}
+}
+
+func (z zipArchive) addDir(name string, info fs.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	_, err = z.w.CreateHeader(header)
+	return err
+}
+
+func (z zipArchive) addFile(name string, info fs.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	fw, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (z zipArchive) Close() error {
+	return z.w.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	w  *tar.Writer
+}
+
+func newTarArchive(w io.Writer) archiveWriter {
+	gz := gzip.NewWriter(w)
+	return tarArchive{gz: gz, w: tar.NewWriter(gz)}
+}
+
+func (t tarArchive) addDir(name string, info fs.FileInfo) error {
+	return t.writeHeader(name+"/", info)
+}
+
+func (t tarArchive) addFile(name string, info fs.FileInfo, r io.Reader) error {
+	if err := t.writeHeader(name, info); err != nil {
+		return err
+	}
+	n, err := io.Copy(t.w, r)
+	if err == nil && n < info.Size() {
+		// the file shrank while being read, and the header promised more
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (t tarArchive) writeHeader(name string, info fs.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// owners on the server mean nothing to the client
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+	return t.w.WriteHeader(header)
+}
+
+func (t tarArchive) Close() error {
+	return errors.Join(t.w.Close(), t.gz.Close())
+}
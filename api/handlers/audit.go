@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+)
+
+// ErrInvalidQuery is returned when query parameters cannot be parsed.
+var ErrInvalidQuery = errors.New("invalid query parameters")
+
+type auditResponse struct {
+	Events []audit.Event `json:"events"`
+}
+
+// AuditHandler is the handler for the /audit endpoint.
+// It returns audit events filtered by the since, until, user, action and limit query parameters.
+func AuditHandler(auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseAuditQuery(r)
+		if err != nil {
+			RespondWithError(w, ErrInvalidQuery.Error(), http.StatusBadRequest)
+			return
+		}
+
+		events, err := auditLog.Query(q)
+		if err != nil {
+			RespondWithError(w, "Failed to read audit log", http.StatusInternalServerError)
+			return
+		}
+		if events == nil {
+			events = []audit.Event{}
+		}
+
+		RespondWithJSON(w, auditResponse{Events: events}, http.StatusOK)
+	}
+}
+
+func parseAuditQuery(r *http.Request) (audit.Query, error) {
+	params := r.URL.Query()
+	q := audit.Query{
+		User:   params.Get("user"),
+		Action: audit.Action(params.Get("action")),
+	}
+
+	var err error
+	if v := params.Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, err
+		}
+	}
+	if v := params.Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, err
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return q, ErrInvalidQuery
+		}
+	}
+
+	return q, nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/checksum"
+)
+
+// maxDigestSize is the largest file hashed in the background after a download, so that later
+// downloads have Digest and ETag headers. Larger files only get them once their checksum is
+// cached by a request to /checksum.
+const maxDigestSize = 64 << 20
+
+// ErrChecksum is returned when checksums cannot be computed.
+var ErrChecksum = errors.New("failed to compute checksum")
+
+type checksumResponse struct {
+	Name      string        `json:"name"`
+	Size      int64         `json:"size"`
+	Modified  time.Time     `json:"modified"`
+	Checksums checksum.Sums `json:"checksums"`
+}
+
+// ChecksumHandler is the handler for the /checksum endpoint.
+// It responds with the checksums of the file named by the path query parameter, for each
+// algorithm query parameter, sha256 by default. Checksums are cached until the file changes.
+func ChecksumHandler(rootDir string, sums *checksum.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		path, err := cleanPath(rootDir, query.Get("path"))
+		if err != nil {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+		algorithms := query["algorithm"]
+		if len(algorithms) == 0 {
+			algorithms = []string{checksum.SHA256}
+		}
+
+		event := audit.Event{Action: audit.ActionChecksum, Path: relPath(rootDir, path)}
+		if !resolvesWithin(rootDir, path) {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = ErrFileNotFound.Error()
+			audit.Record(r, event)
+			RespondWithError(w, ErrFileNotFound.Error(), http.StatusBadRequest)
+			return
+		}
+
+		info, statErr := os.Stat(path)
+		result, err := sums.Sum(r.Context(), path, algorithms...)
+		if err == nil {
+			err = statErr
+		}
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			if r.Context().Err() == nil {
+				message, code := checksumError(err)
+				RespondWithError(w, message, code)
+			}
+			return
+		}
+
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		RespondWithJSON(w, checksumResponse{
+			Name:      info.Name(),
+			Size:      info.Size(),
+			Modified:  info.ModTime(),
+			Checksums: result,
+		}, http.StatusOK)
+	}
+}
+
+// checksumError returns the message and status code for an error computing checksums.
+func checksumError(err error) (string, int) {
+	switch {
+	case errors.Is(err, checksum.ErrUnsupported):
+		return checksum.ErrUnsupported.Error(), http.StatusBadRequest
+	case errors.Is(err, checksum.ErrNotFile):
+		return ErrNotFile.Error(), http.StatusBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		return ErrFileNotFound.Error(), http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrChecksum.Error(), http.StatusServiceUnavailable
+	default:
+		log.Printf("Failed to compute checksum: %v", err)
+		return ErrChecksum.Error(), http.StatusInternalServerError
+	}
+}
+
+// setDigest sets the Digest, Repr-Digest and ETag headers of a download of the file at path,
+// described by info, from its SHA-256 checksum if it is cached. Downloads never wait for a file to be hashed, but
+// small files are hashed in the background for the next.
+func setDigest(w http.ResponseWriter, sums *checksum.Cache, path string, info os.FileInfo) {
+	sum, ok := sums.CachedInfo(info, checksum.SHA256)
+	if !ok {
+		if info.Size() <= maxDigestSize {
+			sums.Warm(path, checksum.SHA256)
+		}
+		return
+	}
+	raw, err := hex.DecodeString(sum)
+	if err != nil {
+		return
+	}
+
+	digest := base64.StdEncoding.EncodeToString(raw)
+	w.Header().Set("ETag", `"`+sum+`"`)
+	// Digest is from RFC 3230, which Repr-Digest replaces in RFC 9530
+	w.Header().Set("Digest", "sha-256="+digest)
+	w.Header().Set("Repr-Digest", "sha-256=:"+digest+":")
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/checksum"
+	"github.com/goteleport-interview/fs4/api/metadata"
+	"github.com/goteleport-interview/fs4/api/versions"
+)
+
+// Fields that can be requested in listings.
+const (
+	fieldMIME   = "mime"
+	fieldMode   = "mode"
+	fieldOwner  = "owner"
+	fieldTarget = "target"
+	fieldHidden = "hidden"
+	fieldImage  = "image"
+	fieldPages  = "pages"
+	// fieldVersions is the number of previous versions kept of a file.
+	fieldVersions = "versions"
+)
+
+// ErrInvalidFields is returned when an unknown field is requested.
+var ErrInvalidFields = errors.New("invalid fields, expected any of mime, mode, owner, target, hidden, image, pages, versions " +
+	"and the checksum algorithms sha256, sha1, md5 and blake2b")
+
+// fileDetails are the optional fields of a listing entry, included if requested.
+type fileDetails struct {
+	// MIMEType is sniffed from the contents of regular files.
+	MIMEType string `json:"mimeType,omitempty"`
+	// Mode is the type and permissions, e.g. "-rw-r--r--".
+	Mode  string `json:"mode,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+	// Target is where a symlink points, as a path from the root. It is omitted for links
+	// that are broken or lead outside the root.
+	Target string `json:"target,omitempty"`
+	Hidden *bool  `json:"hidden,omitempty"`
+	// Width and Height are the dimensions of images, and Taken the date photos were taken
+	// in the camera's local time.
+	Width  int        `json:"width,omitempty"`
+	Height int        `json:"height,omitempty"`
+	Taken  *time.Time `json:"taken,omitempty"`
+	// Pages is the page count of PDFs.
+	Pages int `json:"pages,omitempty"`
+	// Checksums of regular files, by algorithm.
+	Checksums checksum.Sums `json:"checksums,omitempty"`
+	// Versions is the number of previous versions kept of regular files.
+	Versions int `json:"versions,omitempty"`
+}
+
+type detailFields map[string]bool
+
+// algorithms returns the checksum algorithms among the fields, in a stable order.
+func (f detailFields) algorithms() []string {
+	var algorithms []string
+	for _, algorithm := range []string{checksum.SHA256, checksum.SHA1, checksum.MD5, checksum.BLAKE2b} {
+		if f[algorithm] {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms
+}
+
+func parseFields(fields []string) (detailFields, error) {
+	parsed := detailFields{}
+	for _, field := range fields {
+		switch field {
+		case fieldMIME, fieldMode, fieldOwner, fieldTarget, fieldHidden, fieldImage, fieldPages, fieldVersions:
+			parsed[field] = true
+		default:
+			if !checksum.Supported(field) {
+				return nil, ErrInvalidFields
+			}
+			parsed[field] = true
+		}
+	}
+	return parsed, nil
+}
+
+// addDetails adds the requested fields to a listing of the directory at path. Versions are
+// only counted if history is not nil.
+func addDetails(ctx context.Context, rootDir, path string, response *filesResponse, fields detailFields, sums *checksum.Cache, history *versions.Store) {
+	root, _ := filepath.EvalSymlinks(rootDir)
+	d := detailer{
+		ctx:        ctx,
+		root:       root,
+		fields:     fields,
+		sums:       sums,
+		history:    history,
+		algorithms: fields.algorithms(),
+		users:      map[string]string{},
+		groups:     map[string]string{},
+	}
+	response.fileDetails = d.details(path)
+	for i := range response.Contents {
+		response.Contents[i].fileDetails = d.details(filepath.Join(path, response.Contents[i].Name))
+	}
+}
+
+// detailer computes the details of the entries of a listing, caching owner names.
+type detailer struct {
+	ctx context.Context
+	// root is the served directory, with symlinks resolved.
+	root    string
+	fields  detailFields
+	sums    *checksum.Cache
+	history *versions.Store
+	// algorithms are the requested checksum algorithms.
+	algorithms []string
+	users      map[string]string
+	groups     map[string]string
+}
+
+func (d *detailer) details(path string) *fileDetails {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+
+	details := &fileDetails{}
+	if d.fields[fieldMode] {
+		details.Mode = info.Mode().String()
+	}
+	if d.fields[fieldOwner] {
+		details.Owner, details.Group = d.owner(info)
+	}
+	if d.fields[fieldHidden] {
+		hidden := strings.HasPrefix(info.Name(), ".")
+		details.Hidden = &hidden
+	}
+	if d.fields[fieldTarget] && info.Mode()&fs.ModeSymlink != 0 {
+		details.Target = d.target(path)
+	}
+	if info.Mode().IsRegular() {
+		d.regularDetails(path, details)
+	}
+	return details
+}
+
+// regularDetails adds the fields only regular files have.
+func (d *detailer) regularDetails(path string, details *fileDetails) {
+	d.readContents(path, details)
+	if len(d.algorithms) > 0 {
+		// left out if the client goes away, or the file cannot be read
+		details.Checksums, _ = d.sums.Sum(d.ctx, path, d.algorithms...)
+	}
+	if d.fields[fieldVersions] && d.history != nil {
+		details.Versions = d.history.Count(path)
+	}
+}
+
+// owner returns the names of the user and group owning a file, or their IDs if they have
+// no names.
+func (d *detailer) owner(info fs.FileInfo) (string, string) {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return "", ""
+	}
+	if _, ok := d.users[uid]; !ok {
+		d.users[uid] = uid
+		if u, err := user.LookupId(uid); err == nil {
+			d.users[uid] = u.Username
+		}
+	}
+	if _, ok := d.groups[gid]; !ok {
+		d.groups[gid] = gid
+		if g, err := user.LookupGroupId(gid); err == nil {
+			d.groups[gid] = g.Name
+		}
+	}
+	return d.users[uid], d.groups[gid]
+}
+
+func (d *detailer) target(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil || !withinRoot(d.root, resolved) {
+		return ""
+	}
+	return relPath(d.root, resolved)
+}
+
+// readContents adds the fields read from the contents of the regular file at path.
+func (d *detailer) readContents(path string, details *fileDetails) {
+	if !d.fields[fieldMIME] && !d.fields[fieldImage] && !d.fields[fieldPages] {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer closeFile(f)
+
+	if d.fields[fieldMIME] {
+		head := make([]byte, metadata.SniffLen)
+		n, _ := io.ReadFull(f, head)
+		details.MIMEType = metadata.MIMEType(path, head[:n])
+	}
+	if d.fields[fieldImage] && rewind(f) {
+		addImage(f, details)
+	}
+	if d.fields[fieldPages] && rewind(f) {
+		details.Pages, _ = metadata.PDFPages(f)
+	}
+}
+
+func addImage(f *os.File, details *fileDetails) {
+	img, ok := metadata.ReadImage(f)
+	if !ok {
+		return
+	}
+	details.Width, details.Height = img.Width, img.Height
+	if !img.Taken.IsZero() {
+		details.Taken = &img.Taken
+	}
+}
+
+// rewind seeks back to the start of f, reporting whether it succeeded.
+func rewind(f *os.File) bool {
+	_, err := f.Seek(0, io.SeekStart)
+	return err == nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/dirsize"
+)
+
+var (
+	// ErrDirSize is returned when the size of a directory cannot be calculated.
+	ErrDirSize = errors.New("failed to calculate directory size")
+	// ErrDirSizeUnavailable is returned when too many directory sizes are being calculated to
+	// start another.
+	ErrDirSizeUnavailable = errors.New("too many directory sizes being calculated, try again later")
+)
+
+// DirSizeHandler is the handler for the /dirsize endpoint.
+// It responds with the total size and number of entries below the directory named by the path
+// query parameter, which must not be a symlink. Sizes are calculated in the background and
+// cached until the directory changes: while a calculation is queued or running, it responds
+// with 202 Accepted and the size and number of entries counted so far, and should be polled
+// until it is done. If too many are in progress to start another, it responds with 503.
+func DirSizeHandler(rootDir string, sizes *dirsize.Calculator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := cleanPath(rootDir, r.URL.Query().Get("path"))
+		if err != nil {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+
+		event := audit.Event{Action: audit.ActionList, Path: relPath(rootDir, path), Detail: "size"}
+		// symlinks are not followed, so would only count as themselves
+		info, err := os.Lstat(path)
+		if err != nil || !info.IsDir() || !resolvesWithin(rootDir, path) {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail += ": " + ErrDirNotFound.Error()
+			audit.Record(r, event)
+			RespondWithError(w, ErrDirNotFound.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status := sizes.Status(path)
+		switch status.State {
+		case dirsize.StateFailed:
+			event.Outcome = audit.OutcomeFailure
+			event.Detail += ": " + status.Error
+			audit.Record(r, event)
+			RespondWithError(w, ErrDirSize.Error(), http.StatusInternalServerError)
+			return
+		case dirsize.StateUnavailable:
+			event.Outcome = audit.OutcomeFailure
+			event.Detail += ": " + ErrDirSizeUnavailable.Error()
+			audit.Record(r, event)
+			RespondWithError(w, ErrDirSizeUnavailable.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		code := http.StatusOK
+		if status.State != dirsize.StateDone {
+			code = http.StatusAccepted
+		}
+		RespondWithJSON(w, status, code)
+	}
+}
+
+// addDirSizes sets the size and number of entries of each directory in response, a listing of
+// dir, whose size has been calculated, and starts calculating the others.
+func addDirSizes(sizes *dirsize.Calculator, dir string, response *filesResponse) {
+	for i := range response.Contents {
+		entry := &response.Contents[i]
+		if entry.Type != "dir" {
+			continue
+		}
+		status := sizes.Status(filepath.Join(dir, entry.Name))
+		if status.State == dirsize.StateDone {
+			entry.Size = status.Size
+			entry.Items = &status.Items
+		}
+	}
+}
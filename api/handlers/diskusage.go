@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/diskusage"
+)
+
+const (
+	// defaultDiskUsageDepth and defaultDiskUsageLimit are used when a request gives no depth
+	// or limit.
+	defaultDiskUsageDepth = 2
+	defaultDiskUsageLimit = 10
+	// maxDiskUsageDepth and maxDiskUsageLimit bound the size of the tree returned.
+	maxDiskUsageDepth = 16
+	maxDiskUsageLimit = 1000
+)
+
+var (
+	// ErrInvalidDiskUsage is returned for disk usage requests with an unusable depth or limit.
+	ErrInvalidDiskUsage = errors.New("invalid disk usage request, expected a depth of 0 to 16 and a limit of 1 to 1000")
+	// ErrDiskUsage is returned when disk usage cannot be analysed.
+	ErrDiskUsage = errors.New("failed to analyse disk usage")
+	// ErrDiskUsageTimeout is returned when disk usage takes longer to analyse than allowed.
+	ErrDiskUsageTimeout = errors.New("disk usage analysis timed out")
+)
+
+// DiskUsageHandler is the handler for the /diskusage endpoint, which is for admins.
+// It responds with a tree of the largest directories and files below the directory named by
+// the path query parameter, with the size and number of files below each directory broken
+// down by file type and age. The depth query parameter sets the levels of the tree, 2 by
+// default, and limit the entries and file types included for each directory, 10 by default.
+// The analysis stops if the client goes away, or if it takes longer than timeout unless zero.
+func DiskUsageHandler(rootDir string, analyzer *diskusage.Analyzer, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		opts, err := parseDiskUsageOptions(query.Get("depth"), query.Get("limit"))
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		path, err := cleanPath(rootDir, query.Get("path"))
+		if err != nil {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+
+		event := audit.Event{Action: audit.ActionDiskUsage, Path: relPath(rootDir, path)}
+		if !resolvesWithin(rootDir, path) {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = ErrDirNotFound.Error()
+			audit.Record(r, event)
+			RespondWithError(w, ErrDirNotFound.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		tree, err := analyzer.Analyze(ctx, path, opts)
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			if r.Context().Err() == nil {
+				message, code := diskUsageError(err)
+				RespondWithError(w, message, code)
+			}
+			return
+		}
+
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		RespondWithJSON(w, tree, http.StatusOK)
+	}
+}
+
+// parseDiskUsageOptions returns the options for the depth and limit query parameters, using
+// the defaults for those that are empty.
+func parseDiskUsageOptions(depth, limit string) (diskusage.Options, error) {
+	opts := diskusage.Options{Depth: defaultDiskUsageDepth, Limit: defaultDiskUsageLimit}
+	var err error
+	if depth != "" {
+		if opts.Depth, err = strconv.Atoi(depth); err != nil || opts.Depth < 0 || opts.Depth > maxDiskUsageDepth {
+			return opts, ErrInvalidDiskUsage
+		}
+	}
+	if limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil || opts.Limit < 1 || opts.Limit > maxDiskUsageLimit {
+			return opts, ErrInvalidDiskUsage
+		}
+	}
+	return opts, nil
+}
+
+// diskUsageError returns the message and status code for an error from analysing disk usage.
+func diskUsageError(err error) (string, int) {
+	switch {
+	case errors.Is(err, diskusage.ErrNotDir), errors.Is(err, fs.ErrNotExist):
+		return ErrDirNotFound.Error(), http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrDiskUsageTimeout.Error(), http.StatusServiceUnavailable
+	default:
+		log.Printf("Disk usage error: %v", err)
+		return ErrDiskUsage.Error(), http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/checksum"
+)
+
+// ErrNotFile is returned when a download is requested for a directory or other non-regular file.
+var ErrNotFile = errors.New("not a regular file, download directories as an archive")
+
+// DownloadHandler is the handler for the /download endpoint.
+// It serves the file named by the path query parameter as an attachment, with support for
+// range requests. Files inside archives can be downloaded by their path through the archive,
+// e.g. /photos.zip/2024/beach.jpg. Like archive downloads, files reached through a symlink
+// must resolve to a location under rootDir. Files other than archive members are served with
+// an ETag and Digest from their SHA-256 checksum, where one is available from sums.
+func DownloadHandler(rootDir string, sums *checksum.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := cleanPath(rootDir, r.URL.Query().Get("path"))
+		if err != nil {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+
+		event := audit.Event{Action: audit.ActionDownload, Path: relPath(rootDir, path)}
+		if archive, member, ok := splitArchivePath(rootDir, path); ok && member != "" {
+			serveMember(w, r, archive, member, event)
+			return
+		}
+		serveFile(w, r, rootDir, path, sums, event)
+	}
+}
+
+// serveFile serves the regular file at path.
+func serveFile(w http.ResponseWriter, r *http.Request, rootDir, path string, sums *checksum.Cache, event audit.Event) {
+	if !resolvesWithin(rootDir, path) {
+		event.Outcome = audit.OutcomeFailure
+		event.Detail = ErrFileNotFound.Error()
+		audit.Record(r, event)
+		RespondWithError(w, ErrFileNotFound.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Detail = err.Error()
+		audit.Record(r, event)
+		RespondWithError(w, ErrFileNotFound.Error(), http.StatusBadRequest)
+		return
+	}
+	defer closeFile(f)
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		event.Outcome = audit.OutcomeFailure
+		event.Detail = ErrNotFile.Error()
+		audit.Record(r, event)
+		RespondWithError(w, ErrNotFile.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event.Outcome = audit.OutcomeSuccess
+	audit.Record(r, event)
+	setAttachment(w, filepath.Base(path))
+	setDigest(w, sums, path, info)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// setAttachment makes the response a download saved as filename.
+func setAttachment(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/watch"
+)
+
+// maxWatchedDirs limits the number of directories a single event stream can subscribe to.
+const maxWatchedDirs = 32
+
+// eventsKeepalive is how often an idle event stream is written to, so that proxies keep the
+// connection open and ended sessions are noticed.
+const eventsKeepalive = 30 * time.Second
+
+// ErrTooManyDirs is returned when an event stream subscribes to too many directories.
+var ErrTooManyDirs = fmt.Errorf("at most %d directories can be watched at once", maxWatchedDirs)
+
+type readyEvent struct {
+	Dirs []string `json:"dirs"`
+}
+
+// EventsHandler is the handler for the /events endpoint.
+// It streams changes to the directories named by the path query parameters, the root if
+// there are none, as server-sent events. A ready event is sent once the directories are
+// watched, after which they can be listed without missing changes, followed by a change
+// event for each watch.Event. Directories are watched, and events shown, subject to the same
+// checks as listing them, so nothing outside the root or in the server's state directory is
+// seen through a symlink. There are no per-user permissions on directories, so any user may
+// watch any directory they could list. The stream ends with an expired event once the session
+// is no longer valid, or without one when the server shuts down.
+func EventsHandler(rootDir string, hub *watch.Hub, backend AuthBackend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dirs, err := watchedDirs(rootDir, r.URL.Query()["path"])
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sub, err := hub.Subscribe(dirs)
+		if errors.Is(err, watch.ErrClosed) {
+			RespondWithError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			RespondWithError(w, ErrDirRead.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer sub.Close()
+
+		ready := readyEvent{}
+		for _, dir := range dirs {
+			ready.Dirs = append(ready.Dirs, relPath(rootDir, dir))
+			audit.Record(r, audit.Event{Action: audit.ActionWatch, Outcome: audit.OutcomeSuccess, Path: relPath(rootDir, dir)})
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// stop proxies such as nginx from buffering the stream
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		session, _ := r.Context().Value(auth.SessionContextKey).(*auth.Session)
+		streamEvents(w, r, rootDir, sub, ready, func() bool {
+			return sessionActive(session, backend)
+		})
+	}
+}
+
+// watchedDirs resolves the requested paths to existing directories under rootDir, which may
+// be symlinks to directories that resolve within it.
+func watchedDirs(rootDir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	if len(paths) > maxWatchedDirs {
+		return nil, ErrTooManyDirs
+	}
+
+	dirs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		dir, err := cleanPath(rootDir, p)
+		if err != nil {
+			return nil, ErrInvalidPath
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() || !resolvesWithin(rootDir, dir) {
+			return nil, ErrDirNotFound
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// streamEvents writes the events from sub that are visible in listings of rootDir to w until
+// the client disconnects, the subscription ends, or active reports that the session has ended.
+func streamEvents(w http.ResponseWriter, r *http.Request, rootDir string, sub *watch.Subscription, ready readyEvent, active func() bool) {
+	rc := http.NewResponseController(w)
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+
+	err := writeEvent(w, "ready", ready)
+	for err == nil && rc.Flush() == nil {
+		var events []watch.Event
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Done():
+			return
+		case <-keepalive.C:
+		case <-sub.Ready():
+			if events = visibleEvents(rootDir, sub.Events()); len(events) == 0 {
+				// already written with the previous batch
+				continue
+			}
+		}
+
+		if !active() {
+			_ = writeEvent(w, "expired", nil)
+			_ = rc.Flush()
+			return
+		}
+		err = writeChanges(w, events)
+	}
+}
+
+// writeChanges writes a change event for each of events, or a comment if there are none.
+func writeChanges(w io.Writer, events []watch.Event) error {
+	if len(events) == 0 {
+		_, err := io.WriteString(w, ": keepalive\n\n")
+		return err
+	}
+	for _, e := range events {
+		if err := writeEvent(w, "change", e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEvent writes a server-sent event with a JSON payload.
+func writeEvent(w io.Writer, name string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
+
+// sessionActive reports whether session is still valid. Cookie sessions end when they expire
+// or the user logs out, and client certificate sessions, which are not stored, when the
+// certificate expires.
+func sessionActive(session *auth.Session, backend AuthBackend) bool {
+	if session == nil || session.ExpiresAt.Before(time.Now()) {
+		return false
+	}
+	if session.ID == "" {
+		return true
+	}
+	_, err := backend.GetSessionByID(session.ID)
+	return err == nil
+}
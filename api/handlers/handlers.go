@@ -16,7 +16,13 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/goteleport-interview/fs4/api/audit"
 	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/checksum"
+	"github.com/goteleport-interview/fs4/api/dirsize"
+	"github.com/goteleport-interview/fs4/api/metrics"
+	"github.com/goteleport-interview/fs4/api/quota"
+	"github.com/goteleport-interview/fs4/api/versions"
 )
 
 // AuthBackend is the interface for the authentication backend.
@@ -25,6 +31,8 @@ type AuthBackend interface {
 	CreateSession(username string) (*auth.Session, error)
 	DeleteSession(id string) error
 	UpdateSession(id string, session *auth.Session) error
+	ActiveSessions() int
+	Ping() error
 	GetUser(username string) (*auth.User, error)
 	AddUser(username, password string) error
 }
@@ -38,13 +46,15 @@ type APIResponse struct {
 
 // APIError is the error format for the API.
 type APIError struct {
-	Title  string `json:"title"`
-	Detail string `json:"detail"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"requestId,omitempty"`
 }
 
 type sessionReply struct {
-	Username string    `json:"username"`
-	Expires  time.Time `json:"expires"`
+	Username string       `json:"username"`
+	Expires  time.Time    `json:"expires"`
+	Quota    *quota.Usage `json:"quota,omitempty"`
 }
 
 type filesResponse struct {
@@ -53,6 +63,10 @@ type filesResponse struct {
 	Type     string          `json:"type"`
 	Modified time.Time       `json:"modified"`
 	Contents []filesResponse `json:"contents"`
+	// Items is the number of entries below a directory, set along with its size once they
+	// have been calculated.
+	Items *int64 `json:"items,omitempty"`
+	*fileDetails
 }
 
 type loginRequest struct {
@@ -62,6 +76,8 @@ type loginRequest struct {
 
 type pathRequest struct {
 	Path string `json:"path"`
+	// Fields are the optional fileDetails to include for each entry.
+	Fields []string `json:"fields"`
 }
 
 var (
@@ -83,25 +99,37 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, backend AuthBackend) {
 		return
 	}
 
+	loginFailed := audit.Event{Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, User: creds.Username}
+	m := metrics.FromContext(r.Context())
+
 	user, err := backend.GetUser(creds.Username)
 	if err != nil {
 		_ = bcrypt.CompareHashAndPassword([]byte("$2y$12$EXAMPLEHASHFALLBACK12345678901234567890"), []byte(creds.Password))
+		loginFailed.Detail = auth.ErrUserNotFound.Error()
+		audit.Record(r, loginFailed)
+		m.LoginFailed()
 		RespondWithError(w, auth.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
+		loginFailed.Detail = auth.ErrInvalidCredentials.Error()
+		audit.Record(r, loginFailed)
+		m.LoginFailed()
 		RespondWithError(w, auth.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
 		return
 	}
 
 	session, err := backend.CreateSession(creds.Username)
 	if err != nil {
+		loginFailed.Detail = auth.ErrSessionCreation.Error()
+		audit.Record(r, loginFailed)
 		RespondWithError(w, auth.ErrSessionCreation.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	auth.SetCookie(w, auth.CookieData{ID: session.ID, Expires: session.ExpiresAt})
+	audit.Record(r, audit.Event{Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess, User: session.Username})
+	auth.SetCookie(w, auth.CookieData{ID: session.ID, Expires: session.ExpiresAt, Insecure: !IsSecure(r)})
 	RespondWithJSON(w, sessionReply{Username: session.Username, Expires: session.ExpiresAt}, http.StatusOK)
 }
 
@@ -113,37 +141,61 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request, backend AuthBackend)
 		return
 	}
 
+	var username string
+	if session, err := backend.GetSessionByID(cookie.Value); err == nil {
+		username = session.Username
+	}
+
 	err = backend.DeleteSession(cookie.Value)
 	if err != nil {
+		audit.Record(r, audit.Event{Action: audit.ActionLogout, Outcome: audit.OutcomeFailure, User: username, Detail: err.Error()})
 		RespondWithError(w, "Failed to delete session", http.StatusInternalServerError)
 		return
 	}
 
-	auth.SetCookie(w, auth.CookieData{ID: "", Expires: time.Unix(0, 0)})
+	audit.Record(r, audit.Event{Action: audit.ActionLogout, Outcome: audit.OutcomeSuccess, User: username})
+	auth.SetCookie(w, auth.CookieData{ID: "", Expires: time.Unix(0, 0), Insecure: !IsSecure(r)})
 	RespondWithJSON(w, nil, http.StatusOK)
 }
 
 // MeHandler is the handler for the /me endpoint.
-func MeHandler(w http.ResponseWriter, r *http.Request) {
-	session, ok := r.Context().Value(auth.SessionContextKey).(*auth.Session)
+// It responds with the session, and the user's storage usage if quotas is not nil.
+func MeHandler(quotas *quota.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(auth.SessionContextKey).(*auth.Session)
 
-	if !ok || session == nil {
-		RespondWithError(w, auth.ErrAuthRequired.Error(), http.StatusUnauthorized)
-		return
-	}
+		if !ok || session == nil {
+			RespondWithError(w, auth.ErrAuthRequired.Error(), http.StatusUnauthorized)
+			return
+		}
 
-	RespondWithJSON(w, sessionReply{Username: session.Username, Expires: session.ExpiresAt}, http.StatusOK)
+		reply := sessionReply{Username: session.Username, Expires: session.ExpiresAt}
+		if quotas != nil {
+			usage := quotas.Usage(session.Username)
+			reply.Quota = &usage
+		}
+		RespondWithJSON(w, reply, http.StatusOK)
+	}
 }
 
 // FilesHandler is the handler for the /files endpoint.
-// It returns the contents of a requested directory.
-func FilesHandler(rootDir string) http.HandlerFunc {
+// It returns the contents of a requested directory, with the optional fileDetails named by
+// the fields of the request. Details are not available inside archives. Checksums requested
+// as details are cached in sums, and version counts read from history. Subdirectories are
+// given the total size and number of entries below them once calculated by sizes, which starts
+// calculating any not yet known.
+func FilesHandler(rootDir string, sums *checksum.Cache, history *versions.Store, sizes *dirsize.Calculator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var pathReq pathRequest
 		if err := json.NewDecoder(r.Body).Decode(&pathReq); err != nil {
 			RespondWithError(w, ErrInvalidReqBody.Error(), http.StatusBadRequest)
 			return
 		}
+		fields, err := parseFields(pathReq.Fields)
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		path, err := cleanPath(rootDir, pathReq.Path)
 		if err != nil {
@@ -155,22 +207,55 @@ func FilesHandler(rootDir string) http.HandlerFunc {
 			return
 		}
 
-		contents, err := getDirContents(path)
-		if err != nil {
-			if err.Error() == ErrDirNotFound.Error() {
-				RespondWithError(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			RespondWithError(w, ErrDirRead.Error(), http.StatusInternalServerError)
+		event := audit.Event{Action: audit.ActionList, Path: relPath(rootDir, path)}
+		if archive, member, ok := splitArchivePath(rootDir, path); ok {
+			listArchive(w, r, archive, member, event)
 			return
 		}
 
+		contents, ok := readListing(w, r, rootDir, path, event)
+		if !ok {
+			return
+		}
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		metrics.FromContext(r.Context()).ObserveListing(len(contents))
+
 		response := formatDirContents(path, contents)
+		if sizes != nil {
+			addDirSizes(sizes, path, &response)
+		}
+		if len(fields) > 0 {
+			addDetails(r.Context(), rootDir, path, &response, fields, sums, history)
+		}
 
 		RespondWithJSON(w, response, http.StatusOK)
 	}
 }
 
+// readListing returns the entries of the directory at path, without the StateDir. If path
+// cannot be listed, it records event and responds with the error.
+func readListing(w http.ResponseWriter, r *http.Request, rootDir, path string, event audit.Event) ([]fs.DirEntry, bool) {
+	// a symlink may lead out of the root, or back into its StateDir
+	var contents []fs.DirEntry
+	err := ErrDirNotFound
+	if resolvesWithin(rootDir, path) {
+		contents, err = getDirContents(path)
+	}
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Detail = err.Error()
+		audit.Record(r, event)
+		if err.Error() == ErrDirNotFound.Error() {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		RespondWithError(w, ErrDirRead.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return hideStateDir(rootDir, path, contents), true
+}
+
 func formatDirContents(path string, files []fs.DirEntry) filesResponse {
 	var contents []filesResponse
 	for _, entry := range files {
@@ -218,14 +303,23 @@ func cleanPath(rootDir string, path string) (string, error) {
 	}
 	cleanPath := filepath.Join(rootDir, filepath.Clean(string(os.PathSeparator)+decodedPath))
 
-	// Make sure the path is within the root dir
-	if !strings.HasPrefix(cleanPath, filepath.Clean(rootDir)) {
+	// Make sure the path is within the root dir, and not the server's own state
+	if !strings.HasPrefix(cleanPath, filepath.Clean(rootDir)) || isStatePath(rootDir, cleanPath) {
 		return "", ErrInvalidPath
 	}
 
 	return cleanPath, nil
 }
 
+// relPath returns path relative to rootDir in slash-separated form, for display and logging.
+func relPath(rootDir string, path string) string {
+	rel, err := filepath.Rel(filepath.Clean(rootDir), path)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
 func getDirContents(path string) ([]fs.DirEntry, error) {
 	dir, err := os.Open(path)
 	if err != nil {
@@ -248,38 +342,110 @@ func closeFile(f *os.File) {
 }
 
 // RequireAuth is middleware for protected routes.
+// Clients presenting a verified TLS client certificate are authenticated as the user it names,
+// see auth.CertificateUsername, and skip the cookie login. All other clients need a session cookie.
 func RequireAuth(next http.Handler, backend AuthBackend) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie(auth.SessionCookieName)
-
-		if err != nil {
-			RespondWithError(w, auth.ErrAuthRequired.Error(), http.StatusUnauthorized)
+		var session *auth.Session
+		var ok bool
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			session, ok = certificateSession(w, r, backend)
+		} else {
+			session, ok = cookieSession(w, r, backend)
+		}
+		if !ok {
 			return
 		}
 
-		session, err := backend.GetSessionByID(cookie.Value)
-		if err != nil {
-			RespondWithError(w, err.Error(), http.StatusUnauthorized)
+		if info := GetRequestInfo(r.Context()); info != nil {
+			info.User = session.Username
+		}
+
+		ctx := context.WithValue(r.Context(), auth.SessionContextKey, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// cookieSession returns the session named by the request's session cookie.
+// If there is no valid session, an error response is sent and ok is false.
+func cookieSession(w http.ResponseWriter, r *http.Request, backend AuthBackend) (session *auth.Session, ok bool) {
+	cookie, err := r.Cookie(auth.SessionCookieName)
+
+	if err != nil {
+		RespondWithError(w, auth.ErrAuthRequired.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	session, err = backend.GetSessionByID(cookie.Value)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionExpired) {
+			audit.Record(r, audit.Event{Action: audit.ActionSessionExpired, Outcome: audit.OutcomeFailure, Path: r.URL.Path})
+		}
+		RespondWithError(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if session.ExpiresAt.Before(time.Now()) {
+		_ = backend.DeleteSession(session.ID)
+		audit.Record(r, audit.Event{Action: audit.ActionSessionExpired, Outcome: audit.OutcomeFailure, User: session.Username, Path: r.URL.Path})
+		auth.SetCookie(w, auth.CookieData{ID: "", Expires: time.Unix(0, 0), Insecure: !IsSecure(r)})
+		RespondWithError(w, auth.ErrSessionExpired.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return session, true
+}
+
+// certificateSession returns an unstored session for the user named by the request's verified
+// client certificate, valid until the certificate expires.
+// If the certificate does not name a known user, an error response is sent and ok is false.
+func certificateSession(w http.ResponseWriter, r *http.Request, backend AuthBackend) (session *auth.Session, ok bool) {
+	cert := r.TLS.VerifiedChains[0][0]
+	username := auth.CertificateUsername(cert)
+
+	if _, err := backend.GetUser(username); err != nil {
+		audit.Record(r, audit.Event{
+			Action:  audit.ActionLogin,
+			Outcome: audit.OutcomeFailure,
+			User:    username,
+			Path:    r.URL.Path,
+			Detail:  auth.ErrUnknownClientCert.Error(),
+		})
+		metrics.FromContext(r.Context()).LoginFailed()
+		RespondWithError(w, auth.ErrUnknownClientCert.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return &auth.Session{Username: username, ExpiresAt: cert.NotAfter}, true
+}
+
+// RequireAdmin is middleware for admin-only routes.
+// It must be wrapped by RequireAuth so that the session is available.
+func RequireAdmin(next http.Handler, backend AuthBackend) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(auth.SessionContextKey).(*auth.Session)
+		if !ok || session == nil {
+			RespondWithError(w, auth.ErrAuthRequired.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		if session.ExpiresAt.Before(time.Now()) {
-			_ = backend.DeleteSession(session.ID)
-			auth.SetCookie(w, auth.CookieData{ID: "", Expires: time.Unix(0, 0)})
-			RespondWithError(w, auth.ErrSessionExpired.Error(), http.StatusUnauthorized)
+		user, err := backend.GetUser(session.Username)
+		if err != nil || !user.Admin {
+			RespondWithError(w, auth.ErrAdminRequired.Error(), http.StatusForbidden)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), auth.SessionContextKey, session)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
 	})
 }
 
 // RespondWithError sends an error response to the client.
+// The request ID, if one was assigned by middleware, is included so that errors can be matched to logs.
 func RespondWithError(w http.ResponseWriter, message string, code int) {
+	requestID := w.Header().Get(RequestIDHeader)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	err := json.NewEncoder(w).Encode(APIResponse{Status: "error", Error: &APIError{Title: http.StatusText(code), Detail: message}})
+	err := json.NewEncoder(w).Encode(APIResponse{Status: "error", Error: &APIError{Title: http.StatusText(code), Detail: message, RequestID: requestID}})
 	if err != nil {
 		log.Printf("Failed to send error response: %v", err)
 	}
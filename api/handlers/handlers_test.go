@@ -1,16 +1,43 @@
 package handlers
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/goteleport-interview/fs4/api/audit"
 	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/checksum"
+	"github.com/goteleport-interview/fs4/api/dirsize"
+	"github.com/goteleport-interview/fs4/api/diskusage"
+	"github.com/goteleport-interview/fs4/api/quota"
+	"github.com/goteleport-interview/fs4/api/shares"
+	"github.com/goteleport-interview/fs4/api/thumbnail"
+	"github.com/goteleport-interview/fs4/api/trash"
+	"github.com/goteleport-interview/fs4/api/versions"
+	"github.com/goteleport-interview/fs4/api/watch"
 )
 
 type TestAPIResponse struct {
@@ -123,7 +150,7 @@ func TestMeHandler(t *testing.T) {
 		t.Fatalf("failed to add user: %v", err)
 	}
 
-	handler := RequireAuth(http.HandlerFunc(MeHandler), backend)
+	handler := RequireAuth(MeHandler(nil), backend)
 
 	t.Run("valid session", func(t *testing.T) {
 		session, _ := backend.CreateSession("testuser")
@@ -194,6 +221,66 @@ func TestMeHandler(t *testing.T) {
 	})
 }
 
+func TestRequireAuthClientCert(t *testing.T) {
+	backend := auth.NewInMemoryBackend()
+	if err := backend.AddUser("build-bot", "password"); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	handler := RequireAuth(MeHandler(nil), backend)
+
+	withCert := func(commonName string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/me", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}, NotAfter: time.Now().Add(time.Hour)}
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return req
+	}
+
+	t.Run("known user", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, withCert("build-bot"))
+
+		resp := recorder.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var reply sessionReply
+		if err := json.Unmarshal(apiResp.Data, &reply); err != nil {
+			t.Fatalf("failed to unmarshal session reply: %v", err)
+		}
+		if reply.Username != "build-bot" {
+			t.Errorf("expected username 'build-bot', got '%s'", reply.Username)
+		}
+		if backend.ActiveSessions() != 0 {
+			t.Errorf("expected no session to be stored, got %d", backend.ActiveSessions())
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, withCert("intruder"))
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status Unauthorized, got %v", recorder.Code)
+		}
+	})
+
+	t.Run("unverified certificate", func(t *testing.T) {
+		req := withCert("build-bot")
+		req.TLS.PeerCertificates = req.TLS.VerifiedChains[0]
+		req.TLS.VerifiedChains = nil
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status Unauthorized, got %v", recorder.Code)
+		}
+	})
+}
+
 func TestFilesHandler(t *testing.T) {
 	t.Run("valid path", func(t *testing.T) {
 		rootDir, err := os.MkdirTemp(os.TempDir(), "testfiles")
@@ -220,7 +307,7 @@ func TestFilesHandler(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", bytes.NewBuffer(reqBody))
 		recorder := httptest.NewRecorder()
 
-		handler := FilesHandler(rootDir)
+		handler := FilesHandler(rootDir, nil, nil, nil)
 		handler.ServeHTTP(recorder, req)
 
 		resp := recorder.Result()
@@ -262,7 +349,7 @@ func TestFilesHandler(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", bytes.NewBuffer(reqBody))
 		recorder := httptest.NewRecorder()
 
-		handler := FilesHandler("")
+		handler := FilesHandler("", nil, nil, nil)
 		handler.ServeHTTP(recorder, req)
 
 		resp := recorder.Result()
@@ -278,7 +365,7 @@ func TestFilesHandler(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", bytes.NewBuffer(reqBody))
 		recorder := httptest.NewRecorder()
 
-		handler := FilesHandler("")
+		handler := FilesHandler("", nil, nil, nil)
 		handler.ServeHTTP(recorder, req)
 
 		resp := recorder.Result()
@@ -311,7 +398,7 @@ func TestFilesHandler(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", bytes.NewBuffer(reqBody))
 		recorder := httptest.NewRecorder()
 
-		handler := FilesHandler(rootDir)
+		handler := FilesHandler(rootDir, nil, nil, nil)
 		handler.ServeHTTP(recorder, req)
 
 		resp := recorder.Result()
@@ -345,6 +432,35 @@ func TestFilesHandler(t *testing.T) {
 			t.Errorf("expected file name 'testfile.txt', got '%s'", fileInfo["name"])
 		}
 	})
+
+	t.Run("symlink to the root", func(t *testing.T) {
+		rootDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(rootDir, StateDir, "trash"), 0700); err != nil {
+			t.Fatalf("failed to create state dir: %v", err)
+		}
+		if err := os.Symlink(".", filepath.Join(rootDir, "link")); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+		list := func(body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader(body))
+			recorder := httptest.NewRecorder()
+			FilesHandler(rootDir, nil, nil, nil).ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		for _, body := range []string{`{"path": "/link/.fs4/trash"}`, `{"path": "/link/.fs4", "fields": ["mime"]}`} {
+			if code := list(body).Code; code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %s, got %d", body, code)
+			}
+		}
+		recorder := list(`{"path": "/link"}`)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status OK, got %d", recorder.Code)
+		}
+		if strings.Contains(recorder.Body.String(), StateDir) {
+			t.Errorf("expected the state directory not to be listed, got %s", recorder.Body.String())
+		}
+	})
 }
 
 func TestCleanPath(t *testing.T) {
@@ -359,6 +475,7 @@ func TestCleanPath(t *testing.T) {
 		{"empty path", "", rootDir},
 		{"root path", "/", rootDir},
 		{"weird subdirs", "subdir/../subdir/./subdir", filepath.Join(rootDir, "subdir/subdir")},
+		{"state dir", "/.fs4/trash", ""},
 	}
 
 	for _, tt := range tests {
@@ -451,3 +568,1563 @@ func TestFormatDirContents(t *testing.T) {
 		t.Errorf("expected file size '12', got '%d'", fileInfo.Size)
 	}
 }
+
+func TestAuditHandler(t *testing.T) {
+	backend := auth.NewInMemoryBackend()
+	for _, user := range []string{"admin", "testuser"} {
+		if err := backend.AddUser(user, "password"); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+	if err := backend.SetAdmin("admin", true); err != nil {
+		t.Fatalf("failed to set admin: %v", err)
+	}
+
+	auditLog, err := audit.NewLogger(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create audit log: %v", err)
+	}
+	// nolint:errcheck
+	defer auditLog.Close()
+
+	login := audit.WithLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoginHandler(w, r, backend)
+	}), auditLog)
+	reqBody, _ := json.Marshal(map[string]string{
+		"username": "testuser",
+		"password": "wrongpassword",
+	})
+	login.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", bytes.NewBuffer(reqBody)))
+
+	handler := RequireAuth(RequireAdmin(AuditHandler(auditLog), backend), backend)
+
+	t.Run("admin", func(t *testing.T) {
+		session, _ := backend.CreateSession("admin")
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/audit?user=testuser&action=login", nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, req)
+
+		resp := recorder.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		var data auditResponse
+		if err := json.Unmarshal(apiResp.Data, &data); err != nil {
+			t.Fatalf("failed to unmarshal data: %v", err)
+		}
+		if len(data.Events) != 1 {
+			t.Fatalf("expected 1 event, got %d", len(data.Events))
+		}
+		if data.Events[0].Outcome != audit.OutcomeFailure {
+			t.Errorf("expected outcome 'failure', got '%s'", data.Events[0].Outcome)
+		}
+	})
+
+	t.Run("non-admin", func(t *testing.T) {
+		session, _ := backend.CreateSession("testuser")
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/audit", nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, req)
+
+		resp := recorder.Result()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected status Forbidden, got %v", resp.Status)
+		}
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		session, _ := backend.CreateSession("admin")
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/audit?since=yesterday", nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, req)
+
+		resp := recorder.Result()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status BadRequest, got %v", resp.Status)
+		}
+	})
+}
+
+func TestEventsHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	backend := auth.NewInMemoryBackend()
+	session, err := backend.CreateSession("user")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	for link, target := range map[string]string{"rootlink": ".", "outside": t.TempDir()} {
+		if err := os.Symlink(target, filepath.Join(rootDir, link)); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+	hub := watch.NewHub(rootDir, slog.Default())
+	// nolint:errcheck
+	defer hub.Close()
+
+	server := httptest.NewServer(RequireAuth(EventsHandler(rootDir, hub, backend), backend))
+	defer server.Close()
+
+	get := func(query string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/events"+query, nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("invalid paths", func(t *testing.T) {
+		for _, query := range []string{"?path=/missing", "?path=%25zz", "?path=/&path=/missing", "?path=/outside"} {
+			resp := get(query)
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %s, got %v", query, resp.Status)
+			}
+		}
+	})
+
+	t.Run("symlink to the root", func(t *testing.T) {
+		resp := get("?path=/rootlink")
+		// nolint:errcheck
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+
+		events := bufio.NewScanner(resp.Body)
+		for events.Scan() && events.Text() != "event: ready" {
+			// wait until watched
+		}
+		if err := os.Mkdir(filepath.Join(rootDir, StateDir), 0700); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(rootDir, "linked.txt"), nil, 0600); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		for events.Scan() {
+			if events.Text() == "event: change" {
+				if events.Scan(); !strings.Contains(events.Text(), `"name":"linked.txt"`) {
+					t.Fatalf("expected only the change to linked.txt, got %s", events.Text())
+				}
+				return
+			}
+		}
+		t.Fatalf("expected change event, stream ended: %v", events.Err())
+	})
+
+	t.Run("removed directory", func(t *testing.T) {
+		if err := os.Mkdir(filepath.Join(rootDir, "gone"), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		resp := get("?path=/gone")
+		// nolint:errcheck
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+
+		events := bufio.NewScanner(resp.Body)
+		for events.Scan() && events.Text() != "event: ready" {
+			// wait until watched
+		}
+		if err := os.Remove(filepath.Join(rootDir, "gone")); err != nil {
+			t.Fatalf("failed to remove directory: %v", err)
+		}
+		// ends the stream if the event never comes
+		timer := time.AfterFunc(5*time.Second, func() { _ = resp.Body.Close() })
+		defer timer.Stop()
+		for events.Scan() {
+			if events.Text() == "event: change" {
+				if events.Scan(); events.Text() != `data: {"op":"delete","dir":"/gone"}` {
+					t.Fatalf("expected the removal of the directory, got %s", events.Text())
+				}
+				return
+			}
+		}
+		t.Fatalf("expected change event, stream ended: %v", events.Err())
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		resp := get("?path=/")
+		// nolint:errcheck
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("expected event stream, got %s", ct)
+		}
+
+		events := bufio.NewScanner(resp.Body)
+		expectEvent := func(name, data string) {
+			t.Helper()
+			for events.Scan() {
+				if events.Text() != "event: "+name {
+					continue
+				}
+				if events.Scan(); !strings.Contains(events.Text(), data) {
+					t.Fatalf("expected %s event with %s, got %s", name, data, events.Text())
+				}
+				return
+			}
+			t.Fatalf("expected %s event, stream ended: %v", name, events.Err())
+		}
+		expectEvent("ready", `"dirs":["/"]`)
+
+		if err := os.WriteFile(filepath.Join(rootDir, "new.txt"), nil, 0600); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		expectEvent("change", `{"op":"create","dir":"/","name":"new.txt"}`)
+
+		// events stop once the user logs out
+		if err := backend.DeleteSession(session.ID); err != nil {
+			t.Fatalf("failed to delete session: %v", err)
+		}
+		if err := os.Remove(filepath.Join(rootDir, "new.txt")); err != nil {
+			t.Fatalf("failed to remove file: %v", err)
+		}
+		expectEvent("expired", "null")
+		for events.Scan() {
+			if events.Text() != "" {
+				t.Errorf("expected stream to end, got %s", events.Text())
+			}
+		}
+	})
+}
+
+func TestArchiveHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	outside := t.TempDir()
+	for name, content := range map[string]string{
+		"photos/a.jpg":        "jpeg",
+		"photos/nested/b.txt": "text",
+		"docs/c.txt":          "linked",
+	} {
+		p := filepath.Join(rootDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	for link, target := range map[string]string{
+		"photos/link-in":  filepath.Join("..", "docs", "c.txt"),
+		"photos/link-out": filepath.Join(outside, "secret"),
+		"photos/loop":     "..",
+		"outside":         outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(rootDir, link)); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+
+	get := func(query string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/archive"+query, nil)
+		recorder := httptest.NewRecorder()
+		ArchiveHandler(rootDir).ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+	expected := map[string]string{
+		"photos/":             "",
+		"photos/a.jpg":        "jpeg",
+		"photos/link-in":      "linked",
+		"photos/nested/":      "",
+		"photos/nested/b.txt": "text",
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		resp := get("?path=/photos")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+		if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=photos.zip` {
+			t.Errorf("expected photos.zip attachment, got %s", cd)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("failed to read zip: %v", err)
+		}
+		contents := map[string]string{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("failed to open %s: %v", f.Name, err)
+			}
+			data, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			contents[f.Name] = string(data)
+		}
+		if !reflect.DeepEqual(contents, expected) {
+			t.Errorf("expected %v, got %v", expected, contents)
+		}
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		resp := get("?path=/photos&path=/docs/c.txt&format=tar.gz")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+		if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=download.tar.gz` {
+			t.Errorf("expected download.tar.gz attachment, got %s", cd)
+		}
+
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip: %v", err)
+		}
+		tr := tar.NewReader(gz)
+		contents := map[string]string{}
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read tar: %v", err)
+			}
+			data, _ := io.ReadAll(tr)
+			contents[header.Name] = string(data)
+		}
+		expected := maps.Clone(expected)
+		expected["c.txt"] = "linked"
+		if !reflect.DeepEqual(contents, expected) {
+			t.Errorf("expected %v, got %v", expected, contents)
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, query := range []string{
+			"",
+			"?path=/photos&format=rar",
+			"?path=/missing",
+			"?path=/outside",
+			"?path=/photos&path=/docs/../photos",
+		} {
+			resp := get(query)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %q, got %v", query, resp.Status)
+			}
+		}
+	})
+
+	t.Run("audit paths", func(t *testing.T) {
+		// served through a symlink, while entries have their symlinks resolved
+		linked := filepath.Join(t.TempDir(), "root")
+		if err := os.Symlink(rootDir, linked); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+		auditLog, err := audit.NewLogger(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 0)
+		if err != nil {
+			t.Fatalf("failed to create audit log: %v", err)
+		}
+		// nolint:errcheck
+		defer auditLog.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/archive?path=/photos&path=/docs/c.txt", nil)
+		audit.WithLogger(ArchiveHandler(linked), auditLog).ServeHTTP(httptest.NewRecorder(), req)
+		events, err := auditLog.Query(audit.Query{Action: audit.ActionDownload})
+		if err != nil {
+			t.Fatalf("failed to query audit log: %v", err)
+		}
+		var paths []string
+		for _, e := range events {
+			paths = append(paths, e.Path)
+		}
+		if expected := []string{"/photos", "/docs/c.txt"}; !reflect.DeepEqual(paths, expected) {
+			t.Errorf("expected events for %v, got %v", expected, paths)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		entries, err := archiveEntries(rootDir, []string{"/photos"})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := writeArchive(ctx, newZipArchive(io.Discard), entries); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+// writeTestZip creates a ZIP archive at p with the given files.
+func writeTestZip(t *testing.T, p string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+}
+
+func TestFilesHandlerArchive(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(rootDir, "files"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	writeTestZip(t, filepath.Join(rootDir, "files", "orbiter.zip"), map[string]string{
+		"docs/manual.txt":  "manual",
+		"docs/specs/a.txt": "a",
+		"../escape.txt":    "escape",
+	})
+	if err := os.WriteFile(filepath.Join(rootDir, "files", "broken.zip"), []byte("not a zip"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	list := func(path string) (int, filesResponse) {
+		reqBody, _ := json.Marshal(map[string]string{"path": path})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", bytes.NewBuffer(reqBody))
+		recorder := httptest.NewRecorder()
+		FilesHandler(rootDir, nil, nil, nil).ServeHTTP(recorder, req)
+
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(recorder.Result().Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var data filesResponse
+		_ = json.Unmarshal(apiResp.Data, &data)
+		return recorder.Code, data
+	}
+
+	code, root := list("files/orbiter.zip")
+	if code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", code)
+	}
+	if root.Name != "orbiter.zip" || root.Type != "dir" || len(root.Contents) != 1 || root.Contents[0].Name != "docs" {
+		t.Errorf("expected archive root containing docs, got %+v", root)
+	}
+
+	code, docs := list("/files/orbiter.zip/docs")
+	if code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", code)
+	}
+	expected := []filesResponse{
+		{Name: "manual.txt", Type: "file", Size: 6},
+		{Name: "specs", Type: "dir", Size: 0},
+	}
+	for i := range docs.Contents {
+		docs.Contents[i].Modified = time.Time{}
+	}
+	if docs.Name != "docs" || !reflect.DeepEqual(docs.Contents, expected) {
+		t.Errorf("expected %+v, got %+v", expected, docs)
+	}
+
+	for path, want := range map[string]int{
+		"files/orbiter.zip/missing":         http.StatusBadRequest,
+		"files/orbiter.zip/docs/manual.txt": http.StatusBadRequest,
+		"files/broken.zip":                  http.StatusUnprocessableEntity,
+	} {
+		if code, _ := list(path); code != want {
+			t.Errorf("expected status %d for %s, got %d", want, path, code)
+		}
+	}
+}
+
+func TestDownloadHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rootDir, "notes.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(rootDir, "dir"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(rootDir, "secret")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	writeTestZip(t, filepath.Join(rootDir, "orbiter.zip"), map[string]string{"docs/manual.txt": "manual"})
+
+	sums := checksum.NewCache(10)
+	get := func(query string, header http.Header) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/download"+query, nil)
+		maps.Copy(req.Header, header)
+		recorder := httptest.NewRecorder()
+		DownloadHandler(rootDir, sums).ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+
+	t.Run("file", func(t *testing.T) {
+		resp := get("?path=/notes.txt", http.Header{"Range": {"bytes=6-"}})
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("expected status 206, got %v", resp.Status)
+		}
+		if cd := resp.Header.Get("Content-Disposition"); cd != "attachment; filename=notes.txt" {
+			t.Errorf("expected notes.txt attachment, got %s", cd)
+		}
+		if body, _ := io.ReadAll(resp.Body); string(body) != "world" {
+			t.Errorf("expected world, got %q", body)
+		}
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			t.Errorf("expected no ETag before the checksum is cached, got %s", etag)
+		}
+
+		// hashed in the background for later downloads
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, ok := sums.Cached(filepath.Join(rootDir, "notes.txt"), checksum.SHA256); ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the checksum to be cached")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		resp = get("?path=/notes.txt", nil)
+		etag := `"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"`
+		if got := resp.Header.Get("ETag"); got != etag {
+			t.Errorf("expected ETag %s, got %s", etag, got)
+		}
+		if digest := resp.Header.Get("Digest"); digest != "sha-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=" {
+			t.Errorf("expected sha-256 digest, got %s", digest)
+		}
+		if resp := get("?path=/notes.txt", http.Header{"If-None-Match": {etag}}); resp.StatusCode != http.StatusNotModified {
+			t.Errorf("expected status 304 for matching ETag, got %v", resp.Status)
+		}
+	})
+
+	t.Run("archive member", func(t *testing.T) {
+		resp := get("?path=/orbiter.zip/docs/manual.txt", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK, got %v", resp.Status)
+		}
+		if cd := resp.Header.Get("Content-Disposition"); cd != "attachment; filename=manual.txt" {
+			t.Errorf("expected manual.txt attachment, got %s", cd)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("expected text/plain, got %s", ct)
+		}
+		if body, _ := io.ReadAll(resp.Body); string(body) != "manual" {
+			t.Errorf("expected manual, got %q", body)
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, query := range []string{
+			"?path=/missing",
+			"?path=/dir",
+			"?path=/secret",
+			"?path=/orbiter.zip/docs",
+			"?path=/orbiter.zip/missing",
+		} {
+			resp := get(query, nil)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %q, got %v", query, resp.Status)
+			}
+		}
+	})
+}
+
+func TestThumbnailHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	thumbs, err := thumbnail.New(thumbnail.Options{CacheDir: t.TempDir(), Workers: 1, MaxPixels: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to create generator: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 150))); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rootDir, "photo.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rootDir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	get := func(query string, header http.Header) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/thumbnail"+query, nil)
+		maps.Copy(req.Header, header)
+		recorder := httptest.NewRecorder()
+		ThumbnailHandler(rootDir, thumbs).ServeHTTP(recorder, req)
+		return recorder.Result()
+	}
+
+	resp := get("?path=/photo.png&size=100", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK, got %v", resp.Status)
+	}
+	img, format, err := image.Decode(resp.Body)
+	if err != nil || format != "jpeg" {
+		t.Fatalf("expected jpeg thumbnail, got %s (%v)", format, err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 128 || bounds.Dy() != 64 {
+		t.Errorf("expected 128x64 thumbnail, got %v", bounds)
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+	if resp := get("?path=/photo.png&size=128", http.Header{"If-None-Match": {etag}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status 304 for matching ETag, got %v", resp.Status)
+	}
+
+	for query, expected := range map[string]int{
+		"?path=/photo.png&size=big": http.StatusBadRequest,
+		"?path=/photo.png&size=0":   http.StatusBadRequest,
+		"?path=/missing.png":        http.StatusBadRequest,
+		"?path=/notes.txt":          http.StatusUnsupportedMediaType,
+	} {
+		if resp := get(query, nil); resp.StatusCode != expected {
+			t.Errorf("expected status %d for %q, got %v", expected, query, resp.Status)
+		}
+	}
+}
+
+func TestFilesHandlerDetails(t *testing.T) {
+	rootDir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	for name, content := range map[string][]byte{
+		"photo.png":   buf.Bytes(),
+		".hidden.txt": []byte("hidden"),
+		"doc.pdf":     []byte("%PDF-1.4\n1 0 obj << /Type /Pages /Count 4 >> endobj\n"),
+	} {
+		if err := os.WriteFile(filepath.Join(rootDir, name), content, 0640); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	if err := os.Symlink("photo.png", filepath.Join(rootDir, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(rootDir, "outside")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	list := func(body string) (int, map[string]map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		FilesHandler(rootDir, nil, nil, nil).ServeHTTP(recorder, req)
+
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(recorder.Result().Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var data struct {
+			Contents []map[string]interface{} `json:"contents"`
+		}
+		_ = json.Unmarshal(apiResp.Data, &data)
+		entries := map[string]map[string]interface{}{}
+		for _, entry := range data.Contents {
+			name, _ := entry["name"].(string)
+			entries[name] = entry
+		}
+		return recorder.Code, entries
+	}
+
+	code, entries := list(`{"path": "/", "fields": ["mime", "mode", "owner", "target", "hidden", "image", "pages", "md5"]}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", code)
+	}
+	photo := entries["photo.png"]
+	if photo["mimeType"] != "image/png" || photo["width"] != 30.0 || photo["height"] != 20.0 {
+		t.Errorf("expected png details, got %v", photo)
+	}
+	if photo["mode"] != "-rw-r-----" || photo["hidden"] != false || photo["owner"] == nil {
+		t.Errorf("expected mode, owner and hidden flag, got %v", photo)
+	}
+	hidden := entries[".hidden.txt"]
+	if hidden["hidden"] != true {
+		t.Errorf("expected hidden file, got %v", hidden)
+	}
+	if sums, _ := hidden["checksums"].(map[string]interface{}); sums["md5"] != "662f707d5491e9bce8238a6c0be92190" {
+		t.Errorf("expected md5 checksum, got %v", hidden["checksums"])
+	}
+	if doc := entries["doc.pdf"]; doc["pages"] != 4.0 || doc["mimeType"] != "application/pdf" {
+		t.Errorf("expected pdf details, got %v", doc)
+	}
+	if entries["link"]["target"] != "/photo.png" {
+		t.Errorf("expected link target, got %v", entries["link"])
+	}
+	if _, ok := entries["outside"]["target"]; ok {
+		t.Errorf("expected no target for link outside root, got %v", entries["outside"])
+	}
+
+	// details are only included if requested
+	_, entries = list(`{"path": "/"}`)
+	if _, ok := entries["photo.png"]["mimeType"]; ok {
+		t.Errorf("expected no details, got %v", entries["photo.png"])
+	}
+
+	if code, _ := list(`{"path": "/", "fields": ["checksum"]}`); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown field, got %d", code)
+	}
+}
+
+func TestChecksumHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rootDir, "notes.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(rootDir, "dir"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	sums := checksum.NewCache(10)
+
+	get := func(query string) (int, checksumResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/checksum"+query, nil)
+		recorder := httptest.NewRecorder()
+		ChecksumHandler(rootDir, sums).ServeHTTP(recorder, req)
+
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(recorder.Result().Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var data checksumResponse
+		_ = json.Unmarshal(apiResp.Data, &data)
+		return recorder.Code, data
+	}
+
+	code, resp := get("?path=/notes.txt")
+	if code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", code)
+	}
+	expected := checksum.Sums{checksum.SHA256: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"}
+	if resp.Name != "notes.txt" || resp.Size != 11 || !reflect.DeepEqual(resp.Checksums, expected) {
+		t.Errorf("expected sha256 of notes.txt, got %+v", resp)
+	}
+
+	code, resp = get("?path=/notes.txt&algorithm=md5&algorithm=sha1")
+	if code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", code)
+	}
+	expected = checksum.Sums{
+		checksum.MD5:  "5eb63bbbe01eeed093cb22bb8f5acdc3",
+		checksum.SHA1: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed",
+	}
+	if !reflect.DeepEqual(resp.Checksums, expected) {
+		t.Errorf("expected %v, got %v", expected, resp.Checksums)
+	}
+
+	for _, query := range []string{
+		"?path=/notes.txt&algorithm=crc32",
+		"?path=/missing",
+		"?path=/dir",
+	} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %q, got %d", query, code)
+		}
+	}
+}
+
+func TestTrashHandlers(t *testing.T) {
+	rootDir := t.TempDir()
+	for name, content := range map[string]string{
+		"docs/notes.txt": "notes",
+		"docs/other.txt": "other",
+		"keep.txt":       "keep",
+	} {
+		p := filepath.Join(rootDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	backend := auth.NewInMemoryBackend()
+	bin := trash.New(rootDir, filepath.Join(rootDir, StateDir, "trash"), 0, slog.Default())
+	// nolint:errcheck
+	defer bin.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/v1/files", FilesHandler(rootDir, nil, nil, nil))
+	mux.Handle("DELETE /api/v1/files", DeleteHandler(rootDir, bin, nil))
+	mux.Handle("GET /api/v1/trash", TrashHandler(bin))
+	mux.Handle("DELETE /api/v1/trash", PurgeHandler(bin))
+	mux.Handle("DELETE /api/v1/trash/{id}", PurgeHandler(bin))
+	mux.Handle("POST /api/v1/trash/{id}/restore", RestoreHandler(rootDir, bin, nil))
+	handler := RequireAuth(mux, backend)
+
+	do := func(user, method, target string, body string, data interface{}) int {
+		t.Helper()
+		session, err := backend.CreateSession(user)
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		var apiResp TestAPIResponse
+		if recorder.Code != http.StatusNoContent {
+			if err := json.NewDecoder(recorder.Result().Body).Decode(&apiResp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+		if data != nil {
+			_ = json.Unmarshal(apiResp.Data, data)
+		}
+		return recorder.Code
+	}
+	list := func(user string) []trash.Item {
+		t.Helper()
+		var data trashResponse
+		if code := do(user, http.MethodGet, "/api/v1/trash", "", &data); code != http.StatusOK {
+			t.Fatalf("expected status OK listing trash, got %d", code)
+		}
+		return data.Items
+	}
+
+	t.Run("delete and restore", func(t *testing.T) {
+		var item trash.Item
+		if code := do("alice", http.MethodDelete, "/api/v1/files?path=/docs/notes.txt", "", &item); code != http.StatusOK {
+			t.Fatalf("expected status OK, got %d", code)
+		}
+		if item.Path != "/docs/notes.txt" || item.Type != "file" || item.Size != 5 {
+			t.Errorf("unexpected item %+v", item)
+		}
+		if items := list("alice"); len(items) != 1 || items[0].ID != item.ID {
+			t.Errorf("expected the deleted file in alice's trash, got %v", items)
+		}
+		if items := list("bob"); len(items) != 0 {
+			t.Errorf("expected bob's trash to be empty, got %v", items)
+		}
+		if code := do("bob", http.MethodPost, "/api/v1/trash/"+item.ID+"/restore", "", nil); code != http.StatusNotFound {
+			t.Errorf("expected status 404 restoring another user's item, got %d", code)
+		}
+
+		// the trash is hidden from listings
+		var listing filesResponse
+		if code := do("alice", http.MethodPost, "/api/v1/files", `{"path":"/"}`, &listing); code != http.StatusOK {
+			t.Fatalf("expected status OK, got %d", code)
+		}
+		for _, entry := range listing.Contents {
+			if entry.Name == StateDir {
+				t.Errorf("expected %s to be hidden from the listing", StateDir)
+			}
+		}
+		recorder := httptest.NewRecorder()
+		ArchiveHandler(rootDir).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/archive?path=/", nil))
+		body := recorder.Body.Bytes()
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("failed to read zip: %v", err)
+		}
+		for _, f := range zr.File {
+			if strings.Contains(f.Name, StateDir) {
+				t.Errorf("expected %s to be left out of archives, got %s", StateDir, f.Name)
+			}
+		}
+
+		if err := os.WriteFile(filepath.Join(rootDir, "docs", "notes.txt"), []byte("new"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		if code := do("alice", http.MethodPost, "/api/v1/trash/"+item.ID+"/restore", "", nil); code != http.StatusConflict {
+			t.Errorf("expected status 409, got %d", code)
+		}
+		if code := do("alice", http.MethodPost, "/api/v1/trash/"+item.ID+"/restore?conflict=merge", "", nil); code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for an invalid conflict, got %d", code)
+		}
+		var restored trash.Item
+		if code := do("alice", http.MethodPost, "/api/v1/trash/"+item.ID+"/restore?conflict=rename", "", &restored); code != http.StatusOK {
+			t.Fatalf("expected status OK, got %d", code)
+		}
+		if restored.Path != "/docs/notes (1).txt" {
+			t.Errorf("expected item restored under a free name, got %s", restored.Path)
+		}
+		if data, err := os.ReadFile(filepath.Join(rootDir, "docs", "notes (1).txt")); err != nil || string(data) != "notes" {
+			t.Errorf("expected restored contents, got %q (%v)", data, err)
+		}
+	})
+
+	t.Run("invalid paths", func(t *testing.T) {
+		for _, path := range []string{"/", "/missing", "/.fs4", "/.fs4/trash"} {
+			if code := do("alice", http.MethodDelete, "/api/v1/files?path="+path, "", nil); code != http.StatusBadRequest {
+				t.Errorf("expected status 400 deleting %s, got %d", path, code)
+			}
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		var first, second trash.Item
+		do("alice", http.MethodDelete, "/api/v1/files?path=/docs/other.txt", "", &first)
+		do("alice", http.MethodDelete, "/api/v1/files?path=/keep.txt", "", &second)
+		if code := do("alice", http.MethodDelete, "/api/v1/trash/"+first.ID, "", nil); code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", code)
+		}
+		if code := do("alice", http.MethodDelete, "/api/v1/trash/"+first.ID, "", nil); code != http.StatusNotFound {
+			t.Errorf("expected status 404 purging twice, got %d", code)
+		}
+		if items := list("alice"); len(items) != 1 || items[0].ID != second.ID {
+			t.Errorf("expected only the second item left, got %v", items)
+		}
+		if code := do("alice", http.MethodDelete, "/api/v1/trash", "", nil); code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", code)
+		}
+		if items := list("alice"); len(items) != 0 {
+			t.Errorf("expected an empty trash, got %v", items)
+		}
+	})
+}
+
+func TestUploadHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootDir, "docs", "dir"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	history := versions.New(rootDir, filepath.Join(rootDir, StateDir, "versions"), versions.Retention{MaxCount: 5}, slog.Default())
+	// nolint:errcheck
+	defer history.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("PUT /api/v1/files", UploadHandler(rootDir, history, nil))
+	mux.Handle("POST /api/v1/files", FilesHandler(rootDir, nil, history, nil))
+	mux.Handle("GET /api/v1/versions", VersionsHandler(rootDir, history))
+	mux.Handle("GET /api/v1/versions/{id}", VersionDownloadHandler(rootDir, history))
+	mux.Handle("POST /api/v1/versions/{id}/restore", VersionRestoreHandler(rootDir, history))
+
+	do := func(method, target, body string, data interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if data != nil {
+			var apiResp TestAPIResponse
+			if err := json.NewDecoder(bytes.NewReader(recorder.Body.Bytes())).Decode(&apiResp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			_ = json.Unmarshal(apiResp.Data, data)
+		}
+		return recorder
+	}
+	readFile := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(rootDir, name))
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		return string(data)
+	}
+
+	var uploaded filesResponse
+	if code := do(http.MethodPut, "/api/v1/files?path=/docs/notes.txt", "first", &uploaded).Code; code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", code)
+	}
+	if uploaded.Name != "notes.txt" || uploaded.Size != 5 || uploaded.Type != "file" {
+		t.Errorf("unexpected response %+v", uploaded)
+	}
+	if code := do(http.MethodPut, "/api/v1/files?path=/docs/notes.txt", "second", nil).Code; code != http.StatusOK {
+		t.Fatalf("expected status OK replacing a file, got %d", code)
+	}
+	if content := readFile("docs/notes.txt"); content != "second" {
+		t.Errorf("expected uploaded content, got %q", content)
+	}
+
+	for _, path := range []string{"/", "/missing/notes.txt", "/docs/dir", "/.fs4/notes.txt"} {
+		if code := do(http.MethodPut, "/api/v1/files?path="+path, "x", nil).Code; code != http.StatusBadRequest {
+			t.Errorf("expected status 400 uploading to %s, got %d", path, code)
+		}
+	}
+	// the upload's temporary files are cleaned up, and hidden from listings
+	var listing filesResponse
+	do(http.MethodPost, "/api/v1/files", `{"path":"/","fields":["versions"]}`, &listing)
+	if len(listing.Contents) != 1 || listing.Contents[0].Name != "docs" {
+		t.Errorf("expected only docs in the root, got %+v", listing.Contents)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(rootDir, StateDir, "uploads")); len(entries) != 0 {
+		t.Errorf("expected no temporary uploads left, got %v", entries)
+	}
+
+	t.Run("versions", func(t *testing.T) {
+		var listing struct {
+			Contents []map[string]interface{} `json:"contents"`
+		}
+		do(http.MethodPost, "/api/v1/files", `{"path":"/docs","fields":["versions"]}`, &listing)
+		for _, entry := range listing.Contents {
+			if entry["name"] == "notes.txt" && entry["versions"] != float64(1) {
+				t.Errorf("expected 1 version of notes.txt in the listing, got %v", entry)
+			}
+		}
+
+		var list versionsResponse
+		do(http.MethodGet, "/api/v1/versions?path=/docs/notes.txt", "", &list)
+		if len(list.Versions) != 1 || list.Versions[0].Size != 5 || list.Versions[0].Path != "/docs/notes.txt" {
+			t.Fatalf("expected the first upload as a version, got %+v", list.Versions)
+		}
+		id := list.Versions[0].ID
+
+		recorder := do(http.MethodGet, "/api/v1/versions/"+id+"?path=/docs/notes.txt", "", nil)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "first" {
+			t.Errorf("expected version content, got %d %q", recorder.Code, recorder.Body.String())
+		}
+		if cd := recorder.Header().Get("Content-Disposition"); cd != "attachment; filename=notes.txt" {
+			t.Errorf("expected notes.txt attachment, got %s", cd)
+		}
+		if code := do(http.MethodGet, "/api/v1/versions/"+id+"?path=/docs/other.txt", "", nil).Code; code != http.StatusNotFound {
+			t.Errorf("expected status 404 for a version of another file, got %d", code)
+		}
+
+		if code := do(http.MethodPost, "/api/v1/versions/"+id+"/restore?path=/docs/notes.txt", "", nil).Code; code != http.StatusOK {
+			t.Fatalf("expected status OK, got %d", code)
+		}
+		if content := readFile("docs/notes.txt"); content != "first" {
+			t.Errorf("expected restored content, got %q", content)
+		}
+		do(http.MethodGet, "/api/v1/versions?path=/docs/notes.txt", "", &list)
+		if len(list.Versions) != 1 || list.Versions[0].Size != 6 {
+			t.Errorf("expected the replaced content as a version, got %+v", list.Versions)
+		}
+		if code := do(http.MethodPost, "/api/v1/versions/"+id+"/restore?path=/docs/notes.txt", "", nil).Code; code != http.StatusNotFound {
+			t.Errorf("expected status 404 restoring twice, got %d", code)
+		}
+	})
+}
+
+func TestShareHandlers(t *testing.T) {
+	rootDir := t.TempDir()
+	for name, content := range map[string]string{
+		"docs/notes.txt":     "notes",
+		"docs/sub/other.txt": "other",
+		"secret.txt":         "secret",
+	} {
+		p := filepath.Join(rootDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(rootDir, "secret.txt"), filepath.Join(rootDir, "docs", "link.txt")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	store, err := shares.Open(filepath.Join(rootDir, StateDir, "shares.json"))
+	if err != nil {
+		t.Fatalf("failed to open shares: %v", err)
+	}
+	backend := auth.NewInMemoryBackend()
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/v1/shares", RequireAuth(CreateShareHandler(rootDir, store), backend))
+	mux.Handle("GET /api/v1/shares", RequireAuth(SharesHandler(store), backend))
+	mux.Handle("DELETE /api/v1/shares/{id}", RequireAuth(RevokeShareHandler(store), backend))
+	mux.Handle("GET /s/{token}", SharedHandler(rootDir, store))
+	mux.Handle("GET /s/{token}/download", SharedDownloadHandler(rootDir, store, nil))
+
+	do := func(user, method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if user != "" {
+			session, err := backend.CreateSession(user)
+			if err != nil {
+				t.Fatalf("failed to create session: %v", err)
+			}
+			req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		return recorder
+	}
+	decode := func(recorder *httptest.ResponseRecorder, data interface{}) {
+		t.Helper()
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(recorder.Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if err := json.Unmarshal(apiResp.Data, data); err != nil {
+			t.Fatalf("failed to decode data: %v", err)
+		}
+	}
+	create := func(body string) shareCreatedResponse {
+		t.Helper()
+		recorder := do("alice", http.MethodPost, "/api/v1/shares", body)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("expected status 201 creating share, got %d", recorder.Code)
+		}
+		var created shareCreatedResponse
+		decode(recorder, &created)
+		return created
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{"path": "/"}`,
+			`{"path": "/.fs4"}`,
+			`{"path": "/missing.txt"}`,
+			`{"path": "/docs", "maxDownloads": -1}`,
+			`{"path": "/docs", "expires": "2000-01-01T00:00:00Z"}`,
+		} {
+			if code := do("alice", http.MethodPost, "/api/v1/shares", body).Code; code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %s, got %d", body, code)
+			}
+		}
+		if code := do("", http.MethodPost, "/api/v1/shares", `{"path": "/docs"}`).Code; code != http.StatusUnauthorized {
+			t.Errorf("expected status 401 creating a share without a session, got %d", code)
+		}
+		if code := do("", http.MethodGet, "/s/unknown", "").Code; code != http.StatusNotFound {
+			t.Errorf("expected status 404 for an unknown token, got %d", code)
+		}
+	})
+
+	t.Run("protected without listing", func(t *testing.T) {
+		created := create(`{"path": "/docs", "password": "hunter2"}`)
+		if created.URL != "/s/"+created.Token || !created.Protected || created.Listing {
+			t.Fatalf("unexpected share %+v", created)
+		}
+
+		recorder := do("", http.MethodGet, created.URL, "")
+		if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("expected a password challenge, got %d", recorder.Code)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, created.URL, nil)
+		req.SetBasicAuth("", "hunter2")
+		recorder = httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status OK with the password, got %d", recorder.Code)
+		}
+		var entry filesResponse
+		decode(recorder, &entry)
+		if entry.Name != "docs" || entry.Type != "dir" || entry.Contents != nil {
+			t.Errorf("expected the directory without contents, got %+v", entry)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, created.URL+"?path=/notes.txt", nil)
+		req.SetBasicAuth("", "hunter2")
+		recorder = httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("expected status 403 browsing without listing, got %d", recorder.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, created.URL+"/download", nil)
+		req.SetBasicAuth("", "hunter2")
+		recorder = httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/zip" {
+			t.Fatalf("expected the directory as a zip archive, got %d", recorder.Code)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		names := map[string]bool{}
+		for _, f := range zr.File {
+			names[f.Name] = true
+		}
+		if !names["docs/notes.txt"] || !names["docs/sub/other.txt"] || names["docs/link.txt"] {
+			t.Errorf("expected the shared files without the symlink out of the share, got %v", names)
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		created := create(`{"path": "/docs", "listing": true}`)
+
+		recorder := do("", http.MethodGet, created.URL+"?path=/sub", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status OK, got %d", recorder.Code)
+		}
+		var entry filesResponse
+		decode(recorder, &entry)
+		if len(entry.Contents) != 1 || entry.Contents[0].Name != "other.txt" {
+			t.Errorf("expected the subdirectory's contents, got %+v", entry.Contents)
+		}
+
+		recorder = do("", http.MethodGet, created.URL+"/download?path=/sub/other.txt", "")
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "other" {
+			t.Errorf("expected the file's content, got %d %q", recorder.Code, recorder.Body.String())
+		}
+
+		for _, path := range []string{"/../secret.txt", "/link.txt", "%2e%2e/secret.txt"} {
+			if code := do("", http.MethodGet, created.URL+"/download?path="+path, "").Code; code != http.StatusNotFound {
+				t.Errorf("expected status 404 for %s outside the share, got %d", path, code)
+			}
+		}
+	})
+
+	t.Run("download limit", func(t *testing.T) {
+		created := create(`{"path": "/docs/notes.txt", "maxDownloads": 1}`)
+
+		recorder := do("", http.MethodGet, created.URL+"/download", "")
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "notes" {
+			t.Fatalf("expected the file's content, got %d %q", recorder.Code, recorder.Body.String())
+		}
+		if code := do("", http.MethodGet, created.URL+"/download", "").Code; code != http.StatusGone {
+			t.Errorf("expected status 410 past the download limit, got %d", code)
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		created := create(`{"path": "/docs/notes.txt"}`)
+
+		if code := do("bob", http.MethodDelete, "/api/v1/shares/"+created.ID, "").Code; code != http.StatusNotFound {
+			t.Errorf("expected status 404 revoking another user's share, got %d", code)
+		}
+		if code := do("alice", http.MethodDelete, "/api/v1/shares/"+created.ID, "").Code; code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", code)
+		}
+		if code := do("", http.MethodGet, created.URL, "").Code; code != http.StatusNotFound {
+			t.Errorf("expected status 404 for a revoked share, got %d", code)
+		}
+
+		var list sharesResponse
+		decode(do("alice", http.MethodGet, "/api/v1/shares", ""), &list)
+		for _, share := range list.Shares {
+			if share.ID == created.ID {
+				t.Errorf("expected the revoked share not to be listed")
+			}
+		}
+		decode(do("bob", http.MethodGet, "/api/v1/shares", ""), &list)
+		if len(list.Shares) != 0 {
+			t.Errorf("expected no shares for another user, got %+v", list.Shares)
+		}
+	})
+
+	t.Run("password lockout", func(t *testing.T) {
+		created := create(`{"path": "/docs/notes.txt", "password": "hunter2"}`)
+		get := func(password string) int {
+			req := httptest.NewRequest(http.MethodGet, created.URL, nil)
+			req.SetBasicAuth("", password)
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, req)
+			return recorder.Code
+		}
+
+		code := get("wrong")
+		for i := 0; i < 10 && code == http.StatusUnauthorized; i++ {
+			code = get("wrong")
+		}
+		if code != http.StatusTooManyRequests {
+			t.Fatalf("expected status 429 after repeated wrong passwords, got %d", code)
+		}
+		if code := get("hunter2"); code != http.StatusTooManyRequests {
+			t.Errorf("expected status 429 with the password while locked, got %d", code)
+		}
+	})
+
+	t.Run("symlink to the root", func(t *testing.T) {
+		if err := os.Symlink(".", filepath.Join(rootDir, "rootlink")); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+		if code := do("alice", http.MethodPost, "/api/v1/shares", `{"path": "/rootlink"}`).Code; code != http.StatusBadRequest {
+			t.Errorf("expected status 400 sharing the root through a symlink, got %d", code)
+		}
+		// made directly, as such shares can no longer be created
+		_, token, err := store.Create("alice", "/rootlink", shares.Options{Listing: true})
+		if err != nil {
+			t.Fatalf("failed to create share: %v", err)
+		}
+
+		var listing filesResponse
+		decode(do("", http.MethodGet, "/s/"+token, ""), &listing)
+		for _, entry := range listing.Contents {
+			if entry.Name == StateDir {
+				t.Errorf("expected the state directory not to be listed")
+			}
+		}
+		if code := do("", http.MethodGet, "/s/"+token+"/download?path=/rootlink/"+StateDir+"/shares.json", "").Code; code != http.StatusNotFound {
+			t.Errorf("expected status 404 for the state directory, got %d", code)
+		}
+	})
+}
+
+func TestQuotaHandlers(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootDir, "docs"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	quotas, err := quota.Open(rootDir, filepath.Join(rootDir, StateDir, "quota.json"), quota.Limits{Soft: 5, Hard: 10}, nil, slog.Default())
+	if err != nil {
+		t.Fatalf("failed to open quotas: %v", err)
+	}
+	bin := trash.New(rootDir, filepath.Join(rootDir, StateDir, "trash"), 0, slog.Default())
+	// nolint:errcheck
+	defer bin.Close()
+
+	backend := auth.NewInMemoryBackend()
+	if err := backend.AddUser("admin", "password"); err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	if err := backend.SetAdmin("admin", true); err != nil {
+		t.Fatalf("failed to set admin: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/v1/auth/me", MeHandler(quotas))
+	mux.Handle("PUT /api/v1/files", UploadHandler(rootDir, nil, quotas))
+	mux.Handle("DELETE /api/v1/files", DeleteHandler(rootDir, bin, quotas))
+	mux.Handle("POST /api/v1/trash/{id}/restore", RestoreHandler(rootDir, bin, quotas))
+	mux.Handle("POST /api/v1/quota/recalculate", RequireAdmin(QuotaRecalculateHandler(quotas), backend))
+	handler := RequireAuth(mux, backend)
+
+	do := func(user, method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		session, err := backend.CreateSession(user)
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	usage := func() quota.Usage {
+		t.Helper()
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(do("alice", http.MethodGet, "/api/v1/auth/me", "").Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var reply sessionReply
+		if err := json.Unmarshal(apiResp.Data, &reply); err != nil || reply.Quota == nil {
+			t.Fatalf("expected usage in the session, got %s", apiResp.Data)
+		}
+		return *reply.Quota
+	}
+
+	recorder := do("alice", http.MethodPut, "/api/v1/files?path=/docs/a.txt", "1234")
+	if recorder.Code != http.StatusCreated || recorder.Header().Get(QuotaWarningHeader) != "" {
+		t.Fatalf("expected status 201 without a warning, got %d", recorder.Code)
+	}
+	recorder = do("alice", http.MethodPut, "/api/v1/files?path=/docs/b.txt", "1234")
+	if recorder.Code != http.StatusCreated || recorder.Header().Get(QuotaWarningHeader) == "" {
+		t.Fatalf("expected status 201 with a warning over the soft limit, got %d", recorder.Code)
+	}
+	if u := usage(); u.Used != 8 || u.Files != 2 || u.Hard != 10 || !u.OverSoft {
+		t.Errorf("unexpected usage %+v", u)
+	}
+
+	recorder = do("alice", http.MethodPut, "/api/v1/files?path=/docs/c.txt", "1234")
+	if recorder.Code != http.StatusInsufficientStorage || !strings.Contains(recorder.Body.String(), quota.ErrExceeded.Error()) {
+		t.Fatalf("expected status 507 over the hard limit, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "docs", "c.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the refused upload not to be written, got %v", err)
+	}
+	// chunked, so only known to be over the limit once read
+	session, err := backend.CreateSession("alice")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/docs/c.txt", io.MultiReader(strings.NewReader("1234")))
+	req.ContentLength = -1
+	req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusInsufficientStorage || !strings.Contains(recorder.Body.String(), quota.ErrExceeded.Error()) {
+		t.Fatalf("expected status 507 for a chunked upload over the hard limit, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "docs", "c.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the refused chunked upload not to be written, got %v", err)
+	}
+	if code := do("bob", http.MethodPut, "/api/v1/files?path=/docs/c.txt", "1234").Code; code != http.StatusCreated {
+		t.Errorf("expected another user's upload to be allowed, got %d", code)
+	}
+
+	var item trash.Item
+	recorder = do("alice", http.MethodDelete, "/api/v1/files?path=/docs/b.txt", "")
+	var apiResp TestAPIResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&apiResp); err != nil || json.Unmarshal(apiResp.Data, &item) != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if u := usage(); u.Used != 4 || u.Files != 1 {
+		t.Errorf("expected the deleted file no longer charged, got %+v", u)
+	}
+	if code := do("alice", http.MethodPost, "/api/v1/trash/"+item.ID+"/restore", "").Code; code != http.StatusOK {
+		t.Fatalf("expected status OK restoring, got %d", code)
+	}
+	if u := usage(); u.Used != 8 || u.Files != 2 {
+		t.Errorf("expected the restored file charged again, got %+v", u)
+	}
+
+	if err := os.WriteFile(filepath.Join(rootDir, "docs", "a.txt"), []byte("1"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if code := do("alice", http.MethodPost, "/api/v1/quota/recalculate", "").Code; code != http.StatusForbidden {
+		t.Errorf("expected status 403 recalculating as a user, got %d", code)
+	}
+	if code := do("admin", http.MethodPost, "/api/v1/quota/recalculate", "").Code; code != http.StatusOK {
+		t.Fatalf("expected status OK recalculating as an admin, got %d", code)
+	}
+	if u := usage(); u.Used != 5 {
+		t.Errorf("expected the recalculated usage, got %+v", u)
+	}
+
+	recorder = do("alice", http.MethodDelete, "/api/v1/files?path=/docs/b.txt", "")
+	if err := json.NewDecoder(recorder.Body).Decode(&apiResp); err != nil || json.Unmarshal(apiResp.Data, &item) != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if code := do("alice", http.MethodPut, "/api/v1/files?path=/docs/d.txt", "123456789").Code; code != http.StatusCreated {
+		t.Fatalf("expected status 201 uploading up to the hard quota, got %d", code)
+	}
+	if code := do("alice", http.MethodPost, "/api/v1/trash/"+item.ID+"/restore", "").Code; code != http.StatusInsufficientStorage {
+		t.Errorf("expected status 507 restoring over the hard quota, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "docs", "b.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the refused restore not to be moved back, got %v", err)
+	}
+	if u := usage(); u.Used != 10 || u.Files != 2 {
+		t.Errorf("expected the refused restore not to be charged, got %+v", u)
+	}
+}
+
+func TestDirSizeHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootDir, "docs", "sub"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{"docs/a.txt": "hello", "docs/sub/b.txt": "world!", "notes.txt": "notes"} {
+		if err := os.WriteFile(filepath.Join(rootDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	hub := watch.NewHub(rootDir, slog.Default())
+	// nolint:errcheck
+	defer hub.Close()
+	sizes := dirsize.New(rootDir, hub, dirsize.Options{Workers: 1, MaxWatched: 100, TTL: time.Hour, MaxCached: 10, Exclude: filepath.Join(rootDir, StateDir)}, slog.Default())
+	// nolint:errcheck
+	defer sizes.Close()
+
+	get := func(query string) (int, dirsize.Status) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/dirsize"+query, nil)
+		recorder := httptest.NewRecorder()
+		DirSizeHandler(rootDir, sizes).ServeHTTP(recorder, req)
+
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(recorder.Result().Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var status dirsize.Status
+		_ = json.Unmarshal(apiResp.Data, &status)
+		return recorder.Code, status
+	}
+
+	code, status := get("?path=/docs")
+	deadline := time.Now().Add(5 * time.Second)
+	for code == http.StatusAccepted && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		code, status = get("?path=/docs")
+	}
+	if code != http.StatusOK || status.State != dirsize.StateDone || status.Size != 11 || status.Items != 3 || status.Path != "/docs" {
+		t.Fatalf("expected 11 bytes in 3 items, got %d %+v", code, status)
+	}
+	for _, query := range []string{"?path=/notes.txt", "?path=/missing", "?path=/" + StateDir} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %q, got %d", query, code)
+		}
+	}
+
+	// listings include the sizes calculated so far
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/files", strings.NewReader(`{"path": "/"}`))
+	recorder := httptest.NewRecorder()
+	FilesHandler(rootDir, nil, nil, sizes).ServeHTTP(recorder, req)
+	var apiResp TestAPIResponse
+	var listing filesResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&apiResp); err != nil || json.Unmarshal(apiResp.Data, &listing) != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	for _, entry := range listing.Contents {
+		if entry.Name == "docs" && (entry.Size != 11 || entry.Items == nil || *entry.Items != 3) {
+			t.Errorf("expected the size of docs in the listing, got %+v", entry)
+		}
+		if entry.Name == "notes.txt" && (entry.Size != 5 || entry.Items != nil) {
+			t.Errorf("expected only the file's own size, got %+v", entry)
+		}
+	}
+}
+
+func TestDiskUsageHandler(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootDir, "docs", "sub"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{"docs/a.txt": "hello", "docs/sub/b.txt": "world!", "notes.md": "notes"} {
+		if err := os.WriteFile(filepath.Join(rootDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	analyzer := diskusage.New(rootDir, 2, filepath.Join(rootDir, StateDir))
+
+	backend := auth.NewInMemoryBackend()
+	for _, user := range []string{"admin", "alice"} {
+		if err := backend.AddUser(user, "password"); err != nil {
+			t.Fatalf("failed to add user: %v", err)
+		}
+	}
+	if err := backend.SetAdmin("admin", true); err != nil {
+		t.Fatalf("failed to set admin: %v", err)
+	}
+	handler := RequireAuth(RequireAdmin(DiskUsageHandler(rootDir, analyzer, time.Minute), backend), backend)
+
+	get := func(user, query string) (int, diskusage.Node) {
+		t.Helper()
+		session, err := backend.CreateSession(user)
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/diskusage"+query, nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: session.ID})
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		var apiResp TestAPIResponse
+		if err := json.NewDecoder(recorder.Result().Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var tree diskusage.Node
+		_ = json.Unmarshal(apiResp.Data, &tree)
+		return recorder.Code, tree
+	}
+
+	if code, _ := get("alice", "?path=/"); code != http.StatusForbidden {
+		t.Errorf("expected status 403 for a user, got %d", code)
+	}
+	code, tree := get("admin", "?path=/&depth=1&limit=1")
+	if code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", code)
+	}
+	if tree.Size != 16 || tree.Files != 3 || tree.Dirs != 2 || len(tree.Children) != 1 || tree.Children[0].Path != "/docs" {
+		t.Errorf("expected docs as the largest entry of the root, got %+v", tree)
+	}
+	if tree.Other == nil || tree.Other.Size != 5 || len(tree.Types) != 2 || len(tree.Ages) != len(diskusage.Ages) {
+		t.Errorf("expected notes.md left out and the usage broken down, got %+v", tree)
+	}
+
+	for _, query := range []string{"?path=/notes.md", "?path=/missing", "?path=/&depth=-1", "?path=/&limit=0", "?path=/&depth=x"} {
+		if code, _ := get("admin", query); code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %q, got %d", query, code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goteleport-interview/fs4/api/archivefs"
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/metrics"
+)
+
+// ErrArchiveRead is returned when an archive cannot be browsed.
+var ErrArchiveRead = errors.New("failed to read archive")
+
+// splitArchivePath splits path into the archive file it passes through, if any, and the
+// slash-separated member path inside that archive. ok is false if path does not pass
+// through an archive under rootDir, including when it is an ordinary directory.
+func splitArchivePath(rootDir string, path string) (archive string, member string, ok bool) {
+	root := filepath.Clean(rootDir)
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return "", "", false
+	}
+
+	elems := strings.Split(rel, string(filepath.Separator))
+	for i := range elems {
+		candidate := filepath.Join(root, filepath.Join(elems[:i+1]...))
+		info, err := os.Stat(candidate)
+		if err != nil {
+			return "", "", false
+		}
+		if info.IsDir() {
+			continue
+		}
+		if !info.Mode().IsRegular() || !archivefs.Supported(candidate) || !resolvesWithin(root, candidate) {
+			return "", "", false
+		}
+		return candidate, strings.Join(elems[i+1:], "/"), true
+	}
+	return "", "", false
+}
+
+// resolvesWithin reports whether path is inside dir, and outside its StateDir, once symlinks
+// are resolved.
+func resolvesWithin(dir, path string) bool {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	return err == nil && withinRoot(root, resolved)
+}
+
+// archiveError returns the message and status code for an error opening an archive member.
+func archiveError(err error) (string, int) {
+	switch {
+	case errors.Is(err, archivefs.ErrNotFound), errors.Is(err, archivefs.ErrNotDir):
+		return ErrDirNotFound.Error(), http.StatusBadRequest
+	case errors.Is(err, archivefs.ErrIsDir):
+		return ErrNotFile.Error(), http.StatusBadRequest
+	case errors.Is(err, archivefs.ErrTooLarge):
+		return archivefs.ErrTooLarge.Error(), http.StatusUnprocessableEntity
+	default:
+		// most likely corrupt, or not an archive despite its name
+		return ErrArchiveRead.Error(), http.StatusUnprocessableEntity
+	}
+}
+
+// readArchiveDir returns the directory member inside archive and its contents.
+func readArchiveDir(archive, member string) (archivefs.Entry, []archivefs.Entry, error) {
+	a, err := archivefs.Open(archive, archivefs.DefaultLimits)
+	if err != nil {
+		return archivefs.Entry{}, nil, err
+	}
+	// nolint:errcheck
+	defer a.Close()
+
+	dir, err := a.Stat(member)
+	if err != nil {
+		return archivefs.Entry{}, nil, err
+	}
+	entries, err := a.ReadDir(member)
+	return dir, entries, err
+}
+
+// openMember opens the file member inside archive. Closing the returned reader closes the archive.
+func openMember(archive, member string) (archivefs.Entry, io.ReadCloser, error) {
+	a, err := archivefs.Open(archive, archivefs.DefaultLimits)
+	if err != nil {
+		return archivefs.Entry{}, nil, err
+	}
+
+	file, err := a.Stat(member)
+	if err != nil {
+		_ = a.Close()
+		return archivefs.Entry{}, nil, err
+	}
+	rc, err := a.Open(member)
+	if err != nil {
+		_ = a.Close()
+		return archivefs.Entry{}, nil, err
+	}
+	return file, memberReader{rc, a}, nil
+}
+
+// memberReader reads an archive member, closing the archive along with it.
+type memberReader struct {
+	io.ReadCloser
+	archive *archivefs.Archive
+}
+
+func (m memberReader) Close() error {
+	return errors.Join(m.ReadCloser.Close(), m.archive.Close())
+}
+
+// listArchive responds with the contents of the directory member inside archive.
+func listArchive(w http.ResponseWriter, r *http.Request, archive, member string, event audit.Event) {
+	dir, entries, err := readArchiveDir(archive, member)
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Detail = err.Error()
+		audit.Record(r, event)
+		message, code := archiveError(err)
+		RespondWithError(w, message, code)
+		return
+	}
+
+	event.Outcome = audit.OutcomeSuccess
+	audit.Record(r, event)
+	metrics.FromContext(r.Context()).ObserveListing(len(entries))
+
+	RespondWithJSON(w, formatArchiveContents(dir, entries), http.StatusOK)
+}
+
+// formatArchiveContents is formatDirContents for a directory inside an archive.
+func formatArchiveContents(dir archivefs.Entry, entries []archivefs.Entry) filesResponse {
+	var contents []filesResponse
+	for _, entry := range entries {
+		file := filesResponse{Name: entry.Name, Modified: entry.Modified, Type: "file", Size: entry.Size}
+		if entry.Dir {
+			file.Type = "dir"
+			file.Size = 0
+		}
+		contents = append(contents, file)
+	}
+
+	return filesResponse{
+		Name:     dir.Name,
+		Modified: dir.Modified,
+		Type:     "dir",
+		Size:     0,
+		Contents: contents,
+	}
+}
+
+// serveMember responds with the contents of the file member inside archive.
+func serveMember(w http.ResponseWriter, r *http.Request, archive, member string, event audit.Event) {
+	file, rc, err := openMember(archive, member)
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Detail = err.Error()
+		audit.Record(r, event)
+		message, code := archiveError(err)
+		RespondWithError(w, message, code)
+		return
+	}
+	// nolint:errcheck
+	defer rc.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(file.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	if !file.Modified.IsZero() {
+		w.Header().Set("Last-Modified", file.Modified.UTC().Format(http.TimeFormat))
+	}
+	setAttachment(w, file.Name)
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Detail = err.Error()
+		audit.Record(r, event)
+		if r.Context().Err() == nil {
+			log.Printf("Failed to extract %s: %v", event.Path, err)
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	event.Outcome = audit.OutcomeSuccess
+	audit.Record(r, event)
+}
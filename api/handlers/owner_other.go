@@ -0,0 +1,10 @@
+//go:build !unix
+
+package handlers
+
+import "io/fs"
+
+// fileOwner returns false, as files have no owner IDs on this platform.
+func fileOwner(fs.FileInfo) (uid string, gid string, ok bool) {
+	return "", "", false
+}
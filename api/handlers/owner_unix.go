@@ -0,0 +1,18 @@
+//go:build unix
+
+package handlers
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the IDs of the user and group owning the file described by info.
+func fileOwner(info fs.FileInfo) (uid string, gid string, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatUint(uint64(stat.Uid), 10), strconv.FormatUint(uint64(stat.Gid), 10), true
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/goteleport-interview/fs4/api/quota"
+)
+
+// ErrQuota is returned when storage usage cannot be recalculated.
+var ErrQuota = errors.New("failed to recalculate storage usage")
+
+type quotaResponse struct {
+	Users map[string]quota.Usage `json:"users"`
+}
+
+// QuotaRecalculateHandler is the handler for the /quota/recalculate endpoint.
+// It recalculates the storage usage of every user from the files charged to them, and
+// responds with the usage of each.
+func QuotaRecalculateHandler(quotas *quota.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		usages, err := quotas.Recalculate()
+		if err != nil {
+			log.Printf("Failed to recalculate storage usage: %v", err)
+			RespondWithError(w, ErrQuota.Error(), http.StatusInternalServerError)
+			return
+		}
+		RespondWithJSON(w, quotaResponse{Users: usages}, http.StatusOK)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader is the header used to propagate request IDs to and from clients.
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	// ForwardedForHeader lists the client and proxy addresses of a proxied request.
+	ForwardedForHeader = "X-Forwarded-For"
+	// ForwardedProtoHeader is the scheme the client used to connect to a proxy.
+	ForwardedProtoHeader = "X-Forwarded-Proto"
+)
+
+// IsSecure reports whether the client connected over HTTPS, either directly or to a proxy.
+// The server only passes on X-Forwarded-Proto from trusted proxies.
+func IsSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get(ForwardedProtoHeader) == "https"
+}
+
+// RequestInfo holds per-request details shared between middleware layers.
+// It is stored in the request context as a pointer so that inner handlers,
+// such as RequireAuth, can fill in details for outer ones to log.
+type RequestInfo struct {
+	ID   string
+	User string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying info.
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// GetRequestInfo returns the request info stored in ctx, or nil if there is none.
+func GetRequestInfo(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// RequestID returns the ID of the request, or an empty string if none was assigned.
+func RequestID(r *http.Request) string {
+	if info := GetRequestInfo(r.Context()); info != nil {
+		return info.ID
+	}
+	return ""
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/checksum"
+	"github.com/goteleport-interview/fs4/api/metrics"
+	"github.com/goteleport-interview/fs4/api/shares"
+)
+
+var (
+	// ErrShares is returned when shares cannot be read or changed.
+	ErrShares = errors.New("failed to update shares")
+	// ErrShareListing is returned when browsing a shared directory that can only be downloaded
+	// as a whole.
+	ErrShareListing = errors.New("listing is not allowed for this share")
+)
+
+// shareRealm is the realm in which passwords for protected shares are requested.
+const shareRealm = `Basic realm="fs4 share", charset="UTF-8"`
+
+type shareRequest struct {
+	Path         string     `json:"path"`
+	Expires      *time.Time `json:"expires"`
+	Password     string     `json:"password"`
+	MaxDownloads int        `json:"maxDownloads"`
+	Listing      bool       `json:"listing"`
+}
+
+type shareCreatedResponse struct {
+	shares.Share
+	// Token is only ever shown here, when the share is created.
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+type sharesResponse struct {
+	Shares []shares.Share `json:"shares"`
+}
+
+// CreateShareHandler is the handler for creating on the /shares endpoint.
+// It shares the file or directory at the path of the request with anyone given the link it
+// responds with, until the share expires, reaches its download limit or is revoked.
+func CreateShareHandler(rootDir string, store *shares.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req shareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			RespondWithError(w, ErrInvalidReqBody.Error(), http.StatusBadRequest)
+			return
+		}
+		path, err := sharePath(rootDir, req.Path)
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := shares.Options{Password: req.Password, MaxDownloads: req.MaxDownloads, Listing: req.Listing}
+		if req.Expires != nil {
+			opts.Expires = *req.Expires
+		}
+		event := audit.Event{Action: audit.ActionShare, Path: relPath(rootDir, path)}
+		share, token, err := store.Create(sessionUser(r), relPath(rootDir, path), opts)
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			message, code := shareError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+
+		event.Outcome = audit.OutcomeSuccess
+		event.Detail = "share " + share.ID
+		audit.Record(r, event)
+		RespondWithJSON(w, shareCreatedResponse{Share: share, Token: token, URL: "/s/" + token}, http.StatusCreated)
+	}
+}
+
+// sharePath returns the absolute path of the file or directory at path to share, which must
+// exist under rootDir, and not be inside an archive.
+func sharePath(rootDir, path string) (string, error) {
+	path, err := cleanPath(rootDir, path)
+	if err != nil || path == filepath.Clean(rootDir) {
+		// the whole root is never shared
+		return "", ErrInvalidPath
+	}
+	if _, member, ok := splitArchivePath(rootDir, path); ok && member != "" {
+		return "", ErrInvalidPath
+	}
+	if _, err := os.Stat(path); err != nil || !resolvesWithin(rootDir, path) {
+		return "", ErrFileNotFound
+	}
+	if sameDir(rootDir, path) {
+		// nor through a symlink to it
+		return "", ErrInvalidPath
+	}
+	return path, nil
+}
+
+// SharesHandler is the handler for listing the /shares endpoint.
+// It responds with the user's shares, most recently created first.
+func SharesHandler(store *shares.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		RespondWithJSON(w, sharesResponse{Shares: store.List(sessionUser(r))}, http.StatusOK)
+	}
+}
+
+// RevokeShareHandler is the handler for deleting from the /shares endpoint.
+// It revokes the user's share with the id path value, so that its link stops working.
+func RevokeShareHandler(store *shares.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		event := audit.Event{Action: audit.ActionRevoke, Outcome: audit.OutcomeSuccess, Detail: "share " + id}
+		if err := store.Revoke(sessionUser(r), id); err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			message, code := shareError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+		audit.Record(r, event)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sharedPath is a path requested through a share.
+type sharedPath struct {
+	share shares.Share
+	// root is the shared file or directory, and path the requested location within it.
+	root string
+	path string
+}
+
+// SharedHandler is the handler for the /s/{token} endpoint, which needs no account.
+// It responds with the shared file or directory, or the location within a shared directory
+// named by the path query parameter. Directories are listed only if the share allows it.
+// Protected shares take their password by basic authentication, with any username, and are
+// locked for a while after repeated wrong passwords.
+func SharedHandler(rootDir string, store *shares.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := audit.Event{Action: audit.ActionList}
+		shared, ok := openShared(w, r, rootDir, store, event)
+		if !ok {
+			return
+		}
+		event.Path = relPath(rootDir, shared.path)
+		event.Detail = "share " + shared.share.ID
+
+		info, err := os.Stat(shared.path)
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			audit.Record(r, event)
+			RespondWithError(w, ErrFileNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if !info.IsDir() {
+			event.Outcome = audit.OutcomeSuccess
+			audit.Record(r, event)
+			RespondWithJSON(w, filesResponse{Name: info.Name(), Modified: info.ModTime(), Type: "file", Size: info.Size()}, http.StatusOK)
+			return
+		}
+
+		var contents []os.DirEntry
+		if shared.share.Listing {
+			if contents, err = getDirContents(shared.path); err != nil {
+				event.Outcome = audit.OutcomeFailure
+				event.Detail += ": " + err.Error()
+				audit.Record(r, event)
+				RespondWithError(w, ErrDirRead.Error(), http.StatusInternalServerError)
+				return
+			}
+			contents = hideStateDir(rootDir, shared.path, contents)
+		}
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		RespondWithJSON(w, formatDirContents(shared.path, contents), http.StatusOK)
+	}
+}
+
+// SharedDownloadHandler is the handler for the /s/{token}/download endpoint, which needs no
+// account. It serves the shared file, or the file named by the path query parameter within a
+// shared directory, as an attachment. Directories are served as an archive in the format
+// query parameter, zip by default. Every request counts towards the share's download limit,
+// including range requests.
+func SharedDownloadHandler(rootDir string, store *shares.Store, sums *checksum.Cache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := audit.Event{Action: audit.ActionDownload}
+		shared, ok := openShared(w, r, rootDir, store, event)
+		if !ok {
+			return
+		}
+		event.Path = relPath(rootDir, shared.path)
+		event.Detail = "share " + shared.share.ID
+
+		entries, format, err := sharedArchive(shared, r.URL.Query().Get("format"))
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail += ": " + err.Error()
+			audit.Record(r, event)
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := store.Download(r.PathValue("token")); err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail += ": " + err.Error()
+			audit.Record(r, event)
+			message, code := shareError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+		if entries != nil {
+			serveArchive(w, r, shared.share.Path, entries, format, event)
+			return
+		}
+		serveFile(w, r, shared.root, shared.path, sums, event)
+	}
+}
+
+// sharedArchive returns the entries and format of an archive of shared.path if it is a
+// directory, or no entries for anything else.
+func sharedArchive(shared sharedPath, formatName string) ([]archiveEntry, archiveFormat, error) {
+	if info, err := os.Stat(shared.path); err != nil || !info.IsDir() {
+		return nil, archiveFormat{}, nil
+	}
+	_, format, err := requestedFormat(formatName)
+	if err != nil {
+		return nil, archiveFormat{}, err
+	}
+	entries, err := archiveEntries(shared.root, []string{relPath(shared.root, shared.path)})
+	return entries, format, err
+}
+
+// openShared checks the token and password of a request to a share, and resolves the
+// requested path within it. If it fails, it records event and responds with the error.
+func openShared(w http.ResponseWriter, r *http.Request, rootDir string, store *shares.Store, event audit.Event) (sharedPath, bool) {
+	_, password, _ := r.BasicAuth()
+	share, err := store.Access(r.PathValue("token"), password)
+	if err == nil {
+		err = checkShared(rootDir, share, r.URL.Query().Get("path"))
+	}
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Path = share.Path
+		event.Detail = err.Error()
+		if share.ID != "" {
+			event.Detail = "share " + share.ID + ": " + event.Detail
+		}
+		audit.Record(r, event)
+		if password != "" && (errors.Is(err, shares.ErrPassword) || errors.Is(err, shares.ErrLocked)) {
+			metrics.FromContext(r.Context()).SharePasswordFailed()
+		}
+		if errors.Is(err, shares.ErrPassword) {
+			w.Header().Set("WWW-Authenticate", shareRealm)
+		}
+		message, code := shareError(err)
+		RespondWithError(w, message, code)
+		return sharedPath{}, false
+	}
+
+	root := filepath.Join(rootDir, filepath.FromSlash(share.Path))
+	// checked by checkShared, so the path is within the share
+	path, _ := cleanPath(root, r.URL.Query().Get("path"))
+	return sharedPath{share: share, root: root, path: path}, true
+}
+
+// checkShared reports whether requested, relative to the shared file or directory, may be
+// reached through share. Nothing is reached through a symlink leading out of the share.
+func checkShared(rootDir string, share shares.Share, requested string) error {
+	root := filepath.Join(rootDir, filepath.FromSlash(share.Path))
+	if !resolvesWithin(rootDir, root) {
+		// the shared file or directory is gone, or now leads out of the root
+		return ErrFileNotFound
+	}
+	path, err := cleanPath(root, requested)
+	if err != nil {
+		return ErrInvalidPath
+	}
+	if path != root && !share.Listing {
+		return ErrShareListing
+	}
+	if !resolvesWithin(root, path) || !resolvesWithin(rootDir, path) {
+		// the share's own checks only exclude a StateDir below the shared directory, so a
+		// symlink leading back to the root could otherwise reach the server's state
+		return ErrFileNotFound
+	}
+	return nil
+}
+
+// shareError returns the message and status code for an error from the share store, or
+// from reaching a path through a share.
+func shareError(err error) (string, int) {
+	switch {
+	case errors.Is(err, shares.ErrNotFound), errors.Is(err, ErrFileNotFound):
+		return shares.ErrNotFound.Error(), http.StatusNotFound
+	case errors.Is(err, shares.ErrExpired), errors.Is(err, shares.ErrExhausted):
+		return err.Error(), http.StatusGone
+	case errors.Is(err, shares.ErrPassword):
+		return shares.ErrPassword.Error(), http.StatusUnauthorized
+	case errors.Is(err, shares.ErrLocked):
+		return shares.ErrLocked.Error(), http.StatusTooManyRequests
+	case errors.Is(err, shares.ErrInvalidOptions):
+		return shares.ErrInvalidOptions.Error(), http.StatusBadRequest
+	case errors.Is(err, ErrShareListing):
+		return ErrShareListing.Error(), http.StatusForbidden
+	case errors.Is(err, ErrInvalidPath):
+		return ErrInvalidPath.Error(), http.StatusBadRequest
+	default:
+		log.Printf("Shares error: %v", err)
+		return ErrShares.Error(), http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"io/fs"
+	"path/filepath"
+	"slices"
+
+	"github.com/goteleport-interview/fs4/api/watch"
+)
+
+// StateDir is the directory under the root holding the server's own state, such as the trash.
+// It is left out of listings, archives and events, and cannot be requested.
+const StateDir = ".fs4"
+
+// isStatePath reports whether path is the StateDir under root, or inside it.
+func isStatePath(root, path string) bool {
+	return withinDir(filepath.Join(root, StateDir), path)
+}
+
+// withinRoot reports whether path is inside root and outside its StateDir.
+func withinRoot(root, path string) bool {
+	return withinDir(root, path) && !isStatePath(root, path)
+}
+
+// hideStateDir removes the StateDir from the entries of dir, if dir is the root, including
+// through a symlink.
+func hideStateDir(rootDir, dir string, entries []fs.DirEntry) []fs.DirEntry {
+	if dir != filepath.Clean(rootDir) && !sameDir(rootDir, dir) {
+		return entries
+	}
+	return slices.DeleteFunc(entries, func(e fs.DirEntry) bool {
+		return e.Name() == StateDir
+	})
+}
+
+// sameDir reports whether a and b are the same directory once symlinks are resolved.
+func sameDir(a, b string) bool {
+	resolvedA, err := filepath.EvalSymlinks(a)
+	if err != nil {
+		return false
+	}
+	resolvedB, err := filepath.EvalSymlinks(b)
+	return err == nil && resolvedA == resolvedB
+}
+
+// visibleEvents removes the events a listing of their directory would not show: those in
+// directories that no longer resolve within rootDir, and those for the StateDir, which changes
+// whenever the trash does, including when watched through a symlink to the root. The removal
+// of a watched directory itself is always shown, as it ends the events for it.
+func visibleEvents(rootDir string, events []watch.Event) []watch.Event {
+	hidden := map[string]func(watch.Event) bool{}
+	return slices.DeleteFunc(events, func(e watch.Event) bool {
+		if e.Op == watch.OpDelete && e.Name == "" {
+			return false
+		}
+		hide, ok := hidden[e.Dir]
+		if !ok {
+			hide = eventFilter(rootDir, e.Dir)
+			hidden[e.Dir] = hide
+		}
+		return hide(e)
+	})
+}
+
+// eventFilter returns a function reporting whether an event in dir, slash-separated and
+// relative to rootDir, is hidden from listings.
+func eventFilter(rootDir, dir string) func(watch.Event) bool {
+	path := filepath.Join(rootDir, filepath.FromSlash(dir))
+	if !resolvesWithin(rootDir, path) {
+		return func(watch.Event) bool { return true }
+	}
+	if path != filepath.Clean(rootDir) && !sameDir(rootDir, path) {
+		return func(watch.Event) bool { return false }
+	}
+	return func(e watch.Event) bool {
+		return e.Name == StateDir || e.OldName == StateDir
+	}
+}
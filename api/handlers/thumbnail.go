@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goteleport-interview/fs4/api/thumbnail"
+)
+
+// defaultThumbnailSize is the thumbnail size used if none is requested.
+const defaultThumbnailSize = 128
+
+var (
+	// ErrThumbnailSize is returned when the requested thumbnail size is not a positive integer.
+	ErrThumbnailSize = errors.New("invalid thumbnail size")
+	// ErrThumbnail is returned when a thumbnail cannot be generated.
+	ErrThumbnail = errors.New("failed to generate thumbnail")
+)
+
+// ThumbnailHandler is the handler for the /thumbnail endpoint.
+// It responds with a JPEG thumbnail of the image named by the path query parameter, at most
+// size pixels along its longest side, rounded up to one of thumbnail.Sizes. Thumbnails are
+// not audited, as browsing a directory of images requests one for every image.
+func ThumbnailHandler(rootDir string, thumbs *thumbnail.Generator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		path, err := cleanPath(rootDir, query.Get("path"))
+		if err != nil {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+		size, err := thumbnailSize(query.Get("size"))
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// cached by the resolved path, so that thumbnails are shared between symlinks
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil || !resolvesWithin(rootDir, resolved) {
+			RespondWithError(w, ErrFileNotFound.Error(), http.StatusBadRequest)
+			return
+		}
+
+		thumb, err := thumbs.Thumbnail(r.Context(), resolved, size)
+		if err != nil {
+			if r.Context().Err() == nil {
+				message, code := thumbnailError(err)
+				RespondWithError(w, message, code)
+			}
+			return
+		}
+		serveThumbnail(w, r, thumb)
+	}
+}
+
+func thumbnailSize(raw string) (int, error) {
+	if raw == "" {
+		return defaultThumbnailSize, nil
+	}
+	size, err := strconv.Atoi(raw)
+	if err != nil || size < 1 {
+		return 0, ErrThumbnailSize
+	}
+	return size, nil
+}
+
+// thumbnailError returns the message and status code for an error generating a thumbnail.
+func thumbnailError(err error) (string, int) {
+	switch {
+	case errors.Is(err, thumbnail.ErrUnsupported):
+		return thumbnail.ErrUnsupported.Error(), http.StatusUnsupportedMediaType
+	case errors.Is(err, thumbnail.ErrTooLarge):
+		return thumbnail.ErrTooLarge.Error(), http.StatusUnprocessableEntity
+	case errors.Is(err, os.ErrNotExist):
+		return ErrFileNotFound.Error(), http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrThumbnail.Error(), http.StatusServiceUnavailable
+	default:
+		// most likely a corrupt image
+		log.Printf("Failed to generate thumbnail: %v", err)
+		return ErrThumbnail.Error(), http.StatusUnprocessableEntity
+	}
+}
+
+// serveThumbnail serves the cached thumbnail file. Its name changes with the image, so it
+// doubles as an ETag and the browser can revalidate cheaply.
+func serveThumbnail(w http.ResponseWriter, r *http.Request, thumb string) {
+	f, err := os.Open(thumb)
+	if err != nil {
+		RespondWithError(w, ErrThumbnail.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer closeFile(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		RespondWithError(w, ErrThumbnail.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", strconv.Quote(strings.TrimSuffix(filepath.Base(thumb), ".jpg")))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/quota"
+	"github.com/goteleport-interview/fs4/api/trash"
+)
+
+// ErrTrash is returned when the trash cannot be read or changed.
+var ErrTrash = errors.New("failed to update trash")
+
+type trashResponse struct {
+	Items []trash.Item `json:"items"`
+}
+
+// DeleteHandler is the handler for deleting from the /files endpoint.
+// It moves the file or directory named by the path query parameter into the user's trash,
+// and responds with the trash.Item it became. Its files are no longer charged to anyone's
+// quota, if quotas is not nil.
+func DeleteHandler(rootDir string, bin *trash.Trash, quotas *quota.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := cleanPath(rootDir, r.URL.Query().Get("path"))
+		if err != nil || path == filepath.Clean(rootDir) {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, member, ok := splitArchivePath(rootDir, path); ok && member != "" {
+			// archives can be deleted, but not their members
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+		// the entry itself may be a symlink, which is deleted rather than what it points to,
+		// but must not be reached through one leading out of the root
+		if !resolvesWithin(rootDir, filepath.Dir(path)) {
+			RespondWithError(w, ErrFileNotFound.Error(), http.StatusBadRequest)
+			return
+		}
+
+		event := audit.Event{Action: audit.ActionDelete, Path: relPath(rootDir, path)}
+		item, err := bin.Delete(sessionUser(r), path)
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			message, code := trashError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+
+		if quotas != nil {
+			if err := quotas.Release(path); err != nil {
+				log.Printf("Failed to release quota for %s: %v", event.Path, err)
+			}
+		}
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		RespondWithJSON(w, item, http.StatusOK)
+	}
+}
+
+// TrashHandler is the handler for listing the /trash endpoint.
+// It responds with the items in the user's trash, most recently deleted first.
+func TrashHandler(bin *trash.Trash) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := bin.List(sessionUser(r))
+		if err != nil {
+			message, code := trashError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+		RespondWithJSON(w, trashResponse{Items: items}, http.StatusOK)
+	}
+}
+
+// RestoreHandler is the handler for the /trash/{id}/restore endpoint.
+// It moves the item back to where it was deleted from. If something is there now, the
+// conflict query parameter selects whether to fail with a 409, the default, restore it
+// under a free name, or move the existing entry to the trash in its place. It responds with
+// the trash.Item, with the path it was restored to. Restored files are charged to the user's
+// quota, if quotas is not nil, and are not restored if they would take the user over it.
+func RestoreHandler(rootDir string, bin *trash.Trash, quotas *quota.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conflict := trash.Conflict(r.URL.Query().Get("conflict"))
+		if conflict == "" {
+			conflict = trash.ConflictFail
+		}
+
+		event := audit.Event{Action: audit.ActionRestore}
+		item, err := restoreItem(rootDir, bin, quotas, sessionUser(r), r.PathValue("id"), conflict)
+		event.Path = item.Path
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			message, code := trashError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		RespondWithJSON(w, item, http.StatusOK)
+	}
+}
+
+// restoreItem restores the item with id in user's trash, charging its files to user if quotas
+// is not nil and they would not take user over their hard limit.
+func restoreItem(rootDir string, bin *trash.Trash, quotas *quota.Tracker, user, id string, conflict trash.Conflict) (trash.Item, error) {
+	if quotas == nil {
+		return bin.Restore(user, id, conflict)
+	}
+	size, err := bin.Size(user, id)
+	if err != nil {
+		return trash.Item{}, err
+	}
+	var item trash.Item
+	err = quotas.Claim(user, size, func() (string, error) {
+		var err error
+		item, err = bin.Restore(user, id, conflict)
+		return filepath.Join(rootDir, filepath.FromSlash(item.Path)), err
+	})
+	return item, err
+}
+
+// PurgeHandler is the handler for deleting from the /trash endpoint.
+// It permanently removes the item with the id path value, or every item in the user's trash
+// if there is none.
+func PurgeHandler(bin *trash.Trash) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := sessionUser(r)
+		id := r.PathValue("id")
+
+		var err error
+		if id != "" {
+			err = bin.Purge(user, id)
+		} else {
+			_, err = bin.Empty(user)
+		}
+
+		event := audit.Event{Action: audit.ActionPurge, Outcome: audit.OutcomeSuccess, Detail: id}
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			message, code := trashError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+		audit.Record(r, event)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sessionUser returns the name of the authenticated user.
+func sessionUser(r *http.Request) string {
+	session, _ := r.Context().Value(auth.SessionContextKey).(*auth.Session)
+	if session == nil {
+		return ""
+	}
+	return session.Username
+}
+
+// trashError returns the message and status code for an error from the trash.
+func trashError(err error) (string, int) {
+	switch {
+	case errors.Is(err, trash.ErrNotFound):
+		return trash.ErrNotFound.Error(), http.StatusNotFound
+	case errors.Is(err, trash.ErrConflict):
+		return trash.ErrConflict.Error(), http.StatusConflict
+	case errors.Is(err, trash.ErrInvalidConflict):
+		return trash.ErrInvalidConflict.Error(), http.StatusBadRequest
+	case errors.Is(err, quota.ErrExceeded):
+		return err.Error(), http.StatusInsufficientStorage
+	case errors.Is(err, trash.ErrOutsideRoot):
+		return ErrInvalidPath.Error(), http.StatusBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		return ErrFileNotFound.Error(), http.StatusBadRequest
+	default:
+		log.Printf("Trash error: %v", err)
+		return ErrTrash.Error(), http.StatusInternalServerError
+	}
+}
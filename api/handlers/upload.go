@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/quota"
+	"github.com/goteleport-interview/fs4/api/versions"
+)
+
+var (
+	// ErrUpload is returned when an upload cannot be written.
+	ErrUpload = errors.New("failed to write upload")
+	// ErrUploadTooLarge is returned when an upload is larger than the configured maximum.
+	ErrUploadTooLarge = errors.New("upload too large")
+)
+
+// QuotaWarningHeader is set on responses to uploads that took the user over their soft quota.
+const QuotaWarningHeader = "X-Quota-Warning"
+
+// UploadHandler is the handler for uploading to the /files endpoint.
+// It writes the request body to the file named by the path query parameter, in an existing
+// directory. The file only appears once the upload is complete, and replaces any existing
+// file, whose content is kept as a version in history if it is not nil. If quotas is not nil,
+// the upload is charged to the user, and rejected if it would take them over their hard
+// quota. It responds with 201 for a new file, or 200 if one was replaced.
+func UploadHandler(rootDir string, history *versions.Store, quotas *quota.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := uploadPath(rootDir, r.URL.Query().Get("path"))
+		if err != nil {
+			RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		event := audit.Event{Action: audit.ActionUpload, Path: relPath(rootDir, path)}
+		user := sessionUser(r)
+		var body io.Reader = r.Body
+		if quotas != nil {
+			// fail early rather than after reading the whole body, and stop bodies of unknown
+			// length once they go over the quota rather than once written in full
+			if err := quotas.Check(user, path, max(r.ContentLength, 0)); err != nil {
+				failUpload(w, r, event, err)
+				return
+			}
+			body = quotas.Limit(user, path, r.Body)
+		}
+		info, replaced, err := writeUpload(rootDir, path, body, history, chargeUpload(w, quotas, user, path))
+		if err != nil {
+			failUpload(w, r, event, err)
+			return
+		}
+
+		code := http.StatusCreated
+		if replaced {
+			code = http.StatusOK
+			event.Detail = "replaced"
+		}
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		RespondWithJSON(w, filesResponse{Name: info.Name(), Modified: info.ModTime(), Type: "file", Size: info.Size()}, code)
+	}
+}
+
+// failUpload records event as failed with err, and responds with the error.
+func failUpload(w http.ResponseWriter, r *http.Request, event audit.Event, err error) {
+	event.Outcome = audit.OutcomeFailure
+	event.Detail = err.Error()
+	audit.Record(r, event)
+	message, code := uploadError(err)
+	RespondWithError(w, message, code)
+}
+
+// uploadPath resolves the requested path to a file in an existing directory under rootDir.
+func uploadPath(rootDir, requested string) (string, error) {
+	path, err := cleanPath(rootDir, requested)
+	if err != nil || path == filepath.Clean(rootDir) {
+		return "", ErrInvalidPath
+	}
+	if _, member, ok := splitArchivePath(rootDir, path); ok && member != "" {
+		return "", ErrInvalidPath
+	}
+	if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() || !resolvesWithin(rootDir, filepath.Dir(path)) {
+		return "", ErrDirNotFound
+	}
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		return "", ErrNotFile
+	}
+	return path, nil
+}
+
+// uploadCommit makes a complete upload of size bytes appear at its path by calling move.
+type uploadCommit func(size int64, move func() error) error
+
+// chargeUpload returns the uploadCommit charging an upload to path to user, if quotas is not
+// nil, and warning in the response if it takes them over their soft quota.
+func chargeUpload(w http.ResponseWriter, quotas *quota.Tracker, user, path string) uploadCommit {
+	if quotas == nil {
+		return func(_ int64, move func() error) error {
+			return move()
+		}
+	}
+	return func(size int64, move func() error) error {
+		usage, err := quotas.Write(user, path, size, move)
+		if err == nil && usage.OverSoft {
+			w.Header().Set(QuotaWarningHeader, fmt.Sprintf("soft storage quota exceeded, using %d of %d bytes", usage.Used, usage.Soft))
+		}
+		return err
+	}
+}
+
+// writeUpload writes body to a temporary file under the StateDir, hidden from listings until
+// it is complete, and then moves it to path through commit. It returns the written file's
+// info, and whether it replaced an existing file.
+func writeUpload(rootDir, path string, body io.Reader, history *versions.Store, commit uploadCommit) (fs.FileInfo, bool, error) {
+	tmpDir := filepath.Join(rootDir, StateDir, "uploads")
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return nil, false, err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return nil, false, err
+	}
+	// nolint:errcheck
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// a replaced file keeps its permissions
+	mode := fs.FileMode(0644)
+	existing, err := os.Lstat(path)
+	if err == nil {
+		mode = existing.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return nil, false, err
+	}
+
+	err = commit(size, func() error {
+		if history != nil {
+			_, err := history.Replace(path, tmp.Name())
+			return err
+		}
+		return os.Rename(tmp.Name(), path)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := os.Stat(path)
+	return info, existing != nil, err
+}
+
+// uploadError returns the message and status code for an error writing an upload.
+func uploadError(err error) (string, int) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return ErrUploadTooLarge.Error(), http.StatusRequestEntityTooLarge
+	case errors.Is(err, quota.ErrExceeded):
+		return err.Error(), http.StatusInsufficientStorage
+	case errors.Is(err, versions.ErrNotFile):
+		return ErrNotFile.Error(), http.StatusBadRequest
+	default:
+		log.Printf("Failed to write upload: %v", err)
+		return ErrUpload.Error(), http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/versions"
+)
+
+// ErrVersions is returned when the versions of a file cannot be read or restored.
+var ErrVersions = errors.New("failed to read versions")
+
+type versionsResponse struct {
+	Versions []versions.Version `json:"versions"`
+}
+
+// VersionsHandler is the handler for the /versions endpoint.
+// It responds with the previous versions of the file named by the path query parameter,
+// most recently replaced first.
+func VersionsHandler(rootDir string, history *versions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := cleanPath(rootDir, r.URL.Query().Get("path"))
+		if err != nil {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+
+		list, err := history.List(path)
+		if err != nil {
+			message, code := versionError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+		RespondWithJSON(w, versionsResponse{Versions: list}, http.StatusOK)
+	}
+}
+
+// VersionDownloadHandler is the handler for the /versions/{id} endpoint.
+// It serves the version with the id path value of the file named by the path query parameter
+// as an attachment, named like the file.
+func VersionDownloadHandler(rootDir string, history *versions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := cleanPath(rootDir, r.URL.Query().Get("path"))
+		if err != nil {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id := r.PathValue("id")
+		event := audit.Event{Action: audit.ActionDownload, Path: relPath(rootDir, path), Detail: "version " + id}
+		f, version, err := history.Open(path, id)
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			message, code := versionError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+		defer closeFile(f)
+
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		setAttachment(w, filepath.Base(path))
+		http.ServeContent(w, r, filepath.Base(path), version.Modified, f)
+	}
+}
+
+// VersionRestoreHandler is the handler for the /versions/{id}/restore endpoint.
+// It makes the version with the id path value the current content of the file named by the
+// path query parameter, keeping the content it replaces as a version, and responds with the
+// restored version.
+func VersionRestoreHandler(rootDir string, history *versions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := cleanPath(rootDir, r.URL.Query().Get("path"))
+		if err != nil || !resolvesWithin(rootDir, filepath.Dir(path)) {
+			RespondWithError(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id := r.PathValue("id")
+		event := audit.Event{Action: audit.ActionRestore, Path: relPath(rootDir, path), Detail: "version " + id}
+		version, err := history.Restore(path, id)
+		if err != nil {
+			event.Outcome = audit.OutcomeFailure
+			event.Detail = err.Error()
+			audit.Record(r, event)
+			message, code := versionError(err)
+			RespondWithError(w, message, code)
+			return
+		}
+
+		event.Outcome = audit.OutcomeSuccess
+		audit.Record(r, event)
+		RespondWithJSON(w, version, http.StatusOK)
+	}
+}
+
+// versionError returns the message and status code for an error from the version store.
+func versionError(err error) (string, int) {
+	switch {
+	case errors.Is(err, versions.ErrNotFound):
+		return versions.ErrNotFound.Error(), http.StatusNotFound
+	case errors.Is(err, versions.ErrNotFile):
+		return ErrNotFile.Error(), http.StatusBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		// the directory the file was in is gone
+		return ErrDirNotFound.Error(), http.StatusBadRequest
+	default:
+		log.Printf("Versions error: %v", err)
+		return ErrVersions.Error(), http.StatusInternalServerError
+	}
+}
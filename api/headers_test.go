@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend())
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+		recorder := httptest.NewRecorder()
+		s.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		expected := map[string]string{
+			"Strict-Transport-Security": "max-age=31536000",
+			"Content-Security-Policy":   DefaultContentSecurityPolicy + "; frame-ancestors 'none'",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           "no-referrer",
+		}
+		for name, value := range expected {
+			if got := recorder.Header().Get(name); got != value {
+				t.Errorf("expected %s '%s', got '%s'", name, value, got)
+			}
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend(), WithSecurityHeaders(SecurityHeaders{
+			HSTSMaxAge:            time.Hour,
+			HSTSIncludeSubdomains: true,
+			FrameAncestors:        []string{"'self'", "https://portal.example.com"},
+		}))
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+		recorder := httptest.NewRecorder()
+		s.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/unknown", nil))
+
+		if got := recorder.Header().Get("Strict-Transport-Security"); got != "max-age=3600; includeSubDomains" {
+			t.Errorf("unexpected HSTS header '%s'", got)
+		}
+		if got := recorder.Header().Get("Content-Security-Policy"); got != "frame-ancestors 'self' https://portal.example.com" {
+			t.Errorf("unexpected CSP header '%s'", got)
+		}
+		for _, name := range []string{"X-Frame-Options", "Referrer-Policy"} {
+			if got := recorder.Header().Get(name); got != "" {
+				t.Errorf("expected no %s header, got '%s'", name, got)
+			}
+		}
+		if got := recorder.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("expected nosniff to always be set, got '%s'", got)
+		}
+	})
+}
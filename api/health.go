@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthCheckTimeout bounds how long any single check may take.
+const healthCheckTimeout = 2 * time.Second
+
+// CertExpiryWarning is how close to expiry a certificate must be before the tls check reports a warning.
+const CertExpiryWarning = 14 * 24 * time.Hour
+
+// checkStatus is the result of a single health check.
+type checkStatus string
+
+const (
+	checkOK   checkStatus = "ok"
+	checkWarn checkStatus = "warn"
+	checkFail checkStatus = "fail"
+)
+
+type checkResult struct {
+	Status checkStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+type healthResponse struct {
+	Status checkStatus            `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+var errDraining = errors.New("server is shutting down")
+
+// Drain marks the server as not ready, so that /readyz fails and load balancers
+// stop routing new traffic to it. Requests continue to be served.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// healthHandler reports the state of the serving root, auth backend and TLS certificate.
+// If readiness is set, the server being drained is also reported as a failure.
+func (s *Server) healthHandler(readiness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		resp := healthResponse{
+			Status: checkOK,
+			Checks: map[string]checkResult{
+				"root": s.checkRoot(),
+				"auth": s.checkAuth(ctx),
+				"tls":  s.checkTLS(time.Now()),
+			},
+		}
+		if readiness {
+			resp.Checks["draining"] = checkResult{Status: checkOK}
+			if s.draining.Load() {
+				resp.Checks["draining"] = checkResult{Status: checkFail, Detail: errDraining.Error()}
+			}
+		}
+
+		code := http.StatusOK
+		for _, c := range resp.Checks {
+			if c.Status == checkFail {
+				resp.Status = checkFail
+				code = http.StatusServiceUnavailable
+				break
+			}
+			if c.Status == checkWarn {
+				resp.Status = checkWarn
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("Failed to send health response: %v", err)
+		}
+	}
+}
+
+func (s *Server) checkRoot() checkResult {
+	info, err := os.Stat(s.baseDir)
+	if err != nil {
+		return checkResult{Status: checkFail, Detail: err.Error()}
+	}
+	if !info.IsDir() {
+		return checkResult{Status: checkFail, Detail: "serving root is not a directory"}
+	}
+
+	dir, err := os.Open(s.baseDir)
+	if err != nil {
+		return checkResult{Status: checkFail, Detail: err.Error()}
+	}
+	// nolint:errcheck
+	defer dir.Close()
+	if _, err := dir.ReadDir(1); err != nil && !errors.Is(err, io.EOF) {
+		return checkResult{Status: checkFail, Detail: err.Error()}
+	}
+
+	return checkResult{Status: checkOK}
+}
+
+func (s *Server) checkAuth(ctx context.Context) checkResult {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.authBackend.Ping()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return checkResult{Status: checkFail, Detail: err.Error()}
+		}
+		return checkResult{Status: checkOK}
+	case <-ctx.Done():
+		return checkResult{Status: checkFail, Detail: "auth backend did not respond"}
+	}
+}
+
+// currentCertificate is implemented by certificate sources serving a single certificate,
+// such as *tlscert.Manager, allowing its expiry to be checked.
+type currentCertificate interface {
+	Certificate() *tls.Certificate
+}
+
+func (s *Server) checkTLS(now time.Time) checkResult {
+	certs := s.certs.Load()
+	if certs == nil {
+		return checkResult{Status: checkOK, Detail: "no certificate loaded"}
+	}
+	current, ok := (*certs).(currentCertificate)
+	if !ok {
+		return checkResult{Status: checkOK, Detail: "certificates are managed by the certificate source"}
+	}
+	cert := current.Certificate()
+	if cert == nil {
+		return checkResult{Status: checkFail, Detail: "no certificate loaded"}
+	}
+
+	leaf, err := leafCertificate(cert)
+	if err != nil {
+		return checkResult{Status: checkFail, Detail: err.Error()}
+	}
+
+	expiry := leaf.NotAfter.UTC().Format(time.RFC3339)
+	remaining := leaf.NotAfter.Sub(now)
+	switch {
+	case remaining <= 0:
+		return checkResult{Status: checkFail, Detail: fmt.Sprintf("certificate expired %s", expiry)}
+	case remaining < CertExpiryWarning:
+		return checkResult{Status: checkWarn, Detail: fmt.Sprintf("certificate expires soon, %s", expiry)}
+	default:
+		return checkResult{Status: checkOK, Detail: fmt.Sprintf("certificate expires %s", expiry)}
+	}
+}
+
+func leafCertificate(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
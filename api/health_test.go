@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+)
+
+func testCertificate(t *testing.T, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// staticCertificate is a CertificateSource serving a single fixed certificate.
+type staticCertificate struct {
+	cert *tls.Certificate
+}
+
+func (s staticCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert, nil
+}
+
+func (s staticCertificate) Certificate() *tls.Certificate {
+	return s.cert
+}
+
+func storeCertificate(s *Server, cert *tls.Certificate) {
+	var source CertificateSource = staticCertificate{cert: cert}
+	s.certs.Store(&source)
+}
+
+func probe(t *testing.T, s *Server, path string) (int, healthResponse) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var resp healthResponse
+	if err := json.NewDecoder(recorder.Result().Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return recorder.Code, resp
+}
+
+func TestHealthHandler(t *testing.T) {
+	rootDir := filepath.Join(t.TempDir(), "files")
+	if err := os.Mkdir(rootDir, 0755); err != nil {
+		t.Fatalf("failed to create root dir: %v", err)
+	}
+
+	s, err := NewServer(testAssets, rootDir, auth.NewInMemoryBackend())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		storeCertificate(s, testCertificate(t, time.Now().Add(90*24*time.Hour)))
+		for _, path := range []string{"/healthz", "/readyz"} {
+			code, resp := probe(t, s, path)
+			if code != http.StatusOK {
+				t.Fatalf("%s: expected status OK, got %d (%+v)", path, code, resp)
+			}
+			if resp.Status != checkOK {
+				t.Errorf("%s: expected status 'ok', got '%s'", path, resp.Status)
+			}
+		}
+	})
+
+	t.Run("certificate near expiry", func(t *testing.T) {
+		storeCertificate(s, testCertificate(t, time.Now().Add(24*time.Hour)))
+		code, resp := probe(t, s, "/healthz")
+		if code != http.StatusOK {
+			t.Fatalf("expected status OK, got %d", code)
+		}
+		if resp.Checks["tls"].Status != checkWarn {
+			t.Errorf("expected tls check 'warn', got '%s'", resp.Checks["tls"].Status)
+		}
+	})
+
+	t.Run("certificate expired", func(t *testing.T) {
+		storeCertificate(s, testCertificate(t, time.Now().Add(-time.Hour)))
+		code, resp := probe(t, s, "/healthz")
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status ServiceUnavailable, got %d", code)
+		}
+		if resp.Checks["tls"].Status != checkFail {
+			t.Errorf("expected tls check 'fail', got '%s'", resp.Checks["tls"].Status)
+		}
+		s.certs.Store(nil)
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		s.Drain()
+		defer s.draining.Store(false)
+
+		if code, _ := probe(t, s, "/healthz"); code != http.StatusOK {
+			t.Errorf("expected /healthz status OK while draining, got %d", code)
+		}
+		code, resp := probe(t, s, "/readyz")
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected /readyz status ServiceUnavailable while draining, got %d", code)
+		}
+		if resp.Checks["draining"].Status != checkFail {
+			t.Errorf("expected draining check 'fail', got '%s'", resp.Checks["draining"].Status)
+		}
+	})
+
+	t.Run("root removed", func(t *testing.T) {
+		if err := os.Remove(rootDir); err != nil {
+			t.Fatalf("failed to remove root dir: %v", err)
+		}
+		code, resp := probe(t, s, "/readyz")
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status ServiceUnavailable, got %d", code)
+		}
+		if resp.Checks["root"].Status != checkFail {
+			t.Errorf("expected root check 'fail', got '%s'", resp.Checks["root"].Status)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// ListenAndServeHTTP3 serves HTTP/3 on the specified UDP address, using the same handler and
+// TLS settings as ListenAndServeTLS and serving certificates from certs. Once it is listening,
+// responses from the TCP listener advertise it in an Alt-Svc header so that clients can switch.
+// It returns http.ErrServerClosed once Shutdown has been called.
+func (s *Server) ListenAndServeHTTP3(addr string, certs CertificateSource) error {
+	server := &http3.Server{
+		Handler:        s.handler,
+		TLSConfig:      http3.ConfigureTLSConfig(s.tlsConfig(certs)),
+		MaxHeaderBytes: s.limits.MaxHeaderBytes,
+		IdleTimeout:    s.limits.IdleTimeout,
+		Logger:         s.logger,
+	}
+
+	return s.listeners.serve(server, func() error {
+		// http3.Server.ListenAndServe may race with Shutdown, so open the socket ourselves
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		// nolint:errcheck
+		defer conn.Close()
+
+		s.http3.Store(server)
+		defer s.http3.Store(nil)
+		return server.Serve(conn)
+	})
+}
+
+// advertiseHTTP3 is middleware announcing the HTTP/3 listener, if running, to clients of the TCP listener.
+func (s *Server) advertiseHTTP3(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if server := s.http3.Load(); server != nil && r.ProtoMajor < 3 {
+			// fails only if the listener is not yet set up, in which case there is nothing to announce
+			_ = server.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
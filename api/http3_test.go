@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+)
+
+func TestHTTP3(t *testing.T) {
+	s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	// reserve a free UDP port
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	addr := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	certs := staticCertificate{cert: testCertificate(t, time.Now().Add(time.Hour))}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.ListenAndServeHTTP3(addr, certs)
+	}()
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	// nolint:errcheck
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: time.Second}
+
+	var resp *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = client.Get("https://" + addr + "/healthz")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("HTTP/3 request failed: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	_ = resp.Body.Close()
+	if resp.ProtoMajor != 3 {
+		t.Fatalf("expected HTTP/3 response, got %s", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK, got %v", resp.Status)
+	}
+
+	// the TCP listener advertises HTTP/3
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	_, port, _ := net.SplitHostPort(addr)
+	if got, expected := recorder.Header().Get("Alt-Svc"), `h3=":`+port+`"`; !strings.HasPrefix(got, expected) {
+		t.Errorf("expected Alt-Svc to advertise %s, got '%s'", expected, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed from listener, got %v", err)
+	}
+
+	recorder = httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if got := recorder.Header().Get("Alt-Svc"); got != "" {
+		t.Errorf("expected no Alt-Svc after shutdown, got '%s'", got)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ListenUnix listens on a Unix domain socket at path and sets its file mode, so that access
+// can be limited to e.g. the group of a local reverse proxy. A stale socket left behind by a
+// previous run is replaced, but a socket with a live server behind it is not.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("could not set socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+// systemdFirstFD is the first file descriptor passed by systemd socket activation.
+const systemdFirstFD = 3
+
+// SystemdListeners returns the sockets passed to the process by systemd socket activation,
+// in the order they are configured in the socket unit, or nil if there are none.
+// The activation environment variables are cleared so that child processes do not inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		// the sockets were meant for another process
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := systemdFirstFD; fd < systemdFirstFD+n; fd++ {
+		f := os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		// FileListener duplicates the descriptor
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("could not use socket %d from systemd: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Serve serves on l until Shutdown is called, using TLS with certificates from certs or,
+// if certs is nil, plain HTTP. Plain HTTP should only be used behind a trusted proxy that
+// terminates TLS, see WithTrustedProxies.
+// It returns http.ErrServerClosed once Shutdown has been called.
+func (s *Server) Serve(l net.Listener, certs CertificateSource) error {
+	server := &http.Server{
+		Handler:           s.handler,
+		MaxHeaderBytes:    s.limits.MaxHeaderBytes,
+		ReadHeaderTimeout: s.limits.ReadHeaderTimeout,
+		IdleTimeout:       s.limits.IdleTimeout,
+	}
+
+	err := s.listeners.serve(server, func() error {
+		if certs == nil {
+			return server.Serve(l)
+		}
+
+		s.certs.Store(&certs)
+		server.TLSConfig = s.tlsConfig(certs)
+		server.TLSConfig.NextProtos = append([]string{"h2", "http/1.1"}, s.tls.NextProtos...)
+		return server.ServeTLS(l, "", "")
+	})
+	if errors.Is(err, http.ErrServerClosed) {
+		// not closed by the http.Server if it was never started
+		_ = l.Close()
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+)
+
+// socketDir returns a short temporary directory, as Unix socket paths are limited to about 100 bytes.
+func socketDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "fs4")
+	if err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := socketDir(t)
+	path := filepath.Join(dir, "fs4.sock")
+
+	l, err := ListenUnix(path, 0660)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat socket: %v", err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("expected mode 0660, got %v", info.Mode().Perm())
+	}
+
+	if _, err := ListenUnix(path, 0660); err == nil {
+		t.Error("expected error listening on a socket in use")
+	}
+
+	// simulate a crashed server leaving its socket behind
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	_ = l.Close()
+	l, err = ListenUnix(path, 0600)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	_ = l.Close()
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if _, err := ListenUnix(file, 0660); err == nil {
+		t.Error("expected error listening on a regular file")
+	}
+}
+
+func TestServePlaintextUnix(t *testing.T) {
+	s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	path := filepath.Join(socketDir(t), "fs4.sock")
+	l, err := ListenUnix(path, 0600)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(l, nil)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://fs4/healthz")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/goteleport-interview/fs4/api/handlers"
+)
+
+// validRequestID limits client-supplied request IDs to a safe length and character set.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// responseRecorder captures the status code and number of bytes written by a handler.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.status == 0 {
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// Flush implements http.Flusher for streaming responses.
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logRequests is middleware that assigns each request an ID and writes an access log entry once it completes.
+// Route patterns are resolved against mux so that log entries can be grouped by route.
+func logRequests(next http.Handler, mux *http.ServeMux, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(handlers.RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(handlers.RequestIDHeader, requestID)
+
+		info := &handlers.RequestInfo{ID: requestID}
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(handlers.WithRequestInfo(r.Context(), info)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		_, route := mux.Handler(r)
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", requestID),
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", loggedPath(r)),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("user", info.User),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// loggedPath returns the path of r for the access log, with any share token left out, as
+// anyone who has it can use the share.
+func loggedPath(r *http.Request) string {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/s/")
+	if !ok {
+		return r.URL.Path
+	}
+	if _, after, found := strings.Cut(rest, "/"); found {
+		return "/s/{token}/" + after
+	}
+	return "/s/{token}"
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/handlers"
+)
+
+var testAssets = fstest.MapFS{
+	"index.html": &fstest.MapFile{Data: []byte("<html></html>")},
+}
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	t.Run("generated request id", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/me", nil)
+		recorder := httptest.NewRecorder()
+
+		s.handler.ServeHTTP(recorder, req)
+
+		resp := recorder.Result()
+		requestID := resp.Header.Get(handlers.RequestIDHeader)
+		if requestID == "" {
+			t.Fatal("expected request ID header to be set")
+		}
+
+		var apiResp handlers.APIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if apiResp.Error == nil || apiResp.Error.RequestID != requestID {
+			t.Errorf("expected error to include request ID '%s', got %+v", requestID, apiResp.Error)
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to decode log entry: %v", err)
+		}
+		if entry["request_id"] != requestID {
+			t.Errorf("expected logged request ID '%s', got '%v'", requestID, entry["request_id"])
+		}
+		if entry["route"] != "GET /api/v1/auth/me" {
+			t.Errorf("expected route 'GET /api/v1/auth/me', got '%v'", entry["route"])
+		}
+		if entry["status"] != float64(http.StatusUnauthorized) {
+			t.Errorf("expected status 401, got '%v'", entry["status"])
+		}
+	})
+
+	t.Run("client request id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(handlers.RequestIDHeader, "abc-123")
+		recorder := httptest.NewRecorder()
+
+		s.handler.ServeHTTP(recorder, req)
+
+		if got := recorder.Result().Header.Get(handlers.RequestIDHeader); got != "abc-123" {
+			t.Errorf("expected request ID 'abc-123', got '%s'", got)
+		}
+	})
+
+	t.Run("invalid client request id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(handlers.RequestIDHeader, "bad id\nwith newline")
+		recorder := httptest.NewRecorder()
+
+		s.handler.ServeHTTP(recorder, req)
+
+		if got := recorder.Result().Header.Get(handlers.RequestIDHeader); got == "bad id\nwith newline" {
+			t.Error("expected invalid request ID to be replaced")
+		}
+	})
+
+	t.Run("share token left out", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/s/secret-token/download", nil)
+		recorder := httptest.NewRecorder()
+
+		s.handler.ServeHTTP(recorder, req)
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to decode log entry: %v", err)
+		}
+		if entry["path"] != "/s/{token}/download" {
+			t.Errorf("expected path '/s/{token}/download', got '%v'", entry["path"])
+		}
+	})
+}
@@ -0,0 +1,66 @@
+// Package metadata reads details of files from their contents, for listings: the MIME type,
+// the dimensions and date taken of images, and the page count of PDFs. Only as much of a
+// file as is needed is read, and never more than a fixed amount.
+package metadata
+
+import (
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// registered with image.DecodeConfig
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/goteleport-interview/fs4/api/exif"
+)
+
+// SniffLen is the number of bytes at the start of a file MIMEType needs.
+const SniffLen = 512
+
+// MIMEType returns the MIME type of the file named name whose contents start with head.
+// Content sniffing cannot tell text formats apart, so for those the extension is used.
+func MIMEType(name string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if !strings.HasPrefix(sniffed, "text/plain") && sniffed != "application/octet-stream" {
+		return sniffed
+	}
+	if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+		return byExtension
+	}
+	return sniffed
+}
+
+// Image describes an image.
+type Image struct {
+	Width  int
+	Height int
+	// Taken is when a photo was taken according to its EXIF data, zero if unknown. It is in
+	// the camera's local time, but has a UTC location.
+	Taken time.Time
+}
+
+// ReadImage reads the dimensions of a JPEG, PNG, GIF or WebP image from the headers of r,
+// and for JPEGs the date taken. ok is false if r is not an image in one of those formats.
+func ReadImage(r io.ReadSeeker) (img Image, ok bool) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return Image{}, false
+	}
+	img = Image{Width: config.Width, Height: config.Height}
+	if format != "jpeg" {
+		return img, true
+	}
+	if _, err := r.Seek(0, io.SeekStart); err == nil {
+		tags, _ := exif.Read(r)
+		img.Taken = tags.Taken
+	}
+	return img, true
+}
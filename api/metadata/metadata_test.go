@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMIMEType(t *testing.T) {
+	for _, tc := range []struct {
+		name, head, expected string
+	}{
+		{"photo.dat", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"report", "%PDF-1.7", "application/pdf"},
+		{"notes.txt", "hello", "text/plain; charset=utf-8"},
+		{"style.css", "body {}", "text/css; charset=utf-8"},
+		{"data.json", "{}", "application/json"},
+		{"blob", "\x00\x01\x02", "application/octet-stream"},
+	} {
+		if got := MIMEType(tc.name, []byte(tc.head)); got != tc.expected {
+			t.Errorf("expected %s for %s, got %s", tc.expected, tc.name, got)
+		}
+	}
+}
+
+// withExifDate inserts an APP1 segment with a DateTime tag after the start of a JPEG.
+func withExifDate(jpegData []byte, date string) []byte {
+	order := binary.LittleEndian
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, 0x0132) // DateTime
+	tiff = order.AppendUint16(tiff, 2)      // ASCII
+	tiff = order.AppendUint32(tiff, uint32(len(date)+1))
+	tiff = order.AppendUint32(tiff, 8+2+12+4)
+	tiff = order.AppendUint32(tiff, 0)
+	tiff = append(append(tiff, date...), 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+	return append(append([]byte{0xFF, 0xD8}, segment...), jpegData[2:]...)
+}
+
+func TestReadImage(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	var jpegData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, image.NewGray(image.Rect(0, 0, 40, 10)), nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expected Image
+		ok       bool
+	}{
+		{"png", pngData.Bytes(), Image{Width: 30, Height: 20}, true},
+		{"jpeg", jpegData.Bytes(), Image{Width: 40, Height: 10}, true},
+		{
+			"jpeg with exif",
+			withExifDate(jpegData.Bytes(), "2021:12:25 08:15:00"),
+			Image{Width: 40, Height: 10, Taken: time.Date(2021, 12, 25, 8, 15, 0, 0, time.UTC)},
+			true,
+		},
+		{"text", []byte("not an image"), Image{}, false},
+	} {
+		img, ok := ReadImage(bytes.NewReader(tc.data))
+		if ok != tc.ok || img != tc.expected {
+			t.Errorf("%s: expected %+v (%v), got %+v (%v)", tc.name, tc.expected, tc.ok, img, ok)
+		}
+	}
+}
+
+// objectStream returns a compressed object stream holding objects.
+func objectStream(t *testing.T, objects string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write([]byte(objects)); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	return fmt.Sprintf("9 0 obj\n<< /Type /ObjStm /N 1 /First 5 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream\nendobj\n", buf.Len(), buf.String())
+}
+
+func TestPDFPages(t *testing.T) {
+	tree := strings.Join([]string{
+		"%PDF-1.4",
+		"1 0 obj << /Type /Catalog /Pages 2 0 R /Outlines 8 0 R >> endobj",
+		"2 0 obj << /Type /Pages /Kids [3 0 R 4 0 R] /Count 3 >> endobj",
+		"3 0 obj << /Type /Pages /Parent 2 0 R /Kids [5 0 R 6 0 R] /Count 2 >> endobj",
+		"4 0 obj << /Type /Page /Parent 2 0 R /Resources << /Font << /F1 7 0 R >> >> >> endobj",
+		"8 0 obj << /Type /Outlines /Count 50 >> endobj",
+		"%%EOF",
+	}, "\n")
+
+	compressed := "%PDF-1.5\n" + objectStream(t, "2 0 << /Type /Pages /Kids [3 0 R] /Count 12 >>") + "%%EOF"
+
+	for _, tc := range []struct {
+		name     string
+		data     string
+		expected int
+		ok       bool
+	}{
+		{"page tree", tree, 3, true},
+		{"object stream", compressed, 12, true},
+		{"no page tree", "%PDF-1.4\n%%EOF", 0, false},
+		{"not a pdf", "hello /Type /Pages /Count 3", 0, false},
+	} {
+		pages, ok := PDFPages(strings.NewReader(tc.data))
+		if pages != tc.expected || ok != tc.ok {
+			t.Errorf("%s: expected %d pages (%v), got %d (%v)", tc.name, tc.expected, tc.ok, pages, ok)
+		}
+	}
+}
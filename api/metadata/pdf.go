@@ -0,0 +1,148 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// MaxPDFScan is the number of bytes of a PDF read to find its page count.
+	MaxPDFScan = 16 << 20
+	// maxObjectStream is the decompressed size read from each compressed object stream.
+	maxObjectStream = 4 << 20
+)
+
+var (
+	pdfHeader = []byte("%PDF-")
+	// pagesType marks a node of the page tree, whose Count is the number of pages below it.
+	pagesType   = regexp.MustCompile(`/Type\s*/Pages\b`)
+	pagesCount  = regexp.MustCompile(`/Count\s+(\d+)`)
+	objStmType  = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	streamStart = regexp.MustCompile(`stream\r?\n`)
+)
+
+// PDFPages returns the number of pages of the PDF read from r, reading at most MaxPDFScan
+// bytes. ok is false if r is not a PDF or the page count cannot be found.
+//
+// The count is that of the root of the page tree, the page tree node with the most pages.
+// Nodes are found in the file and in compressed object streams, where PDF 1.5 and later
+// usually keep them; other encodings and encrypted PDFs are not supported.
+func PDFPages(r io.Reader) (pages int, ok bool) {
+	// checked first, so that other files are not read any further
+	br := bufio.NewReader(io.LimitReader(r, MaxPDFScan))
+	if header, err := br.Peek(len(pdfHeader)); err != nil || !bytes.Equal(header, pdfHeader) {
+		return 0, false
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return 0, false
+	}
+
+	pages = maxPageCount(data)
+	for _, stream := range objectStreams(data) {
+		pages = max(pages, maxPageCount(stream))
+	}
+	return pages, pages > 0
+}
+
+// maxPageCount returns the largest Count of the page tree nodes in data.
+func maxPageCount(data []byte) int {
+	pages := 0
+	for _, loc := range pagesType.FindAllIndex(data, -1) {
+		dict, _ := enclosingDict(data, loc[0])
+		if m := pagesCount.FindSubmatch(dict); m != nil {
+			if n, err := strconv.Atoi(string(m[1])); err == nil {
+				pages = max(pages, n)
+			}
+		}
+	}
+	return pages
+}
+
+// enclosingDict returns the dictionary, delimited by << and >>, enclosing offset in data,
+// and the offset of its end.
+func enclosingDict(data []byte, offset int) ([]byte, int) {
+	start := dictStart(data, offset)
+	if start < 0 {
+		return nil, -1
+	}
+	end := dictEnd(data, offset)
+	if end < 0 {
+		return nil, -1
+	}
+	return data[start:end], end
+}
+
+// dictStart returns the offset of the << opening the dictionary enclosing offset, or -1.
+func dictStart(data []byte, offset int) int {
+	depth := 0
+	for i := offset - 1; i > 0; i-- {
+		switch {
+		case data[i-1] == '<' && data[i] == '<':
+			if depth == 0 {
+				return i - 1
+			}
+			depth--
+			i--
+		case data[i-1] == '>' && data[i] == '>':
+			depth++
+			i--
+		}
+	}
+	return -1
+}
+
+// dictEnd returns the offset after the >> closing the dictionary enclosing offset, or -1.
+func dictEnd(data []byte, offset int) int {
+	depth := 0
+	for i := offset; i+1 < len(data); i++ {
+		switch {
+		case data[i] == '<' && data[i+1] == '<':
+			depth++
+			i++
+		case data[i] == '>' && data[i+1] == '>':
+			if depth == 0 {
+				return i + 2
+			}
+			depth--
+			i++
+		}
+	}
+	return -1
+}
+
+// objectStreams returns the decompressed contents of the Flate encoded object streams in data.
+func objectStreams(data []byte) [][]byte {
+	var streams [][]byte
+	for _, loc := range objStmType.FindAllIndex(data, -1) {
+		dict, end := enclosingDict(data, loc[0])
+		if dict == nil || !bytes.Contains(dict, []byte("/FlateDecode")) {
+			continue
+		}
+		// the stream follows its dictionary
+		rest := data[end:]
+		if start := streamStart.FindIndex(rest); start != nil && start[0] < 16 {
+			if stream, ok := inflate(rest[start[1]:]); ok {
+				streams = append(streams, stream)
+			}
+		}
+	}
+	return streams
+}
+
+// inflate decompresses the zlib stream at the start of data, ignoring any data after it.
+func inflate(data []byte) ([]byte, bool) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	// nolint:errcheck
+	defer zr.Close()
+	stream, err := io.ReadAll(io.LimitReader(zr, maxObjectStream))
+	// a stream cut short by the scan limit still holds what was read
+	return stream, len(stream) > 0 || err == nil
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/metrics"
+)
+
+// recordMetrics is middleware that records request counts, latency and bytes served per route,
+// and makes m available to handlers for more specific observations.
+func recordMetrics(next http.Handler, mux *http.ServeMux, m *metrics.Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(metrics.NewContext(r.Context(), m)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		m.ObserveRequest(route, r.Method, rec.status, rec.bytes, time.Since(start))
+	})
+}
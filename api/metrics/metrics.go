@@ -0,0 +1,173 @@
+// Package metrics exposes Prometheus metrics for the fs4 server.
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "fs4"
+
+// SessionCounter reports the number of active sessions, typically the auth backend.
+type SessionCounter interface {
+	ActiveSessions() int
+}
+
+// Metrics holds the collectors for a single server.
+// Each instance has its own registry so multiple servers can coexist in one process.
+// A nil *Metrics discards all observations.
+type Metrics struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	bytesServed   *prometheus.CounterVec
+	loginFailures prometheus.Counter
+	shareFailures prometheus.Counter
+	listingSize   prometheus.Histogram
+}
+
+// New creates and registers the server metrics.
+func New(sessions SessionCounter) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		bytesServed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_response_bytes_total",
+			Help:      "Number of response body bytes served by route.",
+		}, []string{"route"}),
+		loginFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_failures_total",
+			Help:      "Number of failed login attempts.",
+		}),
+		shareFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "share_password_failures_total",
+			Help:      "Number of wrong share passwords, and of those refused while a share is locked.",
+		}),
+		listingSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "directory_listing_entries",
+			Help:      "Number of entries returned per directory listing.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.bytesServed,
+		m.loginFailures,
+		m.shareFailures,
+		m.listingSize,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_sessions",
+			Help:      "Number of unexpired sessions in the auth backend.",
+		}, func() float64 {
+			return float64(sessions.ActiveSessions())
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// methods are the request methods recorded as labels. Clients choose the method, so any
+// other is recorded as "other" rather than growing the number of series without bound.
+var methods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+	http.MethodPatch:   true,
+}
+
+// ObserveRequest records a completed HTTP request.
+func (m *Metrics) ObserveRequest(route, method string, status int, bytes int64, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	if !methods[method] {
+		method = "other"
+	}
+	m.requests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	m.duration.WithLabelValues(route, method).Observe(latency.Seconds())
+	m.bytesServed.WithLabelValues(route).Add(float64(bytes))
+}
+
+// LoginFailed records a failed login attempt.
+func (m *Metrics) LoginFailed() {
+	if m == nil {
+		return
+	}
+	m.loginFailures.Inc()
+}
+
+// SharePasswordFailed records a wrong share password, or one refused as the share is locked.
+func (m *Metrics) SharePasswordFailed() {
+	if m == nil {
+		return
+	}
+	m.shareFailures.Inc()
+}
+
+// ObserveListing records the number of entries returned by a directory listing.
+func (m *Metrics) ObserveListing(entries int) {
+	if m == nil {
+		return
+	}
+	m.listingSize.Observe(float64(entries))
+}
+
+// Handler returns an HTTP handler serving the metrics in the Prometheus exposition format.
+// If bearerToken is non-empty, requests must present it in the Authorization header.
+func (m *Metrics) Handler(bearerToken string) http.Handler {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	if bearerToken == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, []byte("Bearer "+bearerToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying m.
+func NewContext(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// FromContext returns the metrics stored in ctx, or nil if there are none.
+func FromContext(ctx context.Context) *Metrics {
+	m, _ := ctx.Value(contextKey{}).(*Metrics)
+	return m
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSessions int
+
+func (f fakeSessions) ActiveSessions() int {
+	return int(f)
+}
+
+func scrape(t *testing.T, h http.Handler, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+
+	body, err := io.ReadAll(recorder.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	return recorder.Code, string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New(fakeSessions(3))
+	m.ObserveRequest("POST /api/v1/files", http.MethodPost, http.StatusOK, 512, 20*time.Millisecond)
+	m.ObserveRequest("unmatched", "X-RANDOM-1234", http.StatusMethodNotAllowed, 0, 0)
+	m.LoginFailed()
+	m.SharePasswordFailed()
+	m.ObserveListing(7)
+
+	code, body := scrape(t, m.Handler(""), "")
+	if code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", code)
+	}
+
+	expected := []string{
+		`fs4_http_requests_total{code="200",method="POST",route="POST /api/v1/files"} 1`,
+		`fs4_http_response_bytes_total{route="POST /api/v1/files"} 512`,
+		`fs4_login_failures_total 1`,
+		`fs4_share_password_failures_total 1`,
+		`fs4_active_sessions 3`,
+		`fs4_directory_listing_entries_count 1`,
+		`fs4_http_request_duration_seconds_count{method="POST",route="POST /api/v1/files"} 1`,
+		`fs4_http_requests_total{code="405",method="other",route="unmatched"} 1`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+	if strings.Contains(body, "X-RANDOM-1234") {
+		t.Error("expected an unknown method not to be used as a label")
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	h := New(fakeSessions(0)).Handler("secret")
+
+	if code, _ := scrape(t, h, ""); code != http.StatusUnauthorized {
+		t.Errorf("expected status Unauthorized without token, got %d", code)
+	}
+	if code, _ := scrape(t, h, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected status Unauthorized with wrong token, got %d", code)
+	}
+	if code, _ := scrape(t, h, "secret"); code != http.StatusOK {
+		t.Errorf("expected status OK with token, got %d", code)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("/", http.MethodGet, http.StatusOK, 0, 0)
+	m.LoginFailed()
+	m.SharePasswordFailed()
+	m.ObserveListing(1)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/goteleport-interview/fs4/api/handlers"
+)
+
+// WithTrustedProxies sets the addresses of reverse proxies whose X-Forwarded-For and
+// X-Forwarded-Proto headers are trusted. Requests over a Unix socket are always trusted,
+// as access to the socket is controlled by its file permissions.
+func WithTrustedProxies(prefixes []netip.Prefix) Option {
+	return func(s *Server) {
+		s.trustedProxies = prefixes
+	}
+}
+
+// forwardedHeaders is middleware applying the X-Forwarded-For and X-Forwarded-Proto headers
+// set by trusted proxies. The client address from X-Forwarded-For replaces the request's
+// RemoteAddr, so that it is logged and audited, and X-Forwarded-Proto is reduced to the value
+// set by the nearest proxy, see handlers.IsSecure. The headers are removed from requests
+// not made by a trusted proxy.
+func (s *Server) forwardedHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.trustedPeer(r.RemoteAddr) {
+			r.Header.Del(handlers.ForwardedForHeader)
+			r.Header.Del(handlers.ForwardedProtoHeader)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if client := s.forwardedClient(r.Header.Values(handlers.ForwardedForHeader)); client.IsValid() {
+			r.RemoteAddr = client.String()
+		}
+
+		proto := lastValue(r.Header.Values(handlers.ForwardedProtoHeader))
+		if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+			r.Header.Set(handlers.ForwardedProtoHeader, proto)
+		} else {
+			r.Header.Del(handlers.ForwardedProtoHeader)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedPeer reports whether remoteAddr belongs to a trusted proxy.
+// Addresses that are not IP addresses are Unix socket peers.
+func (s *Server) trustedPeer(remoteAddr string) bool {
+	addr, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		ip, err := netip.ParseAddr(remoteAddr)
+		if err != nil {
+			return true
+		}
+		return s.trustedIP(ip)
+	}
+	return s.trustedIP(addr.Addr())
+}
+
+func (s *Server) trustedIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient returns the client address from X-Forwarded-For values: the right-most
+// address not belonging to a trusted proxy, since anything left of it may have been set by
+// the client itself. If every address is trusted, the left-most is used.
+func (s *Server) forwardedClient(values []string) netip.Addr {
+	var addrs []string
+	for _, value := range values {
+		addrs = append(addrs, strings.Split(value, ",")...)
+	}
+
+	var client netip.Addr
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(addrs[i]))
+		if err != nil {
+			// can't tell who added an unparseable entry, so stop at the last known address
+			break
+		}
+		client = ip.Unmap()
+		if !s.trustedIP(client) {
+			break
+		}
+	}
+	return client
+}
+
+// lastValue returns the last element of a comma-separated header that may be repeated.
+func lastValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	parts := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
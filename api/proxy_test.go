@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/handlers"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend(),
+		WithTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		proto      string
+		wantAddr   string
+		wantSecure bool
+	}{
+		{"untrusted peer", "203.0.113.1:1234", []string{"198.51.100.1"}, "https", "203.0.113.1:1234", false},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "https", "198.51.100.1", true},
+		{"spoofed entries are skipped", "10.0.0.1:1234", []string{"192.0.2.1, 198.51.100.1", "10.0.0.2"}, "http", "198.51.100.1", false},
+		{"only proxies", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3", false},
+		{"unparseable entry", "10.0.0.1:1234", []string{"198.51.100.1, unknown"}, "", "10.0.0.1:1234", false},
+		{"nearest proxy's scheme", "10.0.0.1:1234", nil, "http, https", "10.0.0.1:1234", true},
+		{"unknown scheme", "10.0.0.1:1234", nil, "gopher", "10.0.0.1:1234", false},
+		{"unix socket peer", "@", []string{"198.51.100.1"}, "https", "198.51.100.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAddr string
+			var gotSecure bool
+			handler := s.forwardedHeaders(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				gotAddr = r.RemoteAddr
+				gotSecure = handlers.IsSecure(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add(handlers.ForwardedForHeader, value)
+			}
+			if tt.proto != "" {
+				req.Header.Set(handlers.ForwardedProtoHeader, tt.proto)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if gotAddr != tt.wantAddr {
+				t.Errorf("expected remote address %s, got %s", tt.wantAddr, gotAddr)
+			}
+			if gotSecure != tt.wantSecure {
+				t.Errorf("expected secure %v, got %v", tt.wantSecure, gotSecure)
+			}
+		})
+	}
+}
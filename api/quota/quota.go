@@ -0,0 +1,308 @@
+// Package quota tracks the storage used by each user, and limits it.
+//
+// Users are charged for the files they upload, from when the upload completes until the file
+// is deleted. A file overwritten by another user is charged to them from then on. Only the
+// current contents of files count, not versions or the trash. Usage is kept up to date as
+// files are uploaded and deleted, and saved to a single JSON file whenever it changes. Files
+// changed outside the server, or by restoring versions, are only accounted for once usage is
+// recalculated.
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrExceeded is returned when a write would take a user over their hard limit.
+var ErrExceeded = errors.New("storage quota exceeded")
+
+// Limits are the storage a user may use, in bytes. Zero means no limit.
+type Limits struct {
+	// Soft is the usage above which writes succeed with a warning.
+	Soft int64
+	// Hard is the usage writes are not allowed to take a user over.
+	Hard int64
+}
+
+// Usage is a user's storage use and limits.
+type Usage struct {
+	Used  int64 `json:"used"`
+	Files int   `json:"files"`
+	Soft  int64 `json:"soft,omitempty"`
+	Hard  int64 `json:"hard,omitempty"`
+	// OverSoft is whether usage is above the soft limit.
+	OverSoft bool `json:"overSoft"`
+}
+
+// Tracker holds the files charged to each user under a root.
+type Tracker struct {
+	root     string
+	file     string
+	defaults Limits
+	limits   map[string]Limits
+	logger   *slog.Logger
+
+	// mutex guards owners and serialises charged writes, so that concurrent writes cannot
+	// together exceed a limit
+	mutex sync.Mutex
+	// owners maps each charged file, slash-separated and relative to the root, to its owner
+	// and size
+	owners map[string]owned
+}
+
+type owned struct {
+	User string `json:"user"`
+	Size int64  `json:"size"`
+}
+
+// Open returns a Tracker for files under root, saved to file, loading the usage already in
+// it. Users are limited by defaults unless they have their own limits.
+func Open(root, file string, defaults Limits, limits map[string]Limits, logger *slog.Logger) (*Tracker, error) {
+	t := &Tracker{
+		root:     filepath.Clean(root),
+		file:     filepath.Clean(file),
+		defaults: defaults,
+		limits:   limits,
+		logger:   logger,
+		owners:   map[string]owned{},
+	}
+	data, err := os.ReadFile(t.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.owners); err != nil {
+		return nil, fmt.Errorf("corrupt quota file %s: %w", t.file, err)
+	}
+	return t, nil
+}
+
+// Usage returns user's storage use and limits.
+func (t *Tracker) Usage(user string) Usage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.usage(user)
+}
+
+// Check returns an error wrapping ErrExceeded if writing size bytes to path, an absolute path
+// under the root, would take user over their hard limit. Writes that do not grow user's
+// usage are always allowed.
+func (t *Tracker) Check(user, path string, size int64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.check(user, t.rel(path), size)
+}
+
+// Limit returns a reader of r that fails with an error wrapping ErrExceeded once more is read
+// from it than user may write to path, an absolute path under the root, without going over
+// their hard limit. It stops writes of unknown size before they are complete. The allowance is
+// taken when Limit is called, so Write must still be used to charge the write.
+func (t *Tracker) Limit(user, path string, r io.Reader) io.Reader {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	usage := t.usage(user)
+	if usage.Hard <= 0 {
+		return r
+	}
+	var own int64
+	if o, ok := t.owners[t.rel(path)]; ok && o.User == user {
+		own = o.Size
+	}
+	// as in check, writes that do not grow usage are allowed even over the limit
+	allowed := max(usage.Hard-usage.Used+own, own)
+	return &limitedReader{r: r, remaining: allowed, hard: usage.Hard}
+}
+
+// Write charges user for the size bytes written to path by write, if that would not take
+// them over their hard limit. It returns user's usage after the write.
+func (t *Tracker) Write(user, path string, size int64, write func() error) (Usage, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	rel := t.rel(path)
+	if err := t.check(user, rel, size); err != nil {
+		return t.usage(user), err
+	}
+	if err := write(); err != nil {
+		return t.usage(user), err
+	}
+	t.owners[rel] = owned{User: user, Size: size}
+	if err := t.save(); err != nil {
+		// the write has been made, so this is not the caller's failure
+		t.logger.Warn("Failed to save storage usage", slog.String("error", err.Error()))
+	}
+	return t.usage(user), nil
+}
+
+// Claim charges user for every regular file at or below the path returned by restore, such as
+// files restored from the trash, if size more bytes would not take user over their hard limit.
+// restore is only called if they would not.
+func (t *Tracker) Claim(user string, size int64, restore func() (string, error)) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// restored files are all charged anew, whatever they replace
+	if err := t.check(user, "", size); err != nil {
+		return err
+	}
+	path, err := restore()
+	if err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		t.owners[t.rel(p)] = owned{User: user, Size: info.Size()}
+		return nil
+	})
+	if err := errors.Join(err, t.save()); err != nil {
+		// the files have been restored, so this is not the caller's failure
+		t.logger.Warn("Failed to charge restored files", slog.String("path", t.rel(path)), slog.String("error", err.Error()))
+	}
+	return nil
+}
+
+// Release stops charging for path and everything below it, such as when it is deleted.
+func (t *Tracker) Release(path string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	rel := t.rel(path)
+	released := false
+	for p := range t.owners {
+		if p == rel || strings.HasPrefix(p, strings.TrimSuffix(rel, "/")+"/") {
+			delete(t.owners, p)
+			released = true
+		}
+	}
+	if !released {
+		return nil
+	}
+	return t.save()
+}
+
+// Recalculate updates the size of every charged file from disk, no longer charging for those
+// that are gone or are no longer regular files. It returns the usage of every user charged
+// for a file.
+func (t *Tracker) Recalculate() (map[string]Usage, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for p, o := range t.owners {
+		info, err := os.Lstat(filepath.Join(t.root, filepath.FromSlash(p)))
+		switch {
+		case errors.Is(err, fs.ErrNotExist) || (err == nil && !info.Mode().IsRegular()):
+			delete(t.owners, p)
+		case err != nil:
+			return nil, err
+		default:
+			o.Size = info.Size()
+			t.owners[p] = o
+		}
+	}
+
+	usages := map[string]Usage{}
+	for _, o := range t.owners {
+		if _, ok := usages[o.User]; !ok {
+			usages[o.User] = t.usage(o.User)
+		}
+	}
+	return usages, t.save()
+}
+
+// check is Check for rel. The caller must hold t.mutex.
+func (t *Tracker) check(user, rel string, size int64) error {
+	usage := t.usage(user)
+	if usage.Hard <= 0 {
+		return nil
+	}
+	used := usage.Used
+	if o, ok := t.owners[rel]; ok && o.User == user {
+		// the write replaces the user's own file
+		used -= o.Size
+	}
+	if used+size > usage.Hard && used+size > usage.Used {
+		return fmt.Errorf("%w: writing %d bytes would use %d of %d bytes allowed", ErrExceeded, size, used+size, usage.Hard)
+	}
+	return nil
+}
+
+// usage returns user's usage. The caller must hold t.mutex.
+func (t *Tracker) usage(user string) Usage {
+	limits, ok := t.limits[user]
+	if !ok {
+		limits = t.defaults
+	}
+	usage := Usage{Soft: limits.Soft, Hard: limits.Hard}
+	for _, o := range t.owners {
+		if o.User == user {
+			usage.Used += o.Size
+			usage.Files++
+		}
+	}
+	usage.OverSoft = usage.Soft > 0 && usage.Used > usage.Soft
+	return usage
+}
+
+// save writes the charged files to the file via a temporary file, so that a partly written
+// file is never read. The caller must hold t.mutex.
+func (t *Tracker) save() error {
+	data, err := json.Marshal(t.owners)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.file), 0700); err != nil {
+		return err
+	}
+	tmp := t.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+// rel returns path relative to the root in slash-separated form.
+func (t *Tracker) rel(path string) string {
+	rel, err := filepath.Rel(t.root, filepath.Clean(path))
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// limitedReader reads from r until more than remaining bytes have been read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	hard      int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// read one byte more than allowed, to tell a write of exactly the allowance from a larger one
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, fmt.Errorf("%w: write would use more than the %d bytes allowed", ErrExceeded, l.hard)
+	}
+	return n, err
+}
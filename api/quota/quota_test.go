@@ -0,0 +1,168 @@
+package quota
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTracker(t *testing.T, defaults Limits, limits map[string]Limits) (*Tracker, string) {
+	t.Helper()
+	root := t.TempDir()
+	tracker, err := Open(root, filepath.Join(root, ".fs4", "quota.json"), defaults, limits, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to open tracker: %v", err)
+	}
+	return tracker, root
+}
+
+// write writes size bytes to name under root, charged to user.
+func write(t *testing.T, tracker *Tracker, root, user, name string, size int) (Usage, error) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	return tracker.Write(user, path, int64(size), func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, make([]byte, size), 0644)
+	})
+}
+
+func TestWrite(t *testing.T) {
+	tracker, root := testTracker(t, Limits{Soft: 10, Hard: 20}, map[string]Limits{"admin": {}})
+
+	usage, err := write(t, tracker, root, "alice", "a.txt", 8)
+	if err != nil || usage.Used != 8 || usage.Files != 1 || usage.OverSoft {
+		t.Fatalf("expected 8 bytes used, got %+v (%v)", usage, err)
+	}
+	usage, err = write(t, tracker, root, "alice", "docs/b.txt", 8)
+	if err != nil || usage.Used != 16 || !usage.OverSoft {
+		t.Fatalf("expected 16 bytes used over the soft limit, got %+v (%v)", usage, err)
+	}
+
+	if _, err := write(t, tracker, root, "alice", "c.txt", 8); !errors.Is(err, ErrExceeded) {
+		t.Errorf("expected %v over the hard limit, got %v", ErrExceeded, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "c.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the rejected write not to be made, got %v", err)
+	}
+	if err := tracker.Check("alice", filepath.Join(root, "a.txt"), 12); err != nil {
+		t.Errorf("expected replacing an own file within the limit to be allowed, got %v", err)
+	}
+	if err := tracker.Check("admin", filepath.Join(root, "big.txt"), 1000); err != nil {
+		t.Errorf("expected a user without limits to be allowed, got %v", err)
+	}
+
+	// overwriting another user's file moves it to the writer
+	usage, err = write(t, tracker, root, "bob", "a.txt", 4)
+	if err != nil || usage.Used != 4 {
+		t.Fatalf("expected 4 bytes used, got %+v (%v)", usage, err)
+	}
+	if usage := tracker.Usage("alice"); usage.Used != 8 || usage.Files != 1 {
+		t.Errorf("expected the overwritten file no longer charged, got %+v", usage)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tracker, root := testTracker(t, Limits{Hard: 10}, map[string]Limits{"admin": {}})
+	if _, err := write(t, tracker, root, "alice", "a.txt", 4); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	read := func(user, name string, size int) error {
+		t.Helper()
+		_, err := io.Copy(io.Discard, tracker.Limit(user, filepath.Join(root, name), strings.NewReader(strings.Repeat("x", size))))
+		return err
+	}
+	if err := read("alice", "b.txt", 6); err != nil {
+		t.Errorf("expected a write of the remaining allowance to be read, got %v", err)
+	}
+	if err := read("alice", "b.txt", 7); !errors.Is(err, ErrExceeded) {
+		t.Errorf("expected %v reading past the allowance, got %v", ErrExceeded, err)
+	}
+	if err := read("alice", "a.txt", 10); err != nil {
+		t.Errorf("expected replacing an own file to count its size, got %v", err)
+	}
+	if err := read("admin", "big.txt", 1000); err != nil {
+		t.Errorf("expected a user without limits not to be limited, got %v", err)
+	}
+}
+
+func TestReleaseClaim(t *testing.T) {
+	tracker, root := testTracker(t, Limits{}, nil)
+	for _, name := range []string{"docs/a.txt", "docs/sub/b.txt", "docs2/c.txt"} {
+		if _, err := write(t, tracker, root, "alice", name, 5); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := tracker.Release(filepath.Join(root, "docs")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if usage := tracker.Usage("alice"); usage.Used != 5 || usage.Files != 1 {
+		t.Errorf("expected only docs2 still charged, got %+v", usage)
+	}
+
+	restore := func() (string, error) { return filepath.Join(root, "docs"), nil }
+	if err := tracker.Claim("bob", 10, restore); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if usage := tracker.Usage("bob"); usage.Used != 10 || usage.Files != 2 {
+		t.Errorf("expected the claimed files charged, got %+v", usage)
+	}
+}
+
+func TestClaimLimit(t *testing.T) {
+	tracker, root := testTracker(t, Limits{Hard: 10}, nil)
+	if _, err := write(t, tracker, root, "alice", "a.txt", 8); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	restored := false
+	restore := func() (string, error) {
+		restored = true
+		return filepath.Join(root, "a.txt"), nil
+	}
+	if err := tracker.Claim("alice", 5, restore); !errors.Is(err, ErrExceeded) || restored {
+		t.Errorf("expected %v without restoring, got %v", ErrExceeded, err)
+	}
+	if err := tracker.Claim("alice", 2, restore); err != nil || !restored {
+		t.Errorf("expected a restore within the limit, got %v", err)
+	}
+}
+
+func TestRecalculate(t *testing.T) {
+	tracker, root := testTracker(t, Limits{}, nil)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := write(t, tracker, root, "alice", name, 5); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), make([]byte, 12), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, "b.txt")); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+
+	usages, err := tracker.Recalculate()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if usage := usages["alice"]; usage.Used != 12 || usage.Files != 1 {
+		t.Errorf("expected the changed size without the removed file, got %+v", usage)
+	}
+
+	// usage survives a restart
+	reopened, err := Open(root, filepath.Join(root, ".fs4", "quota.json"), Limits{}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("failed to reopen tracker: %v", err)
+	}
+	if usage := reopened.Usage("alice"); usage.Used != 12 {
+		t.Errorf("expected the saved usage, got %+v", usage)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// httpChallengeHandler is implemented by certificate sources answering ACME http-01
+// challenges, such as *autocert.Manager.
+type httpChallengeHandler interface {
+	HTTPHandler(fallback http.Handler) http.Handler
+}
+
+// ListenAndServeRedirect serves plain HTTP on the specified address, permanently redirecting
+// every request to the same host and path over HTTPS on httpsPort.
+// If the certificate source of the TLS listener answers ACME http-01 challenges, they are served here.
+// It returns http.ErrServerClosed once Shutdown has been called.
+func (s *Server) ListenAndServeRedirect(addr string, httpsPort int) error {
+	redirect := redirectHandler(httpsPort)
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if certs := s.certs.Load(); certs != nil {
+				if challenges, ok := (*certs).(httpChallengeHandler); ok {
+					challenges.HTTPHandler(redirect).ServeHTTP(w, r)
+					return
+				}
+			}
+			redirect.ServeHTTP(w, r)
+		}),
+		MaxHeaderBytes:    s.limits.MaxHeaderBytes,
+		ReadHeaderTimeout: s.limits.ReadHeaderTimeout,
+		IdleTimeout:       10 * time.Second,
+	}
+
+	return s.listeners.serve(server, server.ListenAndServe)
+}
+
+// redirectHandler redirects requests to HTTPS on port, which is omitted from the URL if it is 443.
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "missing Host header", http.StatusBadRequest)
+			return
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, target, code)
+	})
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		host     string
+		port     int
+		code     int
+		location string
+	}{
+		{"default port", http.MethodGet, "/browse/docs?sort=name", "files.example.com", 443, http.StatusMovedPermanently, "https://files.example.com/browse/docs?sort=name"},
+		{"custom port", http.MethodGet, "/", "localhost:8080", 8081, http.StatusMovedPermanently, "https://localhost:8081/"},
+		{"ipv6", http.MethodHead, "/", "[::1]:80", 443, http.StatusMovedPermanently, "https://[::1]/"},
+		{"post keeps method", http.MethodPost, "/api/v1/files", "localhost", 8081, http.StatusPermanentRedirect, "https://localhost:8081/api/v1/files"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Host = tt.host
+			recorder := httptest.NewRecorder()
+			redirectHandler(tt.port).ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.code {
+				t.Fatalf("expected status %d, got %d", tt.code, recorder.Code)
+			}
+			if got := recorder.Header().Get("Location"); got != tt.location {
+				t.Fatalf("expected location '%s', got '%s'", tt.location, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+// Package shares keeps links giving people without an account access to a file or directory.
+//
+// A share is reached by a random token, handed out once when it is created. Only a hash of
+// the token is kept, so the store cannot be used to recover links, and shares are managed by
+// a separate ID. Shares may be limited to a number of downloads, protected by a password, and
+// expire, at which point they are dropped the next time the store is saved. The store is
+// saved to a single JSON file whenever it changes.
+//
+// Passwords are guessed at most a few times at once for each share, and a share is locked for
+// a while after repeated wrong passwords, so that they cannot be found by brute force.
+package shares
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrNotFound is returned for unknown tokens, and IDs that are not the user's shares.
+	ErrNotFound = errors.New("share not found")
+	// ErrExpired is returned for tokens of shares that have expired.
+	ErrExpired = errors.New("share has expired")
+	// ErrExhausted is returned for tokens of shares that have reached their download limit.
+	ErrExhausted = errors.New("share download limit reached")
+	// ErrPassword is returned when the password of a protected share is missing or wrong.
+	ErrPassword = errors.New("share password required")
+	// ErrLocked is returned for protected shares after too many wrong passwords.
+	ErrLocked = errors.New("too many wrong share passwords, try again later")
+	// ErrInvalidOptions is returned when creating a share with unusable options.
+	ErrInvalidOptions = errors.New("invalid share options: expiry must be in the future, the download limit not negative, and the password at most 72 bytes")
+)
+
+const (
+	// maxPasswordAttempts is the number of wrong passwords, including those being checked,
+	// allowed for a share before it is locked.
+	maxPasswordAttempts = 5
+	// passwordLockout is how long a share is locked for.
+	passwordLockout = time.Minute
+)
+
+// Options restrict access to a share.
+type Options struct {
+	// Expires is when the share stops working, never if zero.
+	Expires time.Time
+	// Password must be given to use the share, if not empty.
+	Password string
+	// MaxDownloads is the number of downloads allowed, unlimited if zero.
+	MaxDownloads int
+	// Listing allows the contents of a shared directory to be listed and downloaded
+	// individually. Otherwise, it can only be downloaded as a whole.
+	Listing bool
+}
+
+// Share describes a link to a file or directory.
+type Share struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	// Path is the shared file or directory, slash-separated and relative to the root.
+	Path    string     `json:"path"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	// Protected is whether a password is required.
+	Protected    bool `json:"protected"`
+	MaxDownloads int  `json:"maxDownloads,omitempty"`
+	Downloads    int  `json:"downloads"`
+	Listing      bool `json:"listing"`
+}
+
+// record is a share as saved, with the secrets that are never shown.
+type record struct {
+	Share
+	TokenHash    string `json:"tokenHash"`
+	PasswordHash []byte `json:"passwordHash,omitempty"`
+}
+
+// Store holds the shares of every user.
+type Store struct {
+	file string
+	now  func() time.Time
+
+	// mutex guards shares and attempts, and serialises saving them
+	mutex sync.Mutex
+	// shares are keyed by the hash of their token
+	shares map[string]*record
+	// attempts are the recent password attempts for protected shares, by the same key
+	attempts map[string]*attempts
+}
+
+// attempts are the password attempts for a share since it was last unlocked.
+type attempts struct {
+	// checking is the number of passwords being checked, and failed those found to be wrong
+	checking int
+	failed   int
+	locked   time.Time
+}
+
+// Open returns a Store saved to file, loading the shares already in it. The directory
+// holding file is created as needed.
+func Open(file string) (*Store, error) {
+	s := &Store{
+		file:     filepath.Clean(file),
+		now:      time.Now,
+		shares:   map[string]*record{},
+		attempts: map[string]*attempts{},
+	}
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("corrupt shares file %s: %w", s.file, err)
+	}
+	for _, r := range records {
+		s.shares[r.TokenHash] = r
+	}
+	return s, nil
+}
+
+// Create shares path, slash-separated and relative to the root, on behalf of owner. It
+// returns the share and the token that reaches it.
+func (s *Store) Create(owner, path string, opts Options) (Share, string, error) {
+	now := s.now().UTC()
+	if (!opts.Expires.IsZero() && !opts.Expires.After(now)) || opts.MaxDownloads < 0 {
+		return Share{}, "", ErrInvalidOptions
+	}
+
+	id, err := randomString(16, hex.EncodeToString)
+	if err != nil {
+		return Share{}, "", err
+	}
+	token, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return Share{}, "", err
+	}
+	r := &record{
+		Share: Share{
+			ID:           id,
+			Owner:        owner,
+			Path:         path,
+			Created:      now,
+			MaxDownloads: opts.MaxDownloads,
+			Listing:      opts.Listing,
+		},
+		TokenHash: hashToken(token),
+	}
+	if !opts.Expires.IsZero() {
+		expires := opts.Expires.UTC()
+		r.Expires = &expires
+	}
+	if r.PasswordHash, err = hashPassword(opts.Password); err != nil {
+		return Share{}, "", err
+	}
+	r.Protected = r.PasswordHash != nil
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shares[r.TokenHash] = r
+	if err := s.save(); err != nil {
+		delete(s.shares, r.TokenHash)
+		return Share{}, "", err
+	}
+	return r.Share, token, nil
+}
+
+// List returns owner's shares, most recently created first.
+func (s *Store) List(owner string) []Share {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	shares := []Share{}
+	for _, r := range s.shares {
+		if r.Owner == owner {
+			shares = append(shares, r.Share)
+		}
+	}
+	slices.SortFunc(shares, func(a, b Share) int {
+		return b.Created.Compare(a.Created)
+	})
+	return shares
+}
+
+// Revoke removes owner's share with id, so that its token no longer works.
+func (s *Store) Revoke(owner, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for hash, r := range s.shares {
+		if r.ID != id || r.Owner != owner {
+			continue
+		}
+		delete(s.shares, hash)
+		if err := s.save(); err != nil {
+			s.shares[hash] = r
+			return err
+		}
+		delete(s.attempts, hash)
+		return nil
+	}
+	return ErrNotFound
+}
+
+// Access returns the share reached by token, if it can still be used with password. A
+// protected share returns ErrLocked, without checking password, while it is locked after
+// too many wrong passwords. Missing passwords are not counted.
+func (s *Store) Access(token, password string) (Share, error) {
+	s.mutex.Lock()
+	found, err := s.usable(token)
+	r := *found
+	if err == nil && r.Protected && password != "" {
+		err = s.attempt(r.TokenHash)
+	}
+	s.mutex.Unlock()
+	if err != nil || !r.Protected {
+		return r.Share, err
+	}
+	if password == "" {
+		return r.Share, ErrPassword
+	}
+
+	// checked without holding the lock, as bcrypt is deliberately slow
+	ok := bcrypt.CompareHashAndPassword(r.PasswordHash, []byte(password)) == nil
+	s.mutex.Lock()
+	s.attempted(r.TokenHash, ok)
+	s.mutex.Unlock()
+	if !ok {
+		return r.Share, ErrPassword
+	}
+	return r.Share, nil
+}
+
+// attempt starts checking a password for the share whose token hashes to hash, failing if it
+// is locked or enough passwords are already being checked to lock it. The caller must hold
+// s.mutex.
+func (s *Store) attempt(hash string) error {
+	a, ok := s.attempts[hash]
+	if !ok {
+		a = &attempts{}
+		s.attempts[hash] = a
+	}
+	if s.now().Before(a.locked) || a.checking+a.failed >= maxPasswordAttempts {
+		return ErrLocked
+	}
+	a.checking++
+	return nil
+}
+
+// attempted finishes checking a password started by attempt, locking the share once too many
+// have been wrong. The caller must hold s.mutex.
+func (s *Store) attempted(hash string, ok bool) {
+	a := s.attempts[hash]
+	a.checking--
+	switch {
+	case ok:
+		a.failed = 0
+	case a.failed+1 >= maxPasswordAttempts:
+		a.failed = 0
+		a.locked = s.now().Add(passwordLockout)
+	default:
+		a.failed++
+	}
+	if a.checking == 0 && a.failed == 0 && !s.now().Before(a.locked) {
+		delete(s.attempts, hash)
+	}
+}
+
+// Download counts a download from the share reached by token, failing if it has reached its
+// limit or has been revoked or expired since it was accessed.
+func (s *Store) Download(token string) (Share, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r, err := s.usable(token)
+	if err != nil {
+		return r.Share, err
+	}
+	r.Downloads++
+	if err := s.save(); err != nil {
+		r.Downloads--
+		return Share{}, err
+	}
+	return r.Share, nil
+}
+
+// usable returns the record of the share reached by token, with an error if it has expired
+// or reached its download limit. The caller must hold s.mutex.
+func (s *Store) usable(token string) (*record, error) {
+	r, ok := s.shares[hashToken(token)]
+	switch {
+	case !ok:
+		return &record{}, ErrNotFound
+	case r.Expires != nil && !s.now().Before(*r.Expires):
+		return r, ErrExpired
+	case r.MaxDownloads > 0 && r.Downloads >= r.MaxDownloads:
+		return r, ErrExhausted
+	}
+	return r, nil
+}
+
+// save writes the shares that have not expired to the file via a temporary file, so that a
+// partly written file is never read. The caller must hold s.mutex.
+func (s *Store) save() error {
+	now := s.now()
+	records := []*record{}
+	for hash, r := range s.shares {
+		if r.Expires != nil && !now.Before(*r.Expires) {
+			delete(s.shares, hash)
+			continue
+		}
+		records = append(records, r)
+	}
+	slices.SortFunc(records, func(a, b *record) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0700); err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// hashPassword returns the hash of password, or nil if there is none.
+func hashPassword(password string) ([]byte, error) {
+	if password == "" {
+		return nil, nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return nil, ErrInvalidOptions
+	}
+	return hash, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
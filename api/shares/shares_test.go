@@ -0,0 +1,186 @@
+package shares
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), ".fs4", "shares.json")
+	s, err := Open(file)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	return s, file
+}
+
+func TestCreateAccess(t *testing.T) {
+	s, file := testStore(t)
+
+	share, token, err := s.Create("alice", "/docs", Options{Password: "secret", Listing: true})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if share.Owner != "alice" || share.Path != "/docs" || !share.Protected || !share.Listing {
+		t.Errorf("unexpected share %+v", share)
+	}
+
+	if _, err := s.Access(token, ""); !errors.Is(err, ErrPassword) {
+		t.Errorf("expected %v without the password, got %v", ErrPassword, err)
+	}
+	if _, err := s.Access(token, "wrong"); !errors.Is(err, ErrPassword) {
+		t.Errorf("expected %v with the wrong password, got %v", ErrPassword, err)
+	}
+	if got, err := s.Access(token, "secret"); err != nil || got.ID != share.ID {
+		t.Errorf("expected the share, got %+v (%v)", got, err)
+	}
+	if _, err := s.Access(token+"x", "secret"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v for an unknown token, got %v", ErrNotFound, err)
+	}
+
+	// shares survive a restart, and only a hash of the token is kept
+	reopened, err := Open(file)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	if _, err := reopened.Access(token, "secret"); err != nil {
+		t.Errorf("expected the share after reopening, got %v", err)
+	}
+	for hash := range reopened.shares {
+		if hash == token {
+			t.Errorf("expected the token not to be saved")
+		}
+	}
+}
+
+func TestPasswordLockout(t *testing.T) {
+	s, _ := testStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	share, token, err := s.Create("alice", "/docs", Options{Password: "secret"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	guess := func(password string, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := s.Access(token, password); !errors.Is(err, ErrPassword) {
+				t.Fatalf("expected %v with the wrong password, got %v", ErrPassword, err)
+			}
+		}
+	}
+
+	// the right password resets the count
+	guess("wrong", maxPasswordAttempts-1)
+	if _, err := s.Access(token, "secret"); err != nil {
+		t.Fatalf("expected the share, got %v", err)
+	}
+	guess("wrong", maxPasswordAttempts)
+	if _, err := s.Access(token, "secret"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected %v once locked, got %v", ErrLocked, err)
+	}
+	now = now.Add(passwordLockout)
+	if _, err := s.Access(token, "secret"); err != nil {
+		t.Errorf("expected the share once unlocked, got %v", err)
+	}
+
+	// passwords being checked count towards the limit
+	for i := 0; i < maxPasswordAttempts; i++ {
+		if err := s.attempt(hashToken(token)); err != nil {
+			t.Fatalf("expected attempt %d to be allowed, got %v", i, err)
+		}
+	}
+	if _, err := s.Access(token, "secret"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected %v with too many passwords being checked, got %v", ErrLocked, err)
+	}
+	if s.Revoke("alice", share.ID) != nil || len(s.attempts) != 0 {
+		t.Errorf("expected attempts dropped with the share, got %d", len(s.attempts))
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	s, _ := testStore(t)
+
+	for name, opts := range map[string]Options{
+		"past expiry":       {Expires: time.Now().Add(-time.Minute)},
+		"negative limit":    {MaxDownloads: -1},
+		"password too long": {Password: string(make([]byte, 73))},
+	} {
+		if _, _, err := s.Create("alice", "/docs", opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: expected %v, got %v", name, ErrInvalidOptions, err)
+		}
+	}
+}
+
+func TestDownloadLimit(t *testing.T) {
+	s, _ := testStore(t)
+	_, token, err := s.Create("alice", "/notes.txt", Options{MaxDownloads: 2})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		share, err := s.Download(token)
+		if err != nil || share.Downloads != i {
+			t.Fatalf("expected download %d, got %+v (%v)", i, share, err)
+		}
+	}
+	if _, err := s.Download(token); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected %v, got %v", ErrExhausted, err)
+	}
+	if _, err := s.Access(token, ""); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected %v, got %v", ErrExhausted, err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s, _ := testStore(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	_, token, err := s.Create("alice", "/notes.txt", Options{Expires: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := s.Access(token, ""); err != nil {
+		t.Fatalf("expected the share before it expires, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := s.Access(token, ""); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected %v, got %v", ErrExpired, err)
+	}
+
+	// expired shares are dropped once the store is saved
+	if _, _, err := s.Create("alice", "/other.txt", Options{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if shares := s.List("alice"); len(shares) != 1 || shares[0].Path != "/other.txt" {
+		t.Errorf("expected only the unexpired share, got %+v", shares)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s, _ := testStore(t)
+	share, token, err := s.Create("alice", "/notes.txt", Options{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := s.Revoke("bob", share.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v revoking another user's share, got %v", ErrNotFound, err)
+	}
+	if shares := s.List("bob"); len(shares) != 0 {
+		t.Errorf("expected no shares for another user, got %+v", shares)
+	}
+	if err := s.Revoke("alice", share.ID); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := s.Access(token, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v after revoking, got %v", ErrNotFound, err)
+	}
+}
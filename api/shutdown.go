@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdowner is a server that can be stopped gracefully, such as *http.Server or *http3.Server.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// listeners tracks the HTTP servers started by a Server so they can be shut down together.
+type listeners struct {
+	servers []shutdowner
+	closed  bool
+	mutex   sync.Mutex
+}
+
+// serve registers server and runs fn, which is expected to block until the server stops.
+// If the Server has already been shut down, http.ErrServerClosed is returned without calling fn.
+func (l *listeners) serve(server shutdowner, fn func() error) error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return http.ErrServerClosed
+	}
+	l.servers = append(l.servers, server)
+	l.mutex.Unlock()
+
+	return fn()
+}
+
+// shutdown gracefully stops all registered servers concurrently.
+func (l *listeners) shutdown(ctx context.Context) error {
+	l.mutex.Lock()
+	l.closed = true
+	servers := l.servers
+	l.mutex.Unlock()
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server shutdowner) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+
+	var err error
+	for range servers {
+		err = errors.Join(err, <-errs)
+	}
+	return err
+}
+
+// Shutdown gracefully stops the server. It marks the server as not ready, and keeps serving
+// for the drain delay, if any, so that load balancers see it. It then ends event streams,
+// stops accepting new connections on all listeners, and waits for in-flight requests such
+// as downloads to complete or for ctx to expire, whichever comes first.
+// Background work, such as purging expired trash and versions or calculating directory
+// sizes, is then stopped, and persistent state, such as the audit log, is flushed and closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Drain()
+	if s.drainDelay > 0 {
+		timer := time.NewTimer(s.drainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	// event streams never finish on their own, so would otherwise hold up shutdown
+	err := s.events.Close()
+	err = errors.Join(err, s.listeners.shutdown(ctx))
+	err = errors.Join(err, s.trash.Close())
+	if s.versions != nil {
+		err = errors.Join(err, s.versions.Close())
+	}
+	if s.dirSizes != nil {
+		err = errors.Join(err, s.dirSizes.Close())
+	}
+
+	if closeErr := s.auditLog.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+
+	return err
+}
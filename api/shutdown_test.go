@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api/audit"
+	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/watch"
+)
+
+func TestShutdown(t *testing.T) {
+	auditLog, err := audit.NewLogger(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create audit log: %v", err)
+	}
+
+	s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend(), WithAuditLog(auditLog))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.ListenAndServeMetrics("127.0.0.1:0", "")
+	}()
+
+	// wait for the listener to be registered before shutting down
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.listeners.mutex.Lock()
+		started := len(s.listeners.servers) > 0
+		s.listeners.mutex.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("listener did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed from listener, got %v", err)
+	}
+	if !s.draining.Load() {
+		t.Error("expected server to be draining after shutdown")
+	}
+	if err := auditLog.Log(audit.Event{Action: audit.ActionLogin}); !errors.Is(err, audit.ErrClosed) {
+		t.Errorf("expected audit log to be closed, got %v", err)
+	}
+	if _, err := s.events.Subscribe([]string{s.baseDir}); !errors.Is(err, watch.ErrClosed) {
+		t.Errorf("expected event streams to be closed, got %v", err)
+	}
+	if err := s.ListenAndServeMetrics("127.0.0.1:0", ""); !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("expected ErrServerClosed when serving after shutdown, got %v", err)
+	}
+}
+
+func TestShutdownDrainDelay(t *testing.T) {
+	s, err := NewServer(testAssets, t.TempDir(), auth.NewInMemoryBackend(), WithDrainDelay(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(ctx)
+	}()
+
+	// ready is reported as failing while listeners are still open
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.draining.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, _ := probe(t, s, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz status ServiceUnavailable while draining, got %d", code)
+	}
+	s.listeners.mutex.Lock()
+	closed := s.listeners.closed
+	s.listeners.mutex.Unlock()
+	if closed {
+		t.Error("expected listeners to stay open during the drain delay")
+	}
+
+	// an expired ctx cuts the delay short
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected shutdown to stop waiting once ctx is done")
+	}
+	if !s.listeners.closed {
+		t.Error("expected listeners to be closed after the drain delay")
+	}
+}
@@ -0,0 +1,46 @@
+package thumbnail
+
+import "image"
+
+// orient flips and rotates img from the given EXIF orientation to upright.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// rotated or transposed, so width and height swap
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := orientPoint(orientation, x, y, w, h)
+			dst.SetRGBA(dx, dy, img.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// orientPoint returns where the pixel at x, y of a w by h image stored with the given
+// orientation is displayed.
+func orientPoint(orientation, x, y, w, h int) (int, int) {
+	switch orientation {
+	case 2: // mirrored
+		return w - 1 - x, y
+	case 3: // rotated 180°
+		return w - 1 - x, h - 1 - y
+	case 4: // flipped vertically
+		return x, h - 1 - y
+	case 5: // transposed
+		return y, x
+	case 6: // needs rotating 90° clockwise
+		return h - 1 - y, x
+	case 7: // transversed
+		return h - 1 - y, w - 1 - x
+	default: // 8, needs rotating 90° counterclockwise
+		return y, w - 1 - x
+	}
+}
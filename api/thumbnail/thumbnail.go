@@ -0,0 +1,265 @@
+// Package thumbnail generates scaled-down JPEG previews of images and caches them on disk.
+//
+// JPEG, PNG, GIF and WebP images are supported. Thumbnails are generated in a few fixed sizes
+// so that the cache holds a bounded number per image, and are keyed by the image's path,
+// modification time and size, so an edited image gets a new thumbnail. Decoding is expensive,
+// so at most a fixed number of images are decoded at once, and images with more pixels than
+// a limit are rejected before being decoded.
+package thumbnail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	// registered with image.Decode
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/goteleport-interview/fs4/api/exif"
+)
+
+var (
+	// ErrUnsupported is returned for files that are not images in a supported format.
+	ErrUnsupported = errors.New("unsupported image format")
+	// ErrTooLarge is returned for images with more pixels than the configured maximum.
+	ErrTooLarge = errors.New("image too large for thumbnail")
+)
+
+// Sizes are the sizes generated, in pixels along the longest side.
+var Sizes = []int{64, 128, 256, 512}
+
+// jpegQuality is the quality thumbnails are encoded with.
+const jpegQuality = 80
+
+// Bucket returns the size generated for a request of size pixels: the smallest of Sizes that
+// is at least size, or the largest.
+func Bucket(size int) int {
+	for _, s := range Sizes {
+		if s >= size {
+			return s
+		}
+	}
+	return Sizes[len(Sizes)-1]
+}
+
+// Options configures a Generator.
+type Options struct {
+	// CacheDir is the directory thumbnails are written to. It is created if needed.
+	CacheDir string
+	// Workers is the number of images decoded at once.
+	Workers int
+	// MaxPixels is the largest width times height of an image that is decoded.
+	MaxPixels int64
+}
+
+// Generator generates and caches thumbnails.
+type Generator struct {
+	cacheDir  string
+	maxPixels int64
+	// workers holds a token for each image being decoded.
+	workers chan struct{}
+
+	mutex sync.Mutex
+	// pending are the thumbnails being generated, by cache key, so that concurrent requests
+	// for the same thumbnail share the work.
+	pending map[string]*pending
+}
+
+type pending struct {
+	done chan struct{}
+	err  error
+}
+
+// New creates a Generator, creating its cache directory.
+func New(opts Options) (*Generator, error) {
+	if opts.Workers < 1 {
+		return nil, fmt.Errorf("thumbnail workers must be at least 1, got %d", opts.Workers)
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create thumbnail cache: %w", err)
+	}
+	return &Generator{
+		cacheDir:  opts.CacheDir,
+		maxPixels: opts.MaxPixels,
+		workers:   make(chan struct{}, opts.Workers),
+		pending:   map[string]*pending{},
+	}, nil
+}
+
+// Thumbnail returns the path of a JPEG thumbnail of the image at path, fitting within the
+// Bucket for size, generating it if it is not cached. The thumbnail's file name is derived
+// from the image's path, modification time and size, so it changes when the image does.
+// It waits for a free worker until ctx is done.
+func (g *Generator) Thumbnail(ctx context.Context, path string, size int) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", ErrUnsupported
+	}
+
+	size = Bucket(size)
+	key := cacheKey(path, info, size)
+	dest := filepath.Join(g.cacheDir, key[:2], key+".jpg")
+	for {
+		if _, err := os.Stat(dest); err == nil {
+			return dest, nil
+		}
+
+		p, leader := g.start(key)
+		if leader {
+			p.err = g.generate(ctx, path, dest, size)
+			g.finish(key, p)
+			return dest, p.err
+		}
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		// the request generating it went away, so try again on behalf of this one
+		if !errors.Is(p.err, context.Canceled) && !errors.Is(p.err, context.DeadlineExceeded) {
+			return dest, p.err
+		}
+	}
+}
+
+// cacheKey identifies the thumbnail of the given size for the file at path.
+func cacheKey(path string, info os.FileInfo, size int) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d\x00%d", path, info.ModTime().UnixNano(), info.Size(), size))
+	return hex.EncodeToString(sum[:])
+}
+
+// start returns the pending generation of key, and whether the caller must generate it.
+func (g *Generator) start(key string) (*pending, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if p, ok := g.pending[key]; ok {
+		return p, false
+	}
+	p := &pending{done: make(chan struct{})}
+	g.pending[key] = p
+	return p, true
+}
+
+func (g *Generator) finish(key string, p *pending) {
+	g.mutex.Lock()
+	delete(g.pending, key)
+	g.mutex.Unlock()
+	close(p.done)
+}
+
+// generate writes a thumbnail of the image at path to dest once a worker is free.
+func (g *Generator) generate(ctx context.Context, path, dest string, size int) error {
+	select {
+	case g.workers <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-g.workers }()
+
+	img, err := g.decode(path)
+	if err != nil {
+		return err
+	}
+	return writeJPEG(dest, orient(scale(img.image, size), img.orientation))
+}
+
+type decoded struct {
+	image       image.Image
+	orientation int
+}
+
+// decode decodes the image at path, and reads its EXIF orientation if it is a JPEG.
+func (g *Generator) decode(path string) (decoded, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return decoded{}, err
+	}
+	// nolint:errcheck
+	defer f.Close()
+
+	// check the dimensions first, so that a small file claiming to be a huge image is not
+	// decoded into a huge buffer
+	config, format, err := image.DecodeConfig(f)
+	if errors.Is(err, image.ErrFormat) {
+		return decoded{}, ErrUnsupported
+	}
+	if err != nil {
+		return decoded{}, err
+	}
+	if int64(config.Width)*int64(config.Height) > g.maxPixels {
+		return decoded{}, ErrTooLarge
+	}
+
+	result := decoded{orientation: 1}
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return decoded{}, err
+		}
+		if tags, ok := exif.Read(f); ok && tags.Orientation != 0 {
+			result.orientation = tags.Orientation
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return decoded{}, err
+	}
+	// only the first frame of an animated GIF is decoded
+	result.image, _, err = image.Decode(f)
+	return result, err
+}
+
+// scale scales img down to fit within size by size pixels, on a white background in place of
+// any transparency. Smaller images are not scaled up.
+func scale(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// writeJPEG encodes img to dest, via a temporary file so that a partly written thumbnail is
+// never served.
+func writeJPEG(dest string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	// nolint:errcheck
+	defer os.Remove(tmp.Name())
+
+	err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: jpegQuality})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
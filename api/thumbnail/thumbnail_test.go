@@ -0,0 +1,232 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testGenerator(t *testing.T, workers int) *Generator {
+	t.Helper()
+	g, err := New(Options{CacheDir: filepath.Join(t.TempDir(), "cache"), Workers: workers, MaxPixels: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to create generator: %v", err)
+	}
+	return g
+}
+
+// testImage returns a w by h image, red in its top left quadrant and blue elsewhere.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{B: 255, A: 255}
+			if x < w/2 && y < h/2 {
+				c = color.RGBA{R: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// exifSegment returns an APP1 segment holding a little-endian EXIF orientation tag.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// writeJPEGFile writes img as a JPEG to name in dir, with an EXIF orientation if non-zero.
+func writeJPEGFile(t *testing.T, dir, name string, img image.Image, orientation uint16) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	data := buf.Bytes()
+	if orientation != 0 {
+		// after the start of image marker
+		data = append(append([]byte{0xFF, 0xD8}, exifSegment(orientation)...), data[2:]...)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	return p
+}
+
+func decodeFile(t *testing.T, p string) image.Image {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatalf("failed to open thumbnail: %v", err)
+	}
+	// nolint:errcheck
+	defer f.Close()
+	img, format, err := image.Decode(f)
+	if err != nil || format != "jpeg" {
+		t.Fatalf("expected a jpeg thumbnail, got %s (%v)", format, err)
+	}
+	return img
+}
+
+// isRed reports whether the pixel at x, y is mostly red.
+func isRed(img image.Image, x, y int) bool {
+	r, _, b, _ := img.At(x, y).RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func TestBucket(t *testing.T) {
+	for size, expected := range map[int]int{1: 64, 64: 64, 65: 128, 300: 512, 10000: 512} {
+		if got := Bucket(size); got != expected {
+			t.Errorf("expected bucket %d for %d, got %d", expected, size, got)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	dir := t.TempDir()
+	g := testGenerator(t, 2)
+
+	t.Run("scaled and cached", func(t *testing.T) {
+		src := writeJPEGFile(t, dir, "wide.jpg", testImage(400, 200), 0)
+		thumb, err := g.Thumbnail(context.Background(), src, 100)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if bounds := decodeFile(t, thumb).Bounds(); bounds.Dx() != 128 || bounds.Dy() != 64 {
+			t.Errorf("expected 128x64 thumbnail, got %v", bounds)
+		}
+
+		again, err := g.Thumbnail(context.Background(), src, 128)
+		if err != nil || again != thumb {
+			t.Errorf("expected cached thumbnail %s, got %s (%v)", thumb, again, err)
+		}
+
+		// a modified image gets a new thumbnail
+		if err := os.Chtimes(src, time.Now(), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("failed to touch image: %v", err)
+		}
+		if changed, err := g.Thumbnail(context.Background(), src, 128); err != nil || changed == thumb {
+			t.Errorf("expected a new thumbnail after modification, got %s (%v)", changed, err)
+		}
+	})
+
+	t.Run("small images are not enlarged", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 10, 20))); err != nil {
+			t.Fatalf("failed to encode png: %v", err)
+		}
+		src := filepath.Join(dir, "small.png")
+		if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+		thumb, err := g.Thumbnail(context.Background(), src, 512)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		img := decodeFile(t, thumb)
+		if bounds := img.Bounds(); bounds.Dx() != 10 || bounds.Dy() != 20 {
+			t.Errorf("expected 10x20 thumbnail, got %v", bounds)
+		}
+		// transparency is replaced by white
+		if r, g, b, _ := img.At(5, 5).RGBA(); r < 0xF000 || g < 0xF000 || b < 0xF000 {
+			t.Errorf("expected white background, got %v", img.At(5, 5))
+		}
+	})
+
+	t.Run("exif orientation", func(t *testing.T) {
+		// stored as 200x100 with red top left, displayed rotated 90° clockwise as 100x200
+		// with red top right
+		src := writeJPEGFile(t, dir, "rotated.jpg", testImage(200, 100), 6)
+		thumb, err := g.Thumbnail(context.Background(), src, 64)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		img := decodeFile(t, thumb)
+		if bounds := img.Bounds(); bounds.Dx() != 32 || bounds.Dy() != 64 {
+			t.Fatalf("expected 32x64 thumbnail, got %v", bounds)
+		}
+		if !isRed(img, 24, 8) || isRed(img, 8, 8) {
+			t.Error("expected image to be rotated clockwise")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		text := filepath.Join(dir, "notes.txt")
+		if err := os.WriteFile(text, []byte("not an image"), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if _, err := g.Thumbnail(context.Background(), text, 64); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported, got %v", err)
+		}
+		if _, err := g.Thumbnail(context.Background(), dir, 64); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported for a directory, got %v", err)
+		}
+		if _, err := g.Thumbnail(context.Background(), filepath.Join(dir, "missing.jpg"), 64); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+
+		huge := writeJPEGFile(t, dir, "huge.jpg", image.NewGray(image.Rect(0, 0, 2048, 1024)), 0)
+		if _, err := g.Thumbnail(context.Background(), huge, 64); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge, got %v", err)
+		}
+	})
+}
+
+func TestThumbnailWorkers(t *testing.T) {
+	dir := t.TempDir()
+	g := testGenerator(t, 1)
+	src := writeJPEGFile(t, dir, "photo.jpg", testImage(300, 300), 0)
+
+	// with the only worker busy, requests wait until their context is done
+	g.workers <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := g.Thumbnail(ctx, src, 64); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+
+	// concurrent requests for the same thumbnail share it once the worker is free
+	var wg sync.WaitGroup
+	results := make([]string, 4)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			thumb, err := g.Thumbnail(context.Background(), src, 64)
+			if err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			results[i] = thumb
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	<-g.workers
+	wg.Wait()
+	for _, thumb := range results[1:] {
+		if thumb != results[0] {
+			t.Errorf("expected the same thumbnail, got %v", results)
+		}
+	}
+}
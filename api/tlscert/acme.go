@@ -0,0 +1,77 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ALPNProto is the TLS protocol the listener must offer for tls-alpn-01 challenges.
+const ALPNProto = acme.ALPNProto
+
+// ACMEOptions configures NewACMEManager.
+type ACMEOptions struct {
+	// DirectoryURL is the ACME directory, e.g. autocert.DefaultACMEDirectory for Let's Encrypt.
+	DirectoryURL string
+	// Email is an optional contact address for the ACME account.
+	Email string
+	// Hosts are the only names certificates will be requested for.
+	Hosts []string
+	// CacheDir stores the account key and obtained certificates.
+	CacheDir string
+	// CABundle is an optional PEM file of CAs trusted when connecting to the directory,
+	// for test servers such as pebble.
+	CABundle string
+	// RenewBefore is how long before expiry certificates are renewed, zero for the default of 30 days.
+	RenewBefore time.Duration
+}
+
+// NewACMEManager returns a certificate source obtaining certificates from an ACME directory.
+// Certificates are requested on the first handshake for each host and renewed in the background.
+// Challenges are answered with tls-alpn-01 on the TLS listener, which must offer ALPNProto,
+// or with http-01 if the manager's HTTPHandler is served on port 80.
+func NewACMEManager(opts ACMEOptions) (*autocert.Manager, error) {
+	if len(opts.Hosts) == 0 {
+		return nil, errors.New("at least one host is required")
+	}
+	if opts.CacheDir == "" {
+		return nil, errors.New("a cache directory is required")
+	}
+
+	httpClient := http.DefaultClient
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ACME CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CABundle)
+		}
+		transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+		if defaultTransport, ok := http.DefaultTransport.(*http.Transport); ok {
+			transport = defaultTransport.Clone()
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(opts.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(opts.Hosts...),
+		RenewBefore: opts.RenewBefore,
+		Email:       opts.Email,
+		Client: &acme.Client{
+			DirectoryURL: opts.DirectoryURL,
+			HTTPClient:   httpClient,
+		},
+	}, nil
+}
@@ -0,0 +1,274 @@
+package tlscert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 90 * 24 * time.Hour
+	// leafRenewBefore is how close to expiry a cached leaf certificate is regenerated.
+	leafRenewBefore = 30 * 24 * time.Hour
+)
+
+// SelfSignedFiles are the files written by EnsureSelfSigned.
+type SelfSignedFiles struct {
+	CACert string
+	CAKey  string
+	Cert   string
+	Key    string
+}
+
+// selfSignedFiles returns the file locations within dir.
+func selfSignedFiles(dir string) SelfSignedFiles {
+	return SelfSignedFiles{
+		CACert: filepath.Join(dir, "ca.pem"),
+		CAKey:  filepath.Join(dir, "ca-key.pem"),
+		Cert:   filepath.Join(dir, "cert.pem"),
+		Key:    filepath.Join(dir, "key.pem"),
+	}
+}
+
+// EnsureSelfSigned makes sure dir holds a local CA and a leaf certificate signed by it for hosts,
+// which may be DNS names or IP addresses. Cached files are reused while the leaf covers the same
+// hosts and is not close to expiry; otherwise a new leaf is issued by the cached CA.
+// Clients can trust the CA certificate to avoid certificate warnings.
+// issued reports whether a new leaf certificate was written.
+func EnsureSelfSigned(dir string, hosts []string, logger *slog.Logger) (files SelfSignedFiles, issued bool, err error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	files = selfSignedFiles(dir)
+	if len(hosts) == 0 {
+		return files, false, errors.New("at least one host is required")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return files, false, fmt.Errorf("could not create certificate cache: %w", err)
+	}
+
+	caCert, caKey, err := loadOrCreateCA(files, logger)
+	if err != nil {
+		return files, false, err
+	}
+
+	if leafIsCurrent(files, caCert, hosts, time.Now()) {
+		return files, false, nil
+	}
+
+	if err := createLeaf(files, caCert, caKey, hosts); err != nil {
+		return files, false, err
+	}
+	logger.Info("Generated self-signed TLS certificate",
+		slog.Any("hosts", hosts),
+		slog.String("ca", files.CACert),
+	)
+	return files, true, nil
+}
+
+func loadOrCreateCA(files SelfSignedFiles, logger *slog.Logger) (*x509.Certificate, crypto.Signer, error) {
+	cert, key, err := loadKeyPair(files.CACert, files.CAKey)
+	if err == nil && time.Now().Add(leafValidity).Before(cert.NotAfter) {
+		return cert, key, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("could not load local CA: %w", err)
+	}
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "fs4 local CA", Organization: []string{"fs4"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create local CA: %w", err)
+	}
+	if err := writeKeyPair(files.CACert, files.CAKey, [][]byte{der}, key); err != nil {
+		return nil, nil, err
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info("Generated local CA", slog.String("ca", files.CACert), slog.Time("expires", cert.NotAfter))
+	return cert, key, nil
+}
+
+// leafIsCurrent reports whether the cached leaf was issued by ca for exactly hosts and is not near expiry.
+func leafIsCurrent(files SelfSignedFiles, ca *x509.Certificate, hosts []string, now time.Time) bool {
+	leaf, _, err := loadKeyPair(files.Cert, files.Key)
+	if err != nil {
+		return false
+	}
+	if leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	if now.Add(leafRenewBefore).After(leaf.NotAfter) {
+		return false
+	}
+
+	var names []string
+	names = append(names, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	want := normalizeHosts(hosts)
+	sort.Strings(names)
+	return slices.Equal(names, want)
+}
+
+func createLeaf(files SelfSignedFiles, ca *x509.Certificate, caKey crypto.Signer, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{"fs4"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range normalizeHosts(hosts) {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		return fmt.Errorf("could not create certificate: %w", err)
+	}
+	return writeKeyPair(files.Cert, files.Key, [][]byte{der, ca.Raw}, key)
+}
+
+// normalizeHosts returns hosts sorted and deduplicated, with IP addresses in canonical form.
+func normalizeHosts(hosts []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		if !seen[host] {
+			seen[host] = true
+			out = append(out, host)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func loadKeyPair(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("invalid PEM data")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported private key type")
+	}
+	return cert, signer, nil
+}
+
+// writeKeyPair writes the certificate chain and private key, replacing existing files atomically.
+func writeKeyPair(certFile, keyFile string, chain [][]byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	// write the key first, so a watcher never sees a new cert with an old key for long
+	if err := writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(certFile, certPEM, 0644)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// nolint:errcheck
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,147 @@
+// Package tlscert manages the TLS certificate served by fs4, reloading it when the files change.
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Manager serves a certificate and key pair loaded from disk via GetCertificate.
+// The pair is reloaded atomically by Reload or Watch; if the new pair is broken,
+// the previous certificate keeps being served.
+type Manager struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+	cert     atomic.Pointer[tls.Certificate]
+	// stamp identifies the file versions last attempted, guarded by mutex
+	stamp fileStamp
+	mutex sync.Mutex
+}
+
+// fileStamp identifies a version of the cert and key files.
+type fileStamp struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// NewManager loads the certificate and key pair from the given files.
+// If logger is nil, slog.Default() is used.
+func NewManager(certFile, keyFile string, logger *slog.Logger) (*Manager, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	m := &Manager{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := m.cert.Load()
+	if cert == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+	return cert, nil
+}
+
+// Certificate returns the current certificate.
+func (m *Manager) Certificate() *tls.Certificate {
+	return m.cert.Load()
+}
+
+// Reload reads the certificate and key files and, if they form a valid pair, swaps them in.
+// On error the previously loaded certificate is kept.
+func (m *Manager) Reload() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stamp, err := m.statFiles()
+	if err != nil {
+		return err
+	}
+	m.stamp = stamp
+
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS keypair: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("could not parse TLS certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	m.cert.Store(&cert)
+
+	level := slog.LevelInfo
+	if time.Now().After(leaf.NotAfter) {
+		level = slog.LevelWarn
+	}
+	m.logger.Log(context.Background(), level, "Loaded TLS certificate",
+		slog.String("subject", leaf.Subject.String()),
+		slog.String("serial", leaf.SerialNumber.String()),
+		slog.Time("expires", leaf.NotAfter),
+	)
+	return nil
+}
+
+// Watch polls the certificate and key files every interval and reloads them when either
+// changes, until ctx is done. Failed reloads are logged and the previous certificate is kept.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !m.changed() {
+				continue
+			}
+			if err := m.Reload(); err != nil {
+				m.logger.Error("Failed to reload TLS certificate, keeping previous", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (m *Manager) changed() bool {
+	stamp, err := m.statFiles()
+	if err != nil {
+		// files may be mid-replacement, try again on the next tick
+		return false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return stamp != m.stamp
+}
+
+func (m *Manager) statFiles() (fileStamp, error) {
+	certInfo, err := os.Stat(m.certFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	keyInfo, err := os.Stat(m.keyFile)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{
+		certMod:  certInfo.ModTime(),
+		keyMod:   keyInfo.ModTime(),
+		certSize: certInfo.Size(),
+		keySize:  keyInfo.Size(),
+	}, nil
+}
@@ -0,0 +1,203 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePair writes a self-signed certificate with the given serial and its key to dir.
+func writePair(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(30 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func serialOf(t *testing.T, m *Manager) int64 {
+	t.Helper()
+	cert, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("expected certificate, got error %v", err)
+	}
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePair(t, certFile, keyFile, 1)
+
+	m, err := NewManager(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	if got := serialOf(t, m); got != 1 {
+		t.Fatalf("expected serial 1, got %d", got)
+	}
+
+	writePair(t, certFile, keyFile, 2)
+	if err := m.Reload(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := serialOf(t, m); got != 2 {
+		t.Fatalf("expected serial 2 after reload, got %d", got)
+	}
+
+	// a certificate paired with the wrong key must be rejected
+	otherDir := t.TempDir()
+	writePair(t, filepath.Join(otherDir, "cert.pem"), filepath.Join(otherDir, "key.pem"), 3)
+	otherCert, err := os.ReadFile(filepath.Join(otherDir, "cert.pem"))
+	if err != nil {
+		t.Fatalf("failed to read cert: %v", err)
+	}
+	if err := os.WriteFile(certFile, otherCert, 0600); err != nil {
+		t.Fatalf("failed to write cert: %v", err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Fatal("expected mismatched pair to be rejected")
+	}
+	if got := serialOf(t, m); got != 2 {
+		t.Fatalf("expected previous serial 2 to be kept, got %d", got)
+	}
+}
+
+func TestNewManagerInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewManager(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing-key.pem"), nil); err == nil {
+		t.Fatal("expected error for missing files")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePair(t, certFile, keyFile, 1)
+
+	m, err := NewManager(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Watch(ctx, 10*time.Millisecond)
+
+	writePair(t, certFile, keyFile, 2)
+	// make sure the change is visible even on filesystems with coarse timestamps
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(keyFile, future, future); err != nil {
+		t.Fatalf("failed to touch key: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for serialOf(t, m) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected certificate to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	hosts := []string{"localhost", "127.0.0.1"}
+
+	files, issued, err := EnsureSelfSigned(dir, hosts, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !issued {
+		t.Fatal("expected a certificate to be issued")
+	}
+
+	m, err := NewManager(files.Cert, files.Key, nil)
+	if err != nil {
+		t.Fatalf("failed to load generated certificate: %v", err)
+	}
+	leaf := m.Certificate().Leaf
+	caPEM, err := os.ReadFile(files.CACert)
+	if err != nil {
+		t.Fatalf("failed to read CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	for _, host := range hosts {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Fatalf("expected certificate to be valid for %s, got %v", host, err)
+		}
+	}
+	info, err := os.Stat(files.Key)
+	if err != nil {
+		t.Fatalf("failed to stat key: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected key mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// cached files are reused
+	if _, issued, err := EnsureSelfSigned(dir, []string{"127.0.0.1", "localhost", "localhost"}, nil); err != nil || issued {
+		t.Fatalf("expected cached certificate to be reused, got issued=%v err=%v", issued, err)
+	}
+
+	// a change of hosts issues a new leaf from the same CA
+	if _, issued, err := EnsureSelfSigned(dir, []string{"fs4.test"}, nil); err != nil || !issued {
+		t.Fatalf("expected new certificate for changed hosts, got issued=%v err=%v", issued, err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if _, err := m.Certificate().Leaf.Verify(x509.VerifyOptions{DNSName: "fs4.test", Roots: roots}); err != nil {
+		t.Fatalf("expected reissued certificate to chain to the cached CA, got %v", err)
+	}
+}
+
+func TestNewACMEManager(t *testing.T) {
+	if _, err := NewACMEManager(ACMEOptions{CacheDir: t.TempDir()}); err == nil {
+		t.Fatal("expected error without hosts")
+	}
+	if _, err := NewACMEManager(ACMEOptions{Hosts: []string{"fs4.test"}, CacheDir: t.TempDir(), CABundle: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Fatal("expected error for missing CA bundle")
+	}
+
+	m, err := NewACMEManager(ACMEOptions{Hosts: []string{"fs4.test"}, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.test"); err == nil {
+		t.Fatal("expected hosts outside the configured list to be rejected")
+	}
+}
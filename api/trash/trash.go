@@ -0,0 +1,474 @@
+// Package trash moves deleted files and directories into a per-user trash, from which they
+// can be restored to where they were or purged for good.
+//
+// Each user's trash is a directory holding the deleted entries, renamed to a random ID, and
+// a JSON file alongside each recording where it came from. Entries are moved rather than
+// copied, so the trash must be on the same filesystem as the files it holds. Items older
+// than a retention period are purged in the background.
+package trash
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for IDs not in the user's trash.
+	ErrNotFound = errors.New("item not found in trash")
+	// ErrConflict is returned when restoring to a path that exists, with ConflictFail.
+	ErrConflict = errors.New("restore destination already exists")
+	// ErrOutsideRoot is returned when deleting the root, or restoring to a path that now
+	// resolves outside it.
+	ErrOutsideRoot = errors.New("path is outside the root")
+	// ErrInvalidConflict is returned for an unknown Conflict.
+	ErrInvalidConflict = errors.New("invalid conflict handling")
+)
+
+// Conflict selects what Restore does when the original path exists.
+type Conflict string
+
+const (
+	// ConflictFail returns ErrConflict.
+	ConflictFail Conflict = "fail"
+	// ConflictRename restores next to the existing entry under a free name, e.g. "notes (1).txt".
+	ConflictRename Conflict = "rename"
+	// ConflictReplace moves the existing entry to the trash in its place.
+	ConflictReplace Conflict = "replace"
+)
+
+// expireInterval is how often items older than the retention period are purged.
+var expireInterval = time.Hour
+
+// Item describes an entry in the trash.
+type Item struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Path is where the entry was deleted from, or restored to, slash-separated and relative
+	// to the root.
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// Trash holds the deleted entries of every user.
+type Trash struct {
+	root      string
+	dir       string
+	retention time.Duration
+	logger    *slog.Logger
+	now       func() time.Time
+
+	// mutex serialises changes, so that an item is not restored and purged at once
+	mutex sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// New returns a Trash for entries under root, kept in dir, which is created as needed. If
+// retention is positive, items deleted longer ago are purged in the background until Close
+// is called.
+func New(root, dir string, retention time.Duration, logger *slog.Logger) *Trash {
+	t := &Trash{
+		root:      filepath.Clean(root),
+		dir:       filepath.Clean(dir),
+		retention: retention,
+		logger:    logger,
+		now:       time.Now,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if retention > 0 {
+		go t.run()
+	} else {
+		close(t.done)
+	}
+	return t
+}
+
+// Close stops purging expired items, waiting for a purge in progress to finish.
+func (t *Trash) Close() error {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	<-t.done
+	return nil
+}
+
+func (t *Trash) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := t.Expire(); err != nil {
+			t.logger.Error("Failed to purge expired trash", slog.String("error", err.Error()))
+		} else if n > 0 {
+			t.logger.Info("Purged expired trash", slog.Int("items", n))
+		}
+
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Delete moves the entry at path, an absolute path under the root, into user's trash.
+// Symlinks are moved themselves rather than what they point to.
+func (t *Trash) Delete(user, path string) (Item, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.delete(user, path)
+}
+
+func (t *Trash) delete(user, path string) (Item, error) {
+	path = filepath.Clean(path)
+	if path == t.root || !within(t.root, path) {
+		return Item{}, ErrOutsideRoot
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Item{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Item{}, err
+	}
+	item := Item{
+		ID:      id,
+		Name:    info.Name(),
+		Path:    t.rel(path),
+		Type:    "file",
+		Size:    info.Size(),
+		Deleted: t.now().UTC(),
+	}
+	if info.IsDir() {
+		item.Type = "dir"
+		item.Size = 0
+	}
+
+	userDir := t.userDir(user)
+	if err := os.MkdirAll(userDir, 0700); err != nil {
+		return Item{}, err
+	}
+	// written first, so that anything in the trash can be listed and restored
+	if err := writeItem(filepath.Join(userDir, id+".json"), item); err != nil {
+		return Item{}, err
+	}
+	if err := os.Rename(path, filepath.Join(userDir, id)); err != nil {
+		_ = os.Remove(filepath.Join(userDir, id+".json"))
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// List returns the items in user's trash, most recently deleted first.
+func (t *Trash) List(user string) ([]Item, error) {
+	entries, err := os.ReadDir(t.userDir(user))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Item{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := []Item{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		item, err := t.item(user, id)
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b Item) int {
+		return b.Deleted.Compare(a.Deleted)
+	})
+	return items, nil
+}
+
+// Restore moves the item with id in user's trash back to where it was deleted from,
+// recreating any missing parent directories. It returns the item with the path it was
+// restored to.
+func (t *Trash) Restore(user, id string, conflict Conflict) (Item, error) {
+	if !slices.Contains([]Conflict{ConflictFail, ConflictRename, ConflictReplace}, conflict) {
+		return Item{}, ErrInvalidConflict
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	item, err := t.item(user, id)
+	if err != nil {
+		return Item{}, err
+	}
+	dest := filepath.Join(t.root, filepath.FromSlash(item.Path))
+	if err := t.makeParent(dest); err != nil {
+		return Item{}, err
+	}
+
+	if _, err := os.Lstat(dest); err == nil {
+		switch conflict {
+		case ConflictRename:
+			dest = freeName(dest)
+		case ConflictReplace:
+			if _, err := t.delete(user, dest); err != nil {
+				return Item{}, err
+			}
+		default:
+			return Item{}, ErrConflict
+		}
+	}
+
+	userDir := t.userDir(user)
+	if err := os.Rename(filepath.Join(userDir, id), dest); err != nil {
+		return Item{}, err
+	}
+	if err := os.Remove(filepath.Join(userDir, id+".json")); err != nil {
+		t.logger.Warn("Failed to remove restored trash item", slog.String("id", id), slog.String("error", err.Error()))
+	}
+
+	item.Name = filepath.Base(dest)
+	item.Path = t.rel(dest)
+	return item, nil
+}
+
+// Size returns the total size of the regular files in the item with id in user's trash.
+func (t *Trash) Size(user, id string) (int64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, err := t.item(user, id); err != nil {
+		return 0, err
+	}
+	var size int64
+	err := filepath.WalkDir(filepath.Join(t.userDir(user), id), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// rel returns path relative to the root in slash-separated form.
+func (t *Trash) rel(path string) string {
+	rel, err := filepath.Rel(t.root, path)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// makeParent creates any missing parent directories of dest, once it has checked that the
+// existing ones have not been replaced by a symlink leading out of the root or into the trash.
+func (t *Trash) makeParent(dest string) error {
+	existing := filepath.Dir(dest)
+	for existing != t.root {
+		if _, err := os.Stat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+
+	root, err := filepath.EvalSymlinks(t.root)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.EvalSymlinks(t.dir)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	if !within(root, resolved) || within(dir, resolved) {
+		return ErrOutsideRoot
+	}
+	return os.MkdirAll(filepath.Dir(dest), 0755)
+}
+
+// Purge permanently removes the item with id from user's trash.
+func (t *Trash) Purge(user, id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, err := t.item(user, id); err != nil {
+		return err
+	}
+	return t.purge(user, id)
+}
+
+// Empty permanently removes every item in user's trash, returning how many were removed.
+func (t *Trash) Empty(user string) (int, error) {
+	items, err := t.List(user)
+	if err != nil {
+		return 0, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.purgeItems(user, items, time.Time{})
+}
+
+// Expire permanently removes items deleted longer ago than the retention period from every
+// user's trash, returning how many were removed.
+func (t *Trash) Expire() (int, error) {
+	if t.retention <= 0 {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(t.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := t.now().Add(-t.retention)
+	total := 0
+	for _, entry := range entries {
+		user, err := hex.DecodeString(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		items, err := t.List(string(user))
+		if err != nil {
+			return total, err
+		}
+
+		t.mutex.Lock()
+		n, err := t.purgeItems(string(user), items, cutoff)
+		t.mutex.Unlock()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// purgeItems removes items deleted before cutoff, or all of them if cutoff is zero.
+// The caller must hold t.mutex.
+func (t *Trash) purgeItems(user string, items []Item, cutoff time.Time) (int, error) {
+	n := 0
+	for _, item := range items {
+		if !cutoff.IsZero() && !item.Deleted.Before(cutoff) {
+			continue
+		}
+		if err := t.purge(user, item.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// purge removes an item's entry and then its record. The caller must hold t.mutex.
+func (t *Trash) purge(user, id string) error {
+	userDir := t.userDir(user)
+	if err := os.RemoveAll(filepath.Join(userDir, id)); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(userDir, id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		// already restored or purged since being listed
+		return nil
+	}
+	return err
+}
+
+// item reads the record of the item with id in user's trash.
+func (t *Trash) item(user, id string) (Item, error) {
+	if !validID(id) {
+		return Item{}, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(t.userDir(user), id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Item{}, ErrNotFound
+	}
+	if err != nil {
+		return Item{}, err
+	}
+	var item Item
+	if err := json.Unmarshal(data, &item); err != nil {
+		return Item{}, fmt.Errorf("corrupt trash item %s: %w", id, err)
+	}
+	return item, nil
+}
+
+// userDir returns the directory holding user's trash. Usernames are hex encoded, as they
+// may contain characters that are not allowed in file names.
+func (t *Trash) userDir(user string) string {
+	return filepath.Join(t.dir, hex.EncodeToString([]byte(user)))
+}
+
+// writeItem writes item's record via a temporary file, so that a partly written record is
+// never read.
+func writeItem(path string, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 16
+}
+
+// freeName returns path with " (n)" added before its extension, for the smallest n for
+// which nothing exists.
+func freeName(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			return candidate
+		}
+	}
+}
+
+// within reports whether path is dir or inside it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
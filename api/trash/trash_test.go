@@ -0,0 +1,228 @@
+package trash
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTrash(t *testing.T, retention time.Duration) (*Trash, string) {
+	t.Helper()
+	root := t.TempDir()
+	bin := New(root, filepath.Join(root, ".fs4", "trash"), retention, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() {
+		_ = bin.Close()
+	})
+	return bin, root
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	return string(data)
+}
+
+func TestDeleteRestore(t *testing.T) {
+	bin, root := testTrash(t, 0)
+	writeFile(t, filepath.Join(root, "docs", "notes.txt"), "notes")
+
+	item, err := bin.Delete("alice", filepath.Join(root, "docs"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if item.Name != "docs" || item.Path != "/docs" || item.Type != "dir" {
+		t.Errorf("unexpected item %+v", item)
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected deleted directory to be gone, got %v", err)
+	}
+
+	items, err := bin.List("alice")
+	if err != nil || len(items) != 1 || items[0].ID != item.ID {
+		t.Fatalf("expected the deleted item, got %v (%v)", items, err)
+	}
+	if items, _ := bin.List("bob"); len(items) != 0 {
+		t.Errorf("expected other users' trash to be empty, got %v", items)
+	}
+	if _, err := bin.Restore("bob", item.ID, ConflictFail); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring another user's item, got %v", err)
+	}
+
+	restored, err := bin.Restore("alice", item.ID, ConflictFail)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if restored.Path != "/docs" {
+		t.Errorf("expected item restored to /docs, got %s", restored.Path)
+	}
+	if content := readFile(t, filepath.Join(root, "docs", "notes.txt")); content != "notes" {
+		t.Errorf("expected restored contents, got %q", content)
+	}
+	if items, _ := bin.List("alice"); len(items) != 0 {
+		t.Errorf("expected trash to be empty after restoring, got %v", items)
+	}
+
+	if _, err := bin.Delete("alice", root); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("expected ErrOutsideRoot deleting the root, got %v", err)
+	}
+	if _, err := bin.Delete("alice", filepath.Join(root, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	bin, root := testTrash(t, 0)
+	path := filepath.Join(root, "a", "b", "notes.txt")
+
+	deleteFile := func(content string) Item {
+		t.Helper()
+		writeFile(t, path, content)
+		item, err := bin.Delete("alice", path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return item
+	}
+
+	// missing parents are recreated
+	first := deleteFile("first")
+	if err := os.RemoveAll(filepath.Join(root, "a")); err != nil {
+		t.Fatalf("failed to remove directory: %v", err)
+	}
+	if _, err := bin.Restore("alice", first.ID, ConflictFail); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	second := deleteFile("second")
+	writeFile(t, path, "current")
+	if _, err := bin.Restore("alice", second.ID, ConflictFail); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if _, err := bin.Restore("alice", second.ID, "merge"); !errors.Is(err, ErrInvalidConflict) {
+		t.Errorf("expected ErrInvalidConflict, got %v", err)
+	}
+
+	renamed, err := bin.Restore("alice", second.ID, ConflictRename)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if renamed.Path != "/a/b/notes (1).txt" || renamed.Name != "notes (1).txt" {
+		t.Errorf("expected item restored under a free name, got %+v", renamed)
+	}
+	if content := readFile(t, filepath.Join(root, "a", "b", "notes (1).txt")); content != "second" {
+		t.Errorf("expected restored contents, got %q", content)
+	}
+
+	third := deleteFile("third")
+	writeFile(t, path, "current")
+	if _, err := bin.Restore("alice", third.ID, ConflictReplace); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if content := readFile(t, path); content != "third" {
+		t.Errorf("expected replaced contents, got %q", content)
+	}
+	// the replaced file is in the trash in its place
+	items, _ := bin.List("alice")
+	if len(items) != 1 || items[0].Path != "/a/b/notes.txt" {
+		t.Errorf("expected the replaced file in the trash, got %v", items)
+	}
+}
+
+func TestRestoreOutsideRoot(t *testing.T) {
+	bin, root := testTrash(t, 0)
+	writeFile(t, filepath.Join(root, "dir", "notes.txt"), "notes")
+	item, err := bin.Delete("alice", filepath.Join(root, "dir", "notes.txt"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// the parent directory is replaced by a symlink leading out of the root
+	outside := t.TempDir()
+	if err := os.Remove(filepath.Join(root, "dir")); err != nil {
+		t.Fatalf("failed to remove directory: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if _, err := bin.Restore("alice", item.ID, ConflictFail); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("expected ErrOutsideRoot, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "notes.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected nothing restored outside the root, got %v", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	bin, root := testTrash(t, 0)
+	var ids []string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, filepath.Join(root, name), name)
+		item, err := bin.Delete("alice", filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		ids = append(ids, item.ID)
+	}
+
+	if err := bin.Purge("alice", ids[0]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := bin.Purge("alice", ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound purging twice, got %v", err)
+	}
+	if err := bin.Purge("alice", "../../a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an invalid ID, got %v", err)
+	}
+
+	n, err := bin.Empty("alice")
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 items purged, got %d (%v)", n, err)
+	}
+	entries, err := os.ReadDir(bin.userDir("alice"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("expected an empty trash directory, got %v (%v)", entries, err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	// not purged in the background, which would race with changing the time
+	bin, root := testTrash(t, 0)
+	bin.retention = 24 * time.Hour
+	now := time.Now()
+	for user, age := range map[string]time.Duration{"alice": 48 * time.Hour, "bob": time.Hour} {
+		bin.now = func() time.Time { return now.Add(-age) }
+		writeFile(t, filepath.Join(root, user+".txt"), user)
+		if _, err := bin.Delete(user, filepath.Join(root, user+".txt")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	bin.now = func() time.Time { return now }
+
+	n, err := bin.Expire()
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 item expired, got %d (%v)", n, err)
+	}
+	if items, _ := bin.List("alice"); len(items) != 0 {
+		t.Errorf("expected alice's old item to be purged, got %v", items)
+	}
+	if items, _ := bin.List("bob"); len(items) != 1 {
+		t.Errorf("expected bob's recent item to be kept, got %v", items)
+	}
+}
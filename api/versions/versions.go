@@ -0,0 +1,405 @@
+// Package versions keeps the previous contents of files that are overwritten, so that they
+// can be downloaded or restored.
+//
+// Versions are kept per path, in a directory named after a hash of the path holding each
+// version's contents, named by a random ID, and a JSON file alongside recording when it was
+// replaced. Files are moved into the store rather than copied, so it must be on the same
+// filesystem as the files it versions. Only a limited number of versions are kept per path,
+// and versions older than a maximum age are purged in the background.
+package versions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for IDs that are not versions of the path.
+	ErrNotFound = errors.New("version not found")
+	// ErrNotFile is returned when versioning something other than a regular file.
+	ErrNotFile = errors.New("only regular files are versioned")
+)
+
+// expireInterval is how often versions older than the maximum age are purged.
+var expireInterval = time.Hour
+
+// Retention limits the versions kept.
+type Retention struct {
+	// MaxCount is the number of versions kept per path, the oldest being purged first.
+	MaxCount int
+	// MaxAge is how long versions are kept after being replaced, forever if zero.
+	MaxAge time.Duration
+}
+
+// Version describes a previous content of a file.
+type Version struct {
+	ID string `json:"id"`
+	// Path is the versioned file, slash-separated and relative to the root.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Modified is when the content was last modified, and Replaced when it was overwritten.
+	Modified time.Time `json:"modified"`
+	Replaced time.Time `json:"replaced"`
+}
+
+// Store holds the versions of files under a root.
+type Store struct {
+	root      string
+	dir       string
+	retention Retention
+	logger    *slog.Logger
+	now       func() time.Time
+
+	// mutex serialises changes, so that a version is not restored and purged at once
+	mutex sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// New returns a Store for files under root, kept in dir, which is created as needed. If
+// retention has a maximum age, older versions are purged in the background until Close is
+// called.
+func New(root, dir string, retention Retention, logger *slog.Logger) *Store {
+	s := &Store{
+		root:      filepath.Clean(root),
+		dir:       filepath.Clean(dir),
+		retention: retention,
+		logger:    logger,
+		now:       time.Now,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if retention.MaxAge > 0 {
+		go s.run()
+	} else {
+		close(s.done)
+	}
+	return s
+}
+
+// Close stops purging expired versions, waiting for a purge in progress to finish.
+func (s *Store) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+	return nil
+}
+
+func (s *Store) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.Expire(); err != nil {
+			s.logger.Error("Failed to purge expired versions", slog.String("error", err.Error()))
+		} else if n > 0 {
+			s.logger.Info("Purged expired versions", slog.Int("versions", n))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Replace moves the file at path, an absolute path under the root, into the store as its
+// latest version, and then moves replacement into its place. If path does not exist,
+// replacement is moved there without a version being kept. It reports whether a version
+// was kept.
+func (s *Store) Replace(path, replacement string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.replace(path, replacement)
+}
+
+func (s *Store) replace(path, replacement string) (bool, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, os.Rename(replacement, path)
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		return false, ErrNotFile
+	}
+
+	id, err := newID()
+	if err != nil {
+		return false, err
+	}
+	version := Version{
+		ID:       id,
+		Path:     s.rel(path),
+		Size:     info.Size(),
+		Modified: info.ModTime().UTC(),
+		Replaced: s.now().UTC(),
+	}
+	dir := s.pathDir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return false, err
+	}
+	// written first, so that any content in the store can be listed and purged
+	record := filepath.Join(dir, id+".json")
+	if err := writeVersion(record, version); err != nil {
+		return false, err
+	}
+	if err := os.Rename(path, filepath.Join(dir, id)); err != nil {
+		return false, errors.Join(err, os.Remove(record))
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		// put the original back rather than leave nothing there
+		return false, errors.Join(err, os.Rename(filepath.Join(dir, id), path), os.Remove(record))
+	}
+	if err := s.prune(path); err != nil {
+		// the file has been replaced, so this is not the caller's failure
+		s.logger.Warn("Failed to purge old versions", slog.String("path", version.Path), slog.String("error", err.Error()))
+	}
+	return true, nil
+}
+
+// List returns the versions of the file at path, most recently replaced first.
+func (s *Store) List(path string) ([]Version, error) {
+	entries, err := os.ReadDir(s.pathDir(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Version{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []Version{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		version, err := s.version(path, id)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	slices.SortFunc(versions, func(a, b Version) int {
+		return b.Replaced.Compare(a.Replaced)
+	})
+	return versions, nil
+}
+
+// Count returns the number of versions of the file at path, zero if they cannot be read.
+func (s *Store) Count(path string) int {
+	entries, err := os.ReadDir(s.pathDir(path))
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && validID(id) {
+			n++
+		}
+	}
+	return n
+}
+
+// Open opens the content of the version of the file at path with id.
+func (s *Store) Open(path, id string) (*os.File, Version, error) {
+	version, err := s.version(path, id)
+	if err != nil {
+		return nil, Version{}, err
+	}
+	f, err := os.Open(filepath.Join(s.pathDir(path), id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Version{}, ErrNotFound
+	}
+	return f, version, err
+}
+
+// Restore makes the version of the file at path with id its current content. The content it
+// replaces is kept as the latest version, so a restore can itself be undone.
+func (s *Store) Restore(path, id string) (Version, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	version, err := s.version(path, id)
+	if err != nil {
+		return Version{}, err
+	}
+	dir := s.pathDir(path)
+	// the record is removed first, so the version is not counted against the limit when the
+	// current content is kept
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil {
+		return Version{}, err
+	}
+	if _, err := s.replace(path, filepath.Join(dir, id)); err != nil {
+		return Version{}, errors.Join(err, writeVersion(filepath.Join(dir, id+".json"), version))
+	}
+	return version, nil
+}
+
+// prune purges the oldest versions of the file at path beyond the maximum count. The
+// caller must hold s.mutex.
+func (s *Store) prune(path string) error {
+	versions, err := s.List(path)
+	if err != nil || len(versions) <= s.retention.MaxCount {
+		return err
+	}
+	for _, version := range versions[s.retention.MaxCount:] {
+		if err := s.purge(s.pathDir(path), version.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expire purges versions replaced longer ago than the maximum age, returning how many were
+// purged.
+func (s *Store) Expire() (int, error) {
+	if s.retention.MaxAge <= 0 {
+		return 0, nil
+	}
+	dirs, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := s.now().Add(-s.retention.MaxAge)
+	total := 0
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		n, err := s.expireDir(filepath.Join(s.dir, d.Name()), cutoff)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// expireDir purges the versions in dir replaced before cutoff, and dir itself once empty.
+func (s *Store) expireDir(dir string, cutoff time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		version, err := readVersion(filepath.Join(dir, entry.Name()))
+		if err != nil || !version.Replaced.Before(cutoff) {
+			continue
+		}
+		if err := s.purge(dir, id); err != nil {
+			return n, err
+		}
+		n++
+	}
+	// fails unless every version was purged
+	_ = os.Remove(dir)
+	return n, nil
+}
+
+// purge removes a version's record and then its content. The caller must hold s.mutex.
+func (s *Store) purge(dir, id string) error {
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err := os.Remove(filepath.Join(dir, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// version reads the record of the version of the file at path with id.
+func (s *Store) version(path, id string) (Version, error) {
+	if !validID(id) {
+		return Version{}, ErrNotFound
+	}
+	version, err := readVersion(filepath.Join(s.pathDir(path), id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Version{}, ErrNotFound
+	}
+	return version, err
+}
+
+// pathDir returns the directory holding the versions of the file at path.
+func (s *Store) pathDir(path string) string {
+	sum := sha256.Sum256([]byte(s.rel(path)))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// rel returns path relative to the root in slash-separated form.
+func (s *Store) rel(path string) string {
+	rel, err := filepath.Rel(s.root, filepath.Clean(path))
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+func readVersion(path string) (Version, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Version{}, err
+	}
+	var version Version
+	if err := json.Unmarshal(data, &version); err != nil {
+		return Version{}, fmt.Errorf("corrupt version %s: %w", filepath.Base(path), err)
+	}
+	return version, nil
+}
+
+// writeVersion writes a version's record via a temporary file, so that a partly written
+// record is never read.
+func writeVersion(path string, version Version) error {
+	data, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 16
+}
@@ -0,0 +1,152 @@
+package versions
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, retention Retention) (*Store, string) {
+	t.Helper()
+	root := t.TempDir()
+	s := New(root, filepath.Join(root, ".fs4", "versions"), retention, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s, root
+}
+
+// upload replaces the file at path with content, as an upload would.
+func upload(t *testing.T, s *Store, path, content string) bool {
+	t.Helper()
+	tmp := filepath.Join(filepath.Dir(path), ".upload")
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	kept, err := s.Replace(path, tmp)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return kept
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	return string(data)
+}
+
+func TestReplace(t *testing.T) {
+	s, root := testStore(t, Retention{MaxCount: 2})
+	path := filepath.Join(root, "notes.txt")
+
+	if upload(t, s, path, "one") {
+		t.Error("expected no version kept for a new file")
+	}
+	for _, content := range []string{"two", "three", "four"} {
+		if !upload(t, s, path, content) {
+			t.Errorf("expected a version kept replacing with %s", content)
+		}
+	}
+	if content := readFile(t, path); content != "four" {
+		t.Errorf("expected current content four, got %q", content)
+	}
+
+	// only the two most recent are kept
+	versions, err := s.List(path)
+	if err != nil || len(versions) != 2 || s.Count(path) != 2 {
+		t.Fatalf("expected 2 versions, got %v (%v)", versions, err)
+	}
+	for i, expected := range []string{"three", "two"} {
+		f, version, err := s.Open(path, versions[i].ID)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		data, _ := io.ReadAll(f)
+		_ = f.Close()
+		if string(data) != expected || version.Path != "/notes.txt" || version.Size != int64(len(expected)) {
+			t.Errorf("expected version %d to be %s, got %q %+v", i, expected, data, version)
+		}
+	}
+
+	if _, _, err := s.Open(path, "../../notes.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an invalid ID, got %v", err)
+	}
+	if versions, _ := s.List(filepath.Join(root, "other.txt")); len(versions) != 0 {
+		t.Errorf("expected no versions of another file, got %v", versions)
+	}
+
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if _, err := s.Replace(filepath.Join(root, "dir"), path); !errors.Is(err, ErrNotFile) {
+		t.Errorf("expected ErrNotFile replacing a directory, got %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s, root := testStore(t, Retention{MaxCount: 5})
+	path := filepath.Join(root, "notes.txt")
+	upload(t, s, path, "one")
+	upload(t, s, path, "two")
+	versions, _ := s.List(path)
+
+	restored, err := s.Restore(path, versions[0].ID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if restored.ID != versions[0].ID {
+		t.Errorf("expected version %s restored, got %s", versions[0].ID, restored.ID)
+	}
+	if content := readFile(t, path); content != "one" {
+		t.Errorf("expected restored content one, got %q", content)
+	}
+
+	// the replaced content is kept, and the restored version is no longer listed
+	versions, _ = s.List(path)
+	if len(versions) != 1 || versions[0].Size != 3 {
+		t.Fatalf("expected the replaced content as the only version, got %v", versions)
+	}
+	f, _, err := s.Open(path, versions[0].ID)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	_ = f.Close()
+	if string(data) != "two" {
+		t.Errorf("expected version two, got %q", data)
+	}
+
+	if _, err := s.Restore(path, restored.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring twice, got %v", err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	// not purged in the background, which would race with changing the time
+	s, root := testStore(t, Retention{MaxCount: 5})
+	s.retention.MaxAge = 24 * time.Hour
+	now := time.Now()
+	path := filepath.Join(root, "notes.txt")
+	upload(t, s, path, "one")
+	s.now = func() time.Time { return now.Add(-48 * time.Hour) }
+	upload(t, s, path, "second")
+	s.now = func() time.Time { return now.Add(-time.Hour) }
+	upload(t, s, path, "third")
+	s.now = func() time.Time { return now }
+
+	n, err := s.Expire()
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 version expired, got %d (%v)", n, err)
+	}
+	if versions, _ := s.List(path); len(versions) != 1 || versions[0].Size != 6 {
+		t.Errorf("expected the recent version to be kept, got %v", versions)
+	}
+}
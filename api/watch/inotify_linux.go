@@ -0,0 +1,199 @@
+package watch
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the changes reported for each watched directory.
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+// inotify watches directories with the Linux inotify API.
+type inotify struct {
+	emit emitFunc
+	file *os.File
+	fd   int
+
+	mutex sync.Mutex
+	// paths of watched directories by watch descriptor; one directory reached through
+	// different paths, e.g. via a symlink, shares a descriptor
+	paths  map[int32][]string
+	wds    map[string]int32
+	closed bool
+}
+
+func newNativeBackend(emit emitFunc) (backend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotify{
+		emit: emit,
+		// a non-blocking descriptor is read through the runtime poller, so closing it
+		// interrupts a pending read
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		fd:    fd,
+		paths: map[int32][]string{},
+		wds:   map[string]int32{},
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *inotify) add(dir string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	w.paths[int32(wd)] = append(w.paths[int32(wd)], dir)
+	w.wds[dir] = int32(wd)
+	return nil
+}
+
+func (w *inotify) remove(dir string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	wd, ok := w.wds[dir]
+	if !ok {
+		return
+	}
+	delete(w.wds, dir)
+	w.paths[wd] = removeName(w.paths[wd], dir)
+	if len(w.paths[wd]) == 0 {
+		delete(w.paths, wd)
+		if !w.closed {
+			// fails if the directory was already removed, which also removed the watch
+			_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+		}
+	}
+}
+
+func (w *inotify) close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.closed = true
+	return w.file.Close()
+}
+
+func (w *inotify) run() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// not expected to recover, and subscribers can no longer rely on events
+			w.emit("", Event{Op: OpResync})
+			return
+		}
+		w.handle(buf[:n])
+	}
+}
+
+// rawEvent is an inotify event before it is delivered.
+type rawEvent struct {
+	wd     int32
+	cookie uint32
+	event  Event
+	// self is set if the watched directory itself was removed
+	self bool
+}
+
+// handle parses and delivers the events in buf. A move within a directory is reported by
+// inotify as a pair of events sharing a cookie, which are merged into a single rename.
+func (w *inotify) handle(buf []byte) {
+	var events []rawEvent
+	for len(buf) >= unix.SizeofInotifyEvent {
+		wd := int32(binary.NativeEndian.Uint32(buf[0:]))
+		mask := binary.NativeEndian.Uint32(buf[4:])
+		cookie := binary.NativeEndian.Uint32(buf[8:])
+		size := int(binary.NativeEndian.Uint32(buf[12:]))
+		if len(buf) < unix.SizeofInotifyEvent+size {
+			break
+		}
+		name := strings.TrimRight(string(buf[unix.SizeofInotifyEvent:unix.SizeofInotifyEvent+size]), "\x00")
+		buf = buf[unix.SizeofInotifyEvent+size:]
+
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			w.emit("", Event{Op: OpResync})
+			continue
+		}
+		if mask&unix.IN_MOVED_TO != 0 && renamed(events, wd, cookie, name) {
+			continue
+		}
+		if op, ok := inotifyOp(mask, name); ok {
+			self := mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0
+			events = append(events, rawEvent{wd: wd, cookie: cookie, event: Event{Op: op, Name: name}, self: self})
+		}
+	}
+
+	for _, e := range events {
+		for _, dir := range w.watchPaths(e.wd, e.self) {
+			w.emit(dir, e.event)
+		}
+	}
+}
+
+// watchPaths returns the paths watched with wd. If forget is set, the watch is removed,
+// as it no longer matches those paths.
+func (w *inotify) watchPaths(wd int32, forget bool) []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	paths := w.paths[wd]
+	if forget {
+		for _, path := range paths {
+			delete(w.wds, path)
+		}
+		delete(w.paths, wd)
+		// a moved directory is still watched at its new location
+		_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+	}
+	return paths
+}
+
+// renamed turns the delete for a move out of directory wd with cookie into a rename to name.
+// It reports whether such a move was found.
+func renamed(events []rawEvent, wd int32, cookie uint32, name string) bool {
+	for i := range events {
+		e := &events[i]
+		if e.wd == wd && e.cookie == cookie && cookie != 0 && e.event.Op == OpDelete {
+			e.event = Event{Op: OpRename, Name: name, OldName: e.event.Name}
+			return true
+		}
+	}
+	return false
+}
+
+// inotifyOp returns the operation for an inotify event mask and entry name. Events for the
+// watched directory itself, which have no name, are ignored other than its removal.
+func inotifyOp(mask uint32, name string) (Op, bool) {
+	switch {
+	case mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+		return OpDelete, true
+	case name == "":
+		return "", false
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		return OpCreate, true
+	case mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		return OpDelete, true
+	case mask&(unix.IN_MODIFY|unix.IN_ATTRIB) != 0:
+		return OpModify, true
+	default:
+		return "", false
+	}
+}
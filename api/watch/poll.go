@@ -0,0 +1,185 @@
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// poller detects changes by periodically listing directories and comparing the results.
+// Renames are recognised by the renamed entry being the same file before and after.
+type poller struct {
+	emit     emitFunc
+	interval time.Duration
+
+	mutex sync.Mutex
+	dirs  map[string]map[string]fs.FileInfo
+	stop  chan struct{}
+}
+
+func newPoller(emit emitFunc, interval time.Duration) *poller {
+	p := &poller{
+		emit:     emit,
+		interval: interval,
+		dirs:     map[string]map[string]fs.FileInfo{},
+		stop:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *poller) add(dir string) error {
+	entries, err := snapshot(dir)
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dirs[dir] = entries
+	return nil
+}
+
+func (p *poller) remove(dir string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.dirs, dir)
+}
+
+func (p *poller) close() error {
+	close(p.stop)
+	return nil
+}
+
+func (p *poller) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+// poll rescans every directory, emitting events without holding the lock.
+func (p *poller) poll() {
+	p.mutex.Lock()
+	dirs := make(map[string]map[string]fs.FileInfo, len(p.dirs))
+	for dir, entries := range p.dirs {
+		dirs[dir] = entries
+	}
+	p.mutex.Unlock()
+
+	for dir, previous := range dirs {
+		current, err := snapshot(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			// like a native watch, stop watching a removed directory
+			p.remove(dir)
+			p.emit(dir, Event{Op: OpDelete})
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		p.mutex.Lock()
+		_, watched := p.dirs[dir]
+		if watched {
+			p.dirs[dir] = current
+		}
+		p.mutex.Unlock()
+		if !watched {
+			continue
+		}
+		for _, e := range diff(previous, current) {
+			p.emit(dir, e)
+		}
+	}
+}
+
+// snapshot returns the entries of dir by name.
+func snapshot(dir string) (map[string]fs.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]fs.FileInfo, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// removed since it was listed
+			continue
+		}
+		infos[entry.Name()] = info
+	}
+	return infos, nil
+}
+
+// diff returns the events turning previous into current, in name order.
+func diff(previous, current map[string]fs.FileInfo) []Event {
+	var created, deleted []string
+	var events []Event
+	for _, name := range sortedNames(current) {
+		old, ok := previous[name]
+		if !ok {
+			created = append(created, name)
+		} else if changed(old, current[name]) {
+			events = append(events, Event{Op: OpModify, Name: name})
+		}
+	}
+	for _, name := range sortedNames(previous) {
+		if _, ok := current[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+
+	for _, name := range created {
+		if oldName := renamedFrom(current[name], previous, deleted); oldName != "" {
+			events = append(events, Event{Op: OpRename, Name: name, OldName: oldName})
+			deleted = removeName(deleted, oldName)
+			continue
+		}
+		events = append(events, Event{Op: OpCreate, Name: name})
+	}
+	for _, name := range deleted {
+		events = append(events, Event{Op: OpDelete, Name: name})
+	}
+	return events
+}
+
+func changed(old, current fs.FileInfo) bool {
+	return !old.ModTime().Equal(current.ModTime()) || old.Size() != current.Size() || old.Mode() != current.Mode()
+}
+
+// renamedFrom returns the name of the deleted entry that info was previously known as, if any.
+func renamedFrom(info fs.FileInfo, previous map[string]fs.FileInfo, deleted []string) string {
+	for _, name := range deleted {
+		if os.SameFile(previous[name], info) {
+			return name
+		}
+	}
+	return ""
+}
+
+func removeName(names []string, name string) []string {
+	for i := range names {
+		if names[i] == name {
+			return append(names[:i], names[i+1:]...)
+		}
+	}
+	return names
+}
+
+func sortedNames(infos map[string]fs.FileInfo) []string {
+	names := make([]string, 0, len(infos))
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
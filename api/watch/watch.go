@@ -0,0 +1,310 @@
+// Package watch notifies subscribers of changes to directories under the served root.
+package watch
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Op is the kind of change to a directory entry.
+type Op string
+
+const (
+	// OpCreate is sent when an entry is added to a directory.
+	OpCreate Op = "create"
+	// OpModify is sent when an entry's contents or attributes change.
+	OpModify Op = "modify"
+	// OpDelete is sent when an entry is removed from a directory. An empty name means the
+	// watched directory itself was removed, after which no further events are sent for it.
+	OpDelete Op = "delete"
+	// OpRename is sent when an entry is renamed within a directory.
+	OpRename Op = "rename"
+	// OpResync is sent when events were lost, e.g. because the subscriber fell behind.
+	// The directory should be listed again.
+	OpResync Op = "resync"
+)
+
+// Event describes a change to a watched directory.
+type Event struct {
+	Op Op `json:"op"`
+	// Dir is the watched directory, slash-separated and relative to the root.
+	Dir  string `json:"dir"`
+	Name string `json:"name,omitempty"`
+	// OldName is the previous name of a renamed entry.
+	OldName string `json:"oldName,omitempty"`
+}
+
+// ErrClosed is returned when subscribing to a closed Hub.
+var ErrClosed = errors.New("watcher is closed")
+
+// pollInterval is how often directories are rescanned when native watching is unavailable.
+const pollInterval = 2 * time.Second
+
+// maxQueued is the number of undelivered events a subscription holds before they are
+// replaced by a resync event.
+const maxQueued = 256
+
+// backend reports changes to directories, calling emit with the absolute path of the
+// changed directory, or an empty path if events for all directories were lost.
+type backend interface {
+	add(dir string) error
+	remove(dir string)
+	close() error
+}
+
+type emitFunc func(dir string, e Event)
+
+// Hub watches directories on behalf of subscribers, sharing a single watch between all
+// subscribers of a directory. Changes are detected with the platform's native file
+// notifications where available, falling back to polling otherwise.
+type Hub struct {
+	root         string
+	logger       *slog.Logger
+	pollInterval time.Duration
+
+	mutex   sync.Mutex
+	native  backend
+	started bool
+	poller  *poller
+	owners  map[string]backend
+	subs    map[string]map[*Subscription]bool
+	closed  bool
+}
+
+// NewHub returns a Hub for directories under root. Nothing is watched until the first
+// subscription is made.
+func NewHub(root string, logger *slog.Logger) *Hub {
+	return &Hub{
+		root:         filepath.Clean(root),
+		logger:       logger,
+		pollInterval: pollInterval,
+		owners:       map[string]backend{},
+		subs:         map[string]map[*Subscription]bool{},
+	}
+}
+
+// Subscribe starts delivering events for dirs, which must be absolute paths of directories
+// under the root. The subscription must be closed once it is no longer needed.
+func (h *Hub) Subscribe(dirs []string) (*Subscription, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+
+	s := &Subscription{
+		hub:   h,
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		if len(h.subs[dir]) == 0 {
+			if err := h.watch(dir); err != nil {
+				h.unsubscribe(s)
+				return nil, err
+			}
+			h.subs[dir] = map[*Subscription]bool{}
+		}
+		h.subs[dir][s] = true
+		s.dirs = append(s.dirs, dir)
+	}
+	return s, nil
+}
+
+// watch starts watching dir with the native backend, or the poller if that fails.
+func (h *Hub) watch(dir string) error {
+	if !h.started {
+		h.started = true
+		native, err := newNativeBackend(h.emit)
+		if err != nil {
+			h.logger.Warn("Native file watching unavailable, polling for changes", "error", err)
+		}
+		h.native = native
+	}
+
+	if h.native != nil {
+		err := h.native.add(dir)
+		if err == nil {
+			h.owners[dir] = h.native
+			return nil
+		}
+		h.logger.Warn("Could not watch directory, polling for changes", "dir", dir, "error", err)
+	}
+
+	if h.poller == nil {
+		h.poller = newPoller(h.emit, h.pollInterval)
+	}
+	if err := h.poller.add(dir); err != nil {
+		return err
+	}
+	h.owners[dir] = h.poller
+	return nil
+}
+
+// unsubscribe removes s from all its directories, releasing watches no longer needed.
+// The caller must hold h.mutex.
+func (h *Hub) unsubscribe(s *Subscription) {
+	for _, dir := range s.dirs {
+		delete(h.subs[dir], s)
+		if len(h.subs[dir]) > 0 {
+			continue
+		}
+		delete(h.subs, dir)
+		if owner, ok := h.owners[dir]; ok {
+			owner.remove(dir)
+			delete(h.owners, dir)
+		}
+	}
+	s.dirs = nil
+}
+
+func (h *Hub) emit(dir string, e Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if dir == "" {
+		for dir, subs := range h.subs {
+			for s := range subs {
+				s.push(Event{Op: OpResync, Dir: h.rel(dir)})
+			}
+		}
+		return
+	}
+
+	e.Dir = h.rel(dir)
+	for s := range h.subs[dir] {
+		s.push(e)
+	}
+	if e.Op == OpDelete && e.Name == "" {
+		h.release(dir)
+	}
+}
+
+// release forgets dir once it has been removed, as its backend no longer watches it. The
+// caller must hold h.mutex.
+func (h *Hub) release(dir string) {
+	for s := range h.subs[dir] {
+		s.dirs = removeName(s.dirs, dir)
+	}
+	delete(h.subs, dir)
+	delete(h.owners, dir)
+}
+
+// rel returns dir relative to the root in slash-separated form.
+func (h *Hub) rel(dir string) string {
+	rel, err := filepath.Rel(h.root, dir)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// Close stops all watches and ends every subscription, see Subscription.Done.
+func (h *Hub) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+
+	for _, subs := range h.subs {
+		for s := range subs {
+			s.end()
+		}
+	}
+	h.subs = map[string]map[*Subscription]bool{}
+	h.owners = map[string]backend{}
+
+	var err error
+	if h.native != nil {
+		err = h.native.close()
+	}
+	if h.poller != nil {
+		err = errors.Join(err, h.poller.close())
+	}
+	return err
+}
+
+// Subscription receives events for a set of directories.
+type Subscription struct {
+	hub  *Hub
+	dirs []string
+
+	mutex sync.Mutex
+	queue []Event
+	ready chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// Ready returns a channel that receives a value when events are waiting to be read with Events.
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Done returns a channel that is closed when the subscription ends, either because it was
+// closed or because the Hub was.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Events returns and removes the waiting events, oldest first.
+func (s *Subscription) Events() []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events := s.queue
+	s.queue = nil
+	return events
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	s.hub.unsubscribe(s)
+	s.hub.mutex.Unlock()
+	s.end()
+}
+
+func (s *Subscription) end() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// push queues e, merging it with an identical waiting modify event. If the subscriber has
+// fallen too far behind, the queue is replaced with a resync event for each directory.
+func (s *Subscription) push(e Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e.Op == OpModify {
+		for _, queued := range s.queue {
+			if queued == e {
+				return
+			}
+		}
+	}
+	if len(s.queue) >= maxQueued {
+		s.queue = s.queue[:0]
+		for _, dir := range s.dirs {
+			s.queue = append(s.queue, Event{Op: OpResync, Dir: s.hub.rel(dir)})
+		}
+	}
+	s.queue = append(s.queue, e)
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
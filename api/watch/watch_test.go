@@ -0,0 +1,171 @@
+package watch
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// expect waits for sub to receive want, skipping any other events.
+func expect(t *testing.T, sub *Subscription, want Event) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-sub.Ready():
+			for _, e := range sub.Events() {
+				if e == want {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("expected event %+v", want)
+		}
+	}
+}
+
+func testHub(t *testing.T, root string, polling bool) *Hub {
+	t.Helper()
+	h := NewHub(root, slog.Default())
+	if polling {
+		h.started = true
+		h.pollInterval = 10 * time.Millisecond
+	}
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+func TestHub(t *testing.T) {
+	backends := map[string]bool{"polling": true}
+	if runtime.GOOS == "linux" {
+		backends["inotify"] = false
+	}
+
+	for name, polling := range backends {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "photos")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatalf("failed to create directory: %v", err)
+			}
+
+			h := testHub(t, root, polling)
+			sub, err := h.Subscribe([]string{dir})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			defer sub.Close()
+
+			file := filepath.Join(dir, "a.jpg")
+			if err := os.WriteFile(file, nil, 0600); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+			expect(t, sub, Event{Op: OpCreate, Dir: "/photos", Name: "a.jpg"})
+
+			if err := os.WriteFile(file, []byte("changed"), 0600); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			expect(t, sub, Event{Op: OpModify, Dir: "/photos", Name: "a.jpg"})
+
+			if err := os.Rename(file, filepath.Join(dir, "b.jpg")); err != nil {
+				t.Fatalf("failed to rename file: %v", err)
+			}
+			expect(t, sub, Event{Op: OpRename, Dir: "/photos", Name: "b.jpg", OldName: "a.jpg"})
+
+			if err := os.Remove(filepath.Join(dir, "b.jpg")); err != nil {
+				t.Fatalf("failed to remove file: %v", err)
+			}
+			expect(t, sub, Event{Op: OpDelete, Dir: "/photos", Name: "b.jpg"})
+
+			if err := os.Remove(dir); err != nil {
+				t.Fatalf("failed to remove directory: %v", err)
+			}
+			expect(t, sub, Event{Op: OpDelete, Dir: "/photos"})
+			h.mutex.Lock()
+			if len(h.owners) != 0 || len(h.subs) != 0 {
+				t.Errorf("expected the removed directory to be released, got %d watches", len(h.owners))
+			}
+			h.mutex.Unlock()
+		})
+	}
+}
+
+func TestHubSharedWatch(t *testing.T) {
+	root := t.TempDir()
+	h := testHub(t, root, false)
+
+	first, err := h.Subscribe([]string{root})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	second, err := h.Subscribe([]string{root, root + "/"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(h.owners) != 1 {
+		t.Fatalf("expected one watch, got %d", len(h.owners))
+	}
+
+	first.Close()
+	select {
+	case <-first.Done():
+	default:
+		t.Error("expected closed subscription to be done")
+	}
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	expect(t, second, Event{Op: OpCreate, Dir: "/", Name: "file"})
+
+	second.Close()
+	if len(h.owners) != 0 || len(h.subs) != 0 {
+		t.Errorf("expected watch to be released, got %d", len(h.owners))
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	root := t.TempDir()
+	h := testHub(t, root, false)
+
+	sub, err := h.Subscribe([]string{root})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("expected subscription to end when the hub is closed")
+	}
+	sub.Close()
+
+	if _, err := h.Subscribe([]string{root}); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestSubscriptionQueue(t *testing.T) {
+	root := t.TempDir()
+	h := testHub(t, root, false)
+	sub := &Subscription{hub: h, dirs: []string{root}, ready: make(chan struct{}, 1), done: make(chan struct{})}
+
+	modify := Event{Op: OpModify, Dir: "/", Name: "log.txt"}
+	sub.push(modify)
+	sub.push(modify)
+	if events := sub.Events(); len(events) != 1 {
+		t.Errorf("expected repeated modify events to be merged, got %v", events)
+	}
+
+	for i := 0; i <= maxQueued; i++ {
+		sub.push(Event{Op: OpCreate, Dir: "/", Name: "file"})
+	}
+	events := sub.Events()
+	if len(events) != 2 || events[0] != (Event{Op: OpResync, Dir: "/"}) {
+		t.Errorf("expected a resync after overflowing, got %d events starting with %v", len(events), events[0])
+	}
+}
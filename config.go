@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/goteleport-interview/fs4/api/config"
+)
+
+// bindFlags registers command line flags that override cfg.
+// Flags default to the values already in cfg, so unset flags leave it unchanged.
+func bindFlags(fs *flag.FlagSet, cfg *config.Config, configPath *string) {
+	fs.StringVar(configPath, "config", os.Getenv("FS4_CONFIG"), "location of YAML config file")
+	fs.IntVar(&cfg.Server.Port, "p", cfg.Server.Port, "port to listen on")
+	fs.StringVar(&cfg.Server.Root, "d", cfg.Server.Root, "directory to serve files from")
+	fs.BoolVar(&cfg.Server.Production, "production", cfg.Server.Production, "run in production mode, disabling CORS")
+	fs.BoolVar(&cfg.Server.HTTP3, "http3", cfg.Server.HTTP3, "also serve HTTP/3 over QUIC on the same UDP port")
+	fs.StringVar(&cfg.Server.RedirectListen, "redirect-addr", cfg.Server.RedirectListen, "plain HTTP address redirecting to HTTPS, empty to disable")
+	fs.StringVar(&cfg.Server.Listen, "listen", cfg.Server.Listen, "main listener: empty for TCP on -p, unix:<path> or systemd")
+	fs.BoolVar(&cfg.Server.Plaintext, "plaintext", cfg.Server.Plaintext, "serve plain HTTP behind a proxy that terminates TLS")
+	fs.DurationVar(&cfg.Server.DrainTimeout, "drain-timeout", cfg.Server.DrainTimeout, "how long to wait for in-flight requests to finish on shutdown")
+	fs.DurationVar(&cfg.Server.DrainDelay, "drain-delay", cfg.Server.DrainDelay, "how long to report not ready on shutdown before closing listeners")
+	fs.StringVar(&cfg.TLS.Mode, "tls-mode", cfg.TLS.Mode, "where the TLS certificate comes from: files, self-signed or acme")
+	fs.StringVar(&cfg.TLS.Cert, "cert", cfg.TLS.Cert, "location of cert file in files mode")
+	fs.StringVar(&cfg.TLS.Key, "key", cfg.TLS.Key, "location of key file in files mode")
+	fs.StringVar(&cfg.TLS.ClientAuth, "client-auth", cfg.TLS.ClientAuth, "client certificate authentication: none, optional or require")
+	fs.StringVar(&cfg.TLS.ClientCA, "client-ca", cfg.TLS.ClientCA, "location of CA file trusted to issue client certificates")
+	fs.StringVar(&cfg.Storage.AuditLog, "audit-log", cfg.Storage.AuditLog, "location of audit log file, empty to disable")
+	fs.Int64Var(&cfg.Storage.AuditMaxSize, "audit-max-size", cfg.Storage.AuditMaxSize, "size in bytes at which the audit log is rotated")
+	fs.IntVar(&cfg.Storage.AuditMaxBackups, "audit-max-backups", cfg.Storage.AuditMaxBackups, "number of rotated audit log files to keep")
+	fs.StringVar(&cfg.Logging.Format, "log-format", cfg.Logging.Format, "log output format, either text or json")
+	fs.StringVar(&cfg.Metrics.Listen, "metrics-addr", cfg.Metrics.Listen, "address to serve Prometheus metrics on, empty to disable")
+	fs.StringVar(&cfg.Metrics.Token, "metrics-token", cfg.Metrics.Token, "bearer token required to scrape metrics, empty for unauthenticated access")
+}
+
+// loadConfig resolves the configuration from defaults, the config file, the environment and
+// finally the command line flags in args, and validates the result.
+func loadConfig(name string, args []string, output io.Writer) (*config.Config, error) {
+	// parse once to find the config file, then again to apply flags on top of it
+	var configPath string
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	bindFlags(fs, config.Default(), &configPath)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	bindFlags(fs, cfg, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configCommand implements `fs4 config <subcommand>` and returns the process exit code.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: fs4 config check [flags]")
+		return 2
+	}
+
+	_, err := loadConfig("fs4 config check", args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println("configuration OK")
+	return 0
+}
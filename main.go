@@ -2,16 +2,29 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/goteleport-interview/fs4/api"
+	"github.com/goteleport-interview/fs4/api/audit"
 	"github.com/goteleport-interview/fs4/api/auth"
+	"github.com/goteleport-interview/fs4/api/config"
+	"github.com/goteleport-interview/fs4/api/dirsize"
+	"github.com/goteleport-interview/fs4/api/thumbnail"
+	"github.com/goteleport-interview/fs4/api/versions"
 )
 
 var testUsers = map[string]string{
@@ -19,67 +32,298 @@ var testUsers = map[string]string{
 	"user":  "password123",
 }
 
+var testAdmins = []string{"admin"}
+
+// commands are run instead of the server when named by the first argument, e.g. `fs4 config check`.
+var commands = map[string]func(args []string) int{
+	"config": configCommand,
+	"quota":  quotaCommand,
+}
+
 //go:embed web/build
 var assets embed.FS
 
 func main() {
-	var listenPort int
-	var baseDir string
-	var certFileLoc string
-	var keyFileLoc string
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 
-	flag.IntVar(&listenPort, "p", 8081, "port to listen on, default 8081")
-	flag.StringVar(&baseDir, "d", "./files/", "directory to serve files from, default ./files/")
-	flag.StringVar(&certFileLoc, "cert", "api/certs/localhost.pem", "location of cert file")
-	flag.StringVar(&keyFileLoc, "key", "api/certs/localhost-key.pem", "location of key file")
+	cfg, err := loadConfig(os.Args[0], os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	flag.Parse()
+	logger, err := newLogger(cfg.Logging.Format)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	slog.SetDefault(logger)
 
-	cert, err := os.ReadFile(certFileLoc)
+	certs := &certificates{}
+	if !cfg.Server.Plaintext {
+		certs, err = newCertificates(cfg.TLS, logger)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	s, err := newServer(cfg, certs, logger)
 	if err != nil {
-		log.Fatalln("Could not read cert", err)
+		log.Fatalln(err)
 	}
 
-	key, err := os.ReadFile(keyFileLoc)
+	os.Exit(run(s, cfg, certs, logger))
+}
+
+// newServer creates the API server for the configuration.
+func newServer(cfg *config.Config, certs *certificates, logger *slog.Logger) (*api.Server, error) {
+	webassets, err := fs.Sub(assets, "web/build")
 	if err != nil {
-		log.Fatalln("Could not read key", err)
+		return nil, fmt.Errorf("could not embed webassets: %w", err)
 	}
 
-	tlsCert, err := tls.X509KeyPair(cert, key)
+	authBackend, err := newAuthBackend(cfg.Auth)
 	if err != nil {
-		log.Fatalln("Could not parse TLS keypair", err)
+		return nil, err
 	}
 
-	webassets, err := fs.Sub(assets, "web/build")
+	opts, err := serverOptions(cfg, certs, logger)
 	if err != nil {
-		log.Fatalln("Could not embed webassets", err)
+		return nil, err
+	}
+	if cfg.Storage.AuditLog != "" {
+		auditLog, err := audit.NewLogger(cfg.Storage.AuditLog, cfg.Storage.AuditMaxSize, cfg.Storage.AuditMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, api.WithAuditLog(auditLog))
+		log.Printf("Writing audit log to %s\n", cfg.Storage.AuditLog)
 	}
+	if cfg.Thumbnails.CacheDir != "" {
+		thumbnails, err := thumbnail.New(thumbnail.Options{
+			CacheDir:  cfg.Thumbnails.CacheDir,
+			Workers:   cfg.Thumbnails.Workers,
+			MaxPixels: cfg.Thumbnails.MaxPixels,
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, api.WithThumbnails(thumbnails))
+	}
+
+	return api.NewServer(webassets, cfg.Server.Root, authBackend, opts...)
+}
 
-	// check baseDir is dir and exists
-	dirInfo, err := os.Stat(baseDir)
+// serverOptions translates the configuration into server options.
+func serverOptions(cfg *config.Config, certs *certificates, logger *slog.Logger) ([]api.Option, error) {
+	cipherSuites, err := cfg.TLS.CipherSuiteIDs()
 	if err != nil {
-		log.Fatalf("Could not stat directory: %s\n", err)
+		return nil, err
 	}
-	if !dirInfo.IsDir() {
-		log.Fatalf("Provided path is not a directory: %s\n", baseDir)
+	clientCAs, err := cfg.TLS.ClientCAPool()
+	if err != nil {
+		return nil, err
+	}
+	trustedProxies, err := cfg.Server.TrustedProxyPrefixes()
+	if err != nil {
+		return nil, err
 	}
 
-	authBackend := auth.NewInMemoryBackend()
+	corsOptions := api.CORSOptions{
+		AllowedOrigins: cfg.CORS.AllowedOrigins,
+		AllowedMethods: cfg.CORS.AllowedMethods,
+		AllowedHeaders: cfg.CORS.AllowedHeaders,
+		ExposedHeaders: cfg.CORS.ExposedHeaders,
+		MaxAge:         cfg.CORS.MaxAge,
+	}
+	if cfg.Server.Production {
+		log.Println("Running in production mode, CORS is disabled")
+		corsOptions = api.CORSOptions{}
+	}
+
+	quotaDefaults, userQuotas := quotaLimits(cfg.Quota)
+	return []api.Option{
+		api.WithLogger(logger),
+		api.WithCORS(corsOptions),
+		api.WithSecurityHeaders(api.SecurityHeaders{
+			HSTSMaxAge:            cfg.Headers.HSTSMaxAge,
+			HSTSIncludeSubdomains: cfg.Headers.HSTSIncludeSubdomains,
+			ContentSecurityPolicy: cfg.Headers.ContentSecurityPolicy,
+			FrameAncestors:        cfg.Headers.FrameAncestors,
+			ReferrerPolicy:        cfg.Headers.ReferrerPolicy,
+		}),
+		api.WithTLSOptions(api.TLSOptions{
+			MinVersion:   cfg.TLS.MinVersionID(),
+			CipherSuites: cipherSuites,
+			NextProtos:   certs.nextProtos,
+			ClientAuth:   cfg.TLS.ClientAuthType(),
+			ClientCAs:    clientCAs,
+		}),
+		api.WithTrustedProxies(trustedProxies),
+		api.WithDrainDelay(cfg.Server.DrainDelay),
+		api.WithTrashRetention(cfg.Trash.Retention),
+		api.WithVersions(versions.Retention{MaxCount: cfg.Versions.MaxCount, MaxAge: cfg.Versions.MaxAge}),
+		api.WithQuotas(quotaDefaults, userQuotas),
+		api.WithDirSizes(dirsize.Options{
+			Workers:    cfg.DirSizes.Workers,
+			MaxWatched: cfg.DirSizes.MaxWatched,
+			TTL:        cfg.DirSizes.TTL,
+		}),
+		api.WithDiskUsage(cfg.DiskUsage.Workers, cfg.DiskUsage.Timeout),
+		api.WithLimits(api.Limits{
+			MaxRequestBody:    cfg.Limits.MaxRequestBody,
+			MaxUploadSize:     cfg.Limits.MaxUploadSize,
+			MaxHeaderBytes:    cfg.Limits.MaxHeaderBytes,
+			ReadHeaderTimeout: cfg.Limits.ReadHeaderTimeout,
+			IdleTimeout:       cfg.Limits.IdleTimeout,
+		}),
+	}, nil
+}
+
+// run serves until a listener fails or the process receives SIGINT or SIGTERM, then shuts
+// the server down gracefully. It returns the process exit code.
+func run(s *api.Server, cfg *config.Config, certs *certificates, logger *slog.Logger) int {
+	listener, err := newListener(cfg.Server)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go reloadCertificates(workersCtx, certs, cfg.TLS, logger)
+
+	serveErrs := serve(s, cfg, certs, listener)
+
+	exitCode := 0
+	select {
+	case err := <-serveErrs:
+		log.Printf("Server stopped: %v\n", err)
+		exitCode = 1
+	case <-ctx.Done():
+		log.Printf("Shutting down, waiting up to %s for requests to finish\n", cfg.Server.DrainTimeout)
+	}
+	// a second signal terminates immediately
+	stop()
+	stopWorkers()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Graceful shutdown failed: %v\n", err)
+		exitCode = 1
+	}
+	return exitCode
+}
 
-	// Add test users
-	for user, pass := range testUsers {
-		err := authBackend.AddUser(user, pass)
+// newListener opens the main listener: a Unix socket, the socket passed by systemd, or by
+// default TCP on the configured host and port.
+func newListener(cfg config.Server) (net.Listener, error) {
+	switch {
+	case cfg.Listen == config.ListenSystemd:
+		listeners, err := api.SystemdListeners()
 		if err != nil {
-			log.Fatalf("Could not add user: %s\n", err)
+			return nil, err
 		}
+		if len(listeners) != 1 {
+			return nil, fmt.Errorf("expected one socket from systemd, got %d", len(listeners))
+		}
+		return listeners[0], nil
+
+	case strings.HasPrefix(cfg.Listen, config.ListenUnixPrefix):
+		mode, err := cfg.SocketFileMode()
+		if err != nil {
+			return nil, err
+		}
+		return api.ListenUnix(strings.TrimPrefix(cfg.Listen, config.ListenUnixPrefix), mode)
+
+	default:
+		return net.Listen("tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)))
 	}
+}
 
-	s, err := api.NewServer(webassets, baseDir, authBackend)
-	if err != nil {
-		log.Fatalln(err)
+// serve starts the main listener and any other configured listeners in the background.
+// Errors from any of them are sent on the returned channel.
+func serve(s *api.Server, cfg *config.Config, certs *certificates, listener net.Listener) <-chan error {
+	serveErrs := make(chan error, 4)
+	start := func(fn func() error) {
+		go func() {
+			serveErrs <- fn()
+		}()
+	}
+
+	if cfg.Metrics.Listen != "" {
+		log.Printf("Serving metrics on %s\n", cfg.Metrics.Listen)
+		start(func() error { return s.ListenAndServeMetrics(cfg.Metrics.Listen, cfg.Metrics.Token) })
+	}
+	if cfg.Server.RedirectListen != "" {
+		log.Printf("Redirecting HTTP on %s to HTTPS\n", cfg.Server.RedirectListen)
+		start(func() error { return s.ListenAndServeRedirect(cfg.Server.RedirectListen, cfg.Server.Port) })
+	}
+	if cfg.Server.HTTP3 {
+		addr := listener.Addr().String()
+		log.Printf("Serving HTTP/3 on %s\n", addr)
+		start(func() error { return s.ListenAndServeHTTP3(addr, certs.source) })
+	}
+
+	if cfg.Server.Plaintext {
+		log.Printf("Listening on %s without TLS\n", listener.Addr())
+	} else {
+		log.Printf("Listening on %s\n", listener.Addr())
 	}
+	log.Printf("Serving files from %s\n", cfg.Server.Root)
+	start(func() error { return s.Serve(listener, certs.source) })
 
-	log.Printf("Listening on localhost:%d\n", listenPort)
-	log.Printf("Serving files from %s\n", baseDir)
-	log.Fatalln(s.ListenAndServeTLS(fmt.Sprintf("localhost:%d", listenPort), tlsCert))
+	return serveErrs
+}
+
+// newAuthBackend creates the auth backend and provisions the configured users.
+// If no users are configured, the development test users are added instead.
+func newAuthBackend(cfg config.Auth) (*auth.InMemoryBackend, error) {
+	authBackend := auth.NewInMemoryBackend()
+	authBackend.SetSessionLifetime(cfg.SessionLifetime)
+
+	if len(cfg.Users) == 0 {
+		log.Println("No users configured, adding test users")
+		for user, pass := range testUsers {
+			if err := authBackend.AddUser(user, pass); err != nil {
+				return nil, fmt.Errorf("could not add user: %w", err)
+			}
+		}
+		for _, user := range testAdmins {
+			if err := authBackend.SetAdmin(user, true); err != nil {
+				return nil, fmt.Errorf("could not set admin: %w", err)
+			}
+		}
+		return authBackend, nil
+	}
+
+	for _, user := range cfg.Users {
+		if err := authBackend.AddHashedUser(user.Username, user.PasswordHash); err != nil {
+			return nil, fmt.Errorf("could not add user %s: %w", user.Username, err)
+		}
+		if err := authBackend.SetAdmin(user.Username, user.Admin); err != nil {
+			return nil, fmt.Errorf("could not set admin: %w", err)
+		}
+	}
+	return authBackend, nil
+}
+
+func newLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
 }
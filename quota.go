@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/goteleport-interview/fs4/api/config"
+	"github.com/goteleport-interview/fs4/api/handlers"
+	"github.com/goteleport-interview/fs4/api/quota"
+)
+
+// quotaLimits translates the quota configuration into the default and per-user limits.
+func quotaLimits(cfg config.Quota) (quota.Limits, map[string]quota.Limits) {
+	users := make(map[string]quota.Limits, len(cfg.Users))
+	for user, limits := range cfg.Users {
+		users[user] = quota.Limits{Soft: limits.Soft, Hard: limits.Hard}
+	}
+	return quota.Limits{Soft: cfg.Soft, Hard: cfg.Hard}, users
+}
+
+// quotaCommand implements `fs4 quota <subcommand>` and returns the process exit code.
+// Usage is recalculated in place, so the server should not be running, or it will overwrite
+// the result; a running server recalculates at /api/v1/quota/recalculate.
+func quotaCommand(args []string) int {
+	if len(args) == 0 || args[0] != "recalculate" {
+		fmt.Fprintln(os.Stderr, "usage: fs4 quota recalculate [flags]")
+		return 2
+	}
+
+	cfg, err := loadConfig("fs4 quota recalculate", args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	limits, users := quotaLimits(cfg.Quota)
+	file := filepath.Join(cfg.Server.Root, handlers.StateDir, "quota.json")
+	tracker, err := quota.Open(cfg.Server.Root, file, limits, users, slog.Default())
+	if err == nil {
+		var usages map[string]quota.Usage
+		if usages, err = tracker.Recalculate(); err == nil {
+			printUsage(usages)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printUsage writes each user's usage to stdout, sorted by name.
+func printUsage(usages map[string]quota.Usage) {
+	names := make([]string, 0, len(usages))
+	for name := range usages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		usage := usages[name]
+		fmt.Printf("%s: %d bytes in %d files (soft limit %d, hard limit %d)\n", name, usage.Used, usage.Files, usage.Soft, usage.Hard)
+	}
+}
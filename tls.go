@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/goteleport-interview/fs4/api"
+	"github.com/goteleport-interview/fs4/api/config"
+	"github.com/goteleport-interview/fs4/api/tlscert"
+)
+
+// selfSignedRenewInterval is how often the self-signed certificate is checked for renewal.
+const selfSignedRenewInterval = 12 * time.Hour
+
+// certificates is the TLS certificate source for the configured mode.
+type certificates struct {
+	source api.CertificateSource
+	// manager serves certificates loaded from disk, it is nil in acme mode
+	manager *tlscert.Manager
+	// nextProtos are extra ALPN protocols the listener must offer
+	nextProtos []string
+}
+
+// newCertificates sets up the certificate source for cfg.Mode.
+func newCertificates(cfg config.TLS, logger *slog.Logger) (*certificates, error) {
+	switch cfg.Mode {
+	case config.TLSModeFiles:
+		manager, err := tlscert.NewManager(cfg.Cert, cfg.Key, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &certificates{source: manager, manager: manager}, nil
+
+	case config.TLSModeSelfSigned:
+		files, _, err := tlscert.EnsureSelfSigned(cfg.CacheDir, cfg.Hosts, logger)
+		if err != nil {
+			return nil, fmt.Errorf("could not create self-signed certificate: %w", err)
+		}
+		manager, err := tlscert.NewManager(files.Cert, files.Key, logger)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using self-signed certificate, trust %s to avoid certificate warnings\n", files.CACert)
+		return &certificates{source: manager, manager: manager}, nil
+
+	case config.TLSModeACME:
+		manager, err := tlscert.NewACMEManager(tlscert.ACMEOptions{
+			DirectoryURL: cfg.ACME.DirectoryURL,
+			Email:        cfg.ACME.Email,
+			Hosts:        cfg.Hosts,
+			CacheDir:     cfg.CacheDir,
+			CABundle:     cfg.ACME.CABundle,
+			RenewBefore:  cfg.ACME.RenewBefore,
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Obtaining certificates for %v from %s\n", cfg.Hosts, cfg.ACME.DirectoryURL)
+		return &certificates{source: manager, nextProtos: []string{tlscert.ALPNProto}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported TLS mode %q", cfg.Mode)
+	}
+}
+
+// reloadCertificates keeps certificates loaded from disk up to date until ctx is done.
+// The certificate is reloaded on SIGHUP and, if cfg.ReloadInterval is positive, whenever
+// the files change. In self-signed mode the certificate is also reissued before it expires.
+// ACME certificates are renewed by the ACME manager itself.
+func reloadCertificates(ctx context.Context, certs *certificates, cfg config.TLS, logger *slog.Logger) {
+	if certs.manager == nil {
+		return
+	}
+	if cfg.ReloadInterval > 0 {
+		go certs.manager.Watch(ctx, cfg.ReloadInterval)
+	}
+
+	var renew <-chan time.Time
+	if cfg.Mode == config.TLSModeSelfSigned {
+		ticker := time.NewTicker(selfSignedRenewInterval)
+		defer ticker.Stop()
+		renew = ticker.C
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := certs.manager.Reload(); err != nil {
+				log.Printf("Failed to reload TLS certificate, keeping previous: %v\n", err)
+			}
+		case <-renew:
+			renewSelfSigned(certs, cfg, logger)
+		}
+	}
+}
+
+// renewSelfSigned reissues the self-signed certificate if it is close to expiry and loads it.
+func renewSelfSigned(certs *certificates, cfg config.TLS, logger *slog.Logger) {
+	_, issued, err := tlscert.EnsureSelfSigned(cfg.CacheDir, cfg.Hosts, logger)
+	if err != nil {
+		log.Printf("Failed to renew self-signed certificate: %v\n", err)
+		return
+	}
+	if !issued {
+		return
+	}
+	if err := certs.manager.Reload(); err != nil {
+		log.Printf("Failed to reload TLS certificate, keeping previous: %v\n", err)
+	}
+}
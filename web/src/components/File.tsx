@@ -1,9 +1,10 @@
-import React from 'react';
+import React, { useState } from 'react';
 
 import { Link, useSearchParams } from 'react-router-dom';
 
 import { DocumentIcon, FolderIcon } from '@heroicons/react/24/outline';
 
+import { API_ENDPOINTS, API_URL } from '../lib/constants';
 import {
   bytesToHumanReadable,
   dateToHumanReadable,
@@ -12,18 +13,43 @@ import {
 
 import type { FileOrDir } from '../types';
 
-const FileInner = ({ item }: { item: FileOrDir }) => {
+const THUMBNAIL_EXTENSIONS = ['jpg', 'jpeg', 'png', 'gif', 'webp'];
+
+const hasThumbnail = (filename: string) =>
+  THUMBNAIL_EXTENSIONS.includes(filename.split('.').pop()?.toLowerCase() ?? '');
+
+const FileIcon = ({ item, path }: { item: FileOrDir; path: string }) => {
+  const [failed, setFailed] = useState(false);
+
+  if (item.type !== 'file') {
+    return <FolderIcon className="hidden h-5 w-5 flex-shrink-0 md:block" />;
+  }
+  if (failed || !hasThumbnail(item.name)) {
+    return <DocumentIcon className="hidden h-5 w-5 flex-shrink-0 md:block" />;
+  }
+
+  const filePath = path === '/' ? `/${item.name}` : `${path}/${item.name}`;
+  const params = new URLSearchParams({ path: filePath, size: '64' });
+
+  return (
+    <img
+      alt=""
+      className="hidden h-8 w-8 flex-shrink-0 rounded object-cover md:block"
+      loading="lazy"
+      onError={() => setFailed(true)}
+      src={`${API_URL}/${API_ENDPOINTS.THUMBNAIL}?${params.toString()}`}
+    />
+  );
+};
+
+const FileInner = ({ item, path }: { item: FileOrDir; path: string }) => {
   return (
     <>
       <div
         className="flex w-1/2 flex-shrink-0 flex-row items-center justify-start gap-x-4 text-left"
         title={item.name}
       >
-        {item.type === 'file' ? (
-          <DocumentIcon className="hidden h-5 w-5 flex-shrink-0 md:block" />
-        ) : (
-          <FolderIcon className="hidden h-5 w-5 flex-shrink-0 md:block" />
-        )}
+        <FileIcon item={item} path={path} />
         <span className="line-clamp-2 block w-full overflow-ellipsis break-normal">
           {item.name}
         </span>
@@ -50,7 +76,7 @@ const File = ({ item, path }: { item: FileOrDir; path: string }) => {
         className="mx-4 flex flex-row items-center justify-start gap-x-2 rounded-lg px-6 py-4 text-sm text-zinc-300 transition-colors duration-75 ease-out hover:bg-zinc-800 hover:text-zinc-50 focus-visible:bg-zinc-800 focus-visible:text-zinc-50"
         role="listitem"
       >
-        <FileInner item={item} />
+        <FileInner item={item} path={path} />
       </li>
     );
   }
@@ -64,7 +90,7 @@ const File = ({ item, path }: { item: FileOrDir; path: string }) => {
         className="mx-4 flex flex-row items-center justify-start gap-x-2 rounded-lg px-6 py-4 text-sm text-zinc-200 ring-lime-300 transition-colors duration-100 hover:bg-zinc-800 hover:text-zinc-50 focus:outline-none focus-visible:bg-zinc-800 focus-visible:text-zinc-50 focus-visible:ring-2"
         to={`/browse${slug}${searchParamsStr?.length ? `?${searchParamsStr}` : ''}`}
       >
-        <FileInner item={item} />
+        <FileInner item={item} path={path} />
       </Link>
     </li>
   );
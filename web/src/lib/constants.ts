@@ -7,6 +7,7 @@ export const API_ENDPOINTS = {
   LOGOUT: 'auth/logout',
   ME: 'auth/me',
   FILES: 'files',
+  THUMBNAIL: 'thumbnail',
 } as const;
 
 export const API_ERRORS = {
@@ -16,6 +16,7 @@ export type APIError = {
   title: string;
   // eslint-disable-next-line @typescript-eslint/ban-types
   detail?: (typeof API_ERRORS)[keyof typeof API_ERRORS] | (string & {});
+  requestId?: string;
 };
 
 export type UserSession = {
@@ -29,6 +30,21 @@ export type FileOrDir = {
   size: number; // Bytes
   modified: string; // Date string
   contents?: FileOrDir[];
+} & FileDetails;
+
+// Optional details, included when requested with the `fields` of a files request.
+export type FileDetails = {
+  mimeType?: string;
+  mode?: string; // e.g. -rw-r--r--
+  owner?: string;
+  group?: string;
+  target?: string; // Symlink target from the root
+  hidden?: boolean;
+  width?: number; // Pixels
+  height?: number;
+  taken?: string; // Date string
+  pages?: number;
+  checksums?: Record<string, string>; // Hex, by algorithm
 };
 
 export type SortType = 'name' | 'modified' | 'type' | 'size';